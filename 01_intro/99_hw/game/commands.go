@@ -2,29 +2,461 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
+type CommandHandler func(user *User, args ...string) string
+
 type Command struct {
 	CommandName  string
-	ArgsNum      int
+	Aliases      []string
+	ArgsNum      int  // минимальное количество аргументов
+	VarArgs      bool // можно передать больше аргументов, чем ArgsNum
+	Meta         bool // служебная команда: не считается ходом и доступна после окончания игры
+	RawArgs      bool // аргументы - произвольный текст, их не надо переводить в слова мира
+	Usage        string
+	Description  string
 	ErrorMessage string
+
+	// дополнительная проверка аргументов, если их количество может быть разным
+	ArgsCheck func(args []string) bool
+
+	Handler CommandHandler
 }
 
 func (command *Command) Error() string {
 	return command.ErrorMessage
 }
 
-var (
-	CommandLookAround = Command{CommandName: "осмотреться", ArgsNum: 0}
-	CommandWalk       = Command{CommandName: "идти", ArgsNum: 1}
-	CommandWear       = Command{CommandName: "надеть", ArgsNum: 1}
-	CommandTake       = Command{CommandName: "взять", ArgsNum: 1}
-	CommandApply      = Command{CommandName: "применить", ArgsNum: 2}
+// CheckArgs проверяет количество аргументов по спецификации команды
+func (command *Command) CheckArgs(args []string) error {
+	if len(args) < command.ArgsNum || (!command.VarArgs && len(args) > command.ArgsNum) ||
+		(command.ArgsCheck != nil && !command.ArgsCheck(args)) {
+		return fmt.Errorf("%w: %s", errInvalidCommand, command.UsageLine())
+	}
+
+	return nil
+}
 
-	UnknownCommand = Command{CommandName: "неизвестная команда", ArgsNum: 0}
+func (command *Command) UsageLine() string {
+	if command.Usage == "" {
+		return command.CommandName
+	}
+
+	return command.CommandName + " " + command.Usage
+}
+
+var (
+	CommandLookAround = &Command{
+		CommandName: "осмотреться",
+		Aliases:     []string{"оглядеться"},
+		Description: "описание комнаты, в которой вы находитесь",
+		Handler:     handleLookAround,
+	}
+	CommandWalk = &Command{
+		CommandName: "идти",
+		Aliases:     []string{"пойти"},
+		ArgsNum:     1,
+		Usage:       "<комната>",
+		Description: "перейти в соседнюю комнату",
+		Handler:     handleWalk,
+	}
+	CommandWear = &Command{
+		CommandName: "надеть",
+		ArgsNum:     1,
+		Usage:       "<предмет>",
+		Description: "надеть предмет",
+		Handler:     handleWear,
+	}
+	CommandTake = &Command{
+		CommandName: "взять",
+		Aliases:     []string{"подобрать"},
+		ArgsNum:     1,
+		Usage:       "<предмет>",
+		Description: "положить предмет из комнаты в инвентарь",
+		Handler:     handleTake,
+	}
+	CommandPut = &Command{
+		CommandName: "положить",
+		ArgsNum:     1,
+		VarArgs:     true,
+		Usage:       "<предмет> [в <контейнер>]",
+		Description: "положить предмет из инвентаря на его место в комнате или в контейнер",
+		ArgsCheck: func(args []string) bool {
+			return len(args) == 1 || (len(args) == 3 && args[1] == intoWord)
+		},
+		Handler: handlePut,
+	}
+	CommandDrop = &Command{
+		CommandName: "выбросить",
+		ArgsNum:     1,
+		Usage:       "<предмет>",
+		Description: "выбросить предмет из инвентаря на пол",
+		Handler:     handleDrop,
+	}
+	CommandOpen = &Command{
+		CommandName: "открыть",
+		ArgsNum:     1,
+		Usage:       "<контейнер>",
+		Description: "открыть контейнер",
+		Handler:     handleOpen,
+	}
+	CommandClose = &Command{
+		CommandName: "закрыть",
+		ArgsNum:     1,
+		Usage:       "<контейнер>",
+		Description: "закрыть контейнер",
+		Handler:     handleClose,
+	}
+	CommandInventory = &Command{
+		CommandName: "инвентарь",
+		Description: "список предметов в инвентаре",
+		Handler:     handleInventory,
+	}
+	CommandApply = &Command{
+		CommandName: "применить",
+		ArgsNum:     2,
+		Usage:       "<предмет> <к чему>",
+		Description: "применить предмет из инвентаря",
+		Handler:     handleApply,
+	}
+	CommandSay = &Command{
+		CommandName: "сказать",
+		VarArgs:     true,
+		RawArgs:     true,
+		Usage:       "<текст>",
+		Description: "сказать всем игрокам в комнате",
+		Handler:     handleSay,
+	}
+	CommandSayPlayer = &Command{
+		CommandName: "сказать_игроку",
+		ArgsNum:     1,
+		VarArgs:     true,
+		RawArgs:     true,
+		Usage:       "<игрок> <текст>",
+		Description: "сказать игроку в комнате так, чтобы слышал только он",
+		Handler:     handleSayPlayer,
+	}
+	CommandGoals = &Command{
+		CommandName: "задания",
+		Meta:        true,
+		Description: "список заданий, очки и количество ходов",
+		Handler:     handleGoals,
+	}
+	CommandSave = &Command{
+		CommandName: "сохранить",
+		Meta:        true,
+		RawArgs:     true,
+		ArgsNum:     1,
+		Usage:       "<имя>",
+		Description: "сохранить игру",
+		Handler:     handleSave,
+	}
+	CommandLoad = &Command{
+		CommandName: "загрузить",
+		Meta:        true,
+		RawArgs:     true,
+		ArgsNum:     1,
+		Usage:       "<имя>",
+		Description: "загрузить сохранённую игру",
+		Handler:     handleLoad,
+	}
+	CommandHelp = &Command{
+		CommandName: "помощь",
+		Aliases:     []string{"команды"},
+		Meta:        true,
+		Description: "список команд",
+		Handler:     handleHelp,
+	}
+	CommandLanguage = &Command{
+		CommandName: "язык",
+		Meta:        true,
+		RawArgs:     true,
+		ArgsNum:     1,
+		Usage:       "<язык>",
+		Description: "сменить язык игры",
+		Handler:     handleLanguage,
+	}
 )
 
 var (
 	errInvalidCommand = errors.New("ошибка формата команды")
-	errItemNotInRoom  = errors.New("нет такого")
+	errCommandExists  = errors.New("команда с таким именем уже есть")
+	errNoHandler      = errors.New("у команды нет обработчика")
 )
+
+// предлог в команде "положить X в Y"
+const intoWord = "в"
+
+// максимальное расстояние Левенштейна, при котором команда предлагается как исправление опечатки
+const maxSuggestDistance = 2
+
+// CommandRegistry хранит команды в порядке регистрации и ищет их по имени или синониму
+type CommandRegistry struct {
+	commands []*Command
+	byName   map[string]*Command
+}
+
+func NewCommandRegistry(commands ...*Command) (*CommandRegistry, error) {
+	registry := &CommandRegistry{
+		byName: make(map[string]*Command),
+	}
+
+	for _, command := range commands {
+		if err := registry.Register(command); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+func NewDefaultCommands() *CommandRegistry {
+	registry, err := NewCommandRegistry(
+		CommandLookAround,
+		CommandWalk,
+		CommandWear,
+		CommandTake,
+		CommandPut,
+		CommandDrop,
+		CommandOpen,
+		CommandClose,
+		CommandInventory,
+		CommandApply,
+		CommandSay,
+		CommandSayPlayer,
+		CommandGoals,
+		CommandSave,
+		CommandLoad,
+		CommandHelp,
+		CommandLanguage,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}
+
+func (registry *CommandRegistry) Register(command *Command) error {
+	if command.Handler == nil {
+		return fmt.Errorf("%s: %w", command.CommandName, errNoHandler)
+	}
+
+	names := append([]string{command.CommandName}, command.Aliases...)
+	for _, name := range names {
+		if _, exist := registry.byName[name]; exist {
+			return fmt.Errorf("%s: %w", name, errCommandExists)
+		}
+	}
+
+	for _, name := range names {
+		registry.byName[name] = command
+	}
+	registry.commands = append(registry.commands, command)
+
+	return nil
+}
+
+func (registry *CommandRegistry) Lookup(name string) (*Command, bool) {
+	command, exist := registry.byName[name]
+	return command, exist
+}
+
+// LookupLocalized ищет команду по имени на языке игрока, исходные имена команд тоже работают
+func (registry *CommandRegistry) LookupLocalized(locale *Locale, name string) (*Command, bool) {
+	if canonical, exist := locale.commandNames[name]; exist {
+		name = canonical
+	}
+
+	return registry.Lookup(name)
+}
+
+// Dispatch находит команду, проверяет аргументы и вызывает её обработчик
+func (registry *CommandRegistry) Dispatch(user *User, name string, args ...string) (string, error) {
+	command, exist := registry.LookupLocalized(user.Locale, name)
+	if !exist {
+		return registry.unknownCommand(user, name), nil
+	}
+
+	if !command.RawArgs {
+		for i := range args {
+			args[i] = user.Locale.Canonical(args[i])
+		}
+	}
+
+	if err := command.CheckArgs(args); err != nil {
+		return "", &LocalizedError{
+			Err:     err,
+			Message: user.Text(msgInvalidCommand, Params{"Usage": user.Locale.UsageLine(command)}),
+		}
+	}
+
+	if command.Meta {
+		return command.Handler(user, args...), nil
+	}
+
+	if user.State != StatePlaying {
+		return user.Text(msgGameOver, nil), nil
+	}
+
+	user.Moves++
+	result := command.Handler(user, args...)
+	user.UpdateGoals()
+
+	return result, nil
+}
+
+func (registry *CommandRegistry) unknownCommand(user *User, name string) string {
+	suggestions := registry.Suggest(user.Locale, name)
+	if len(suggestions) == 0 {
+		return user.Text(msgUnknownCommand, nil)
+	}
+
+	return user.Text(msgDidYouMean, Params{"Commands": suggestions})
+}
+
+// Suggest возвращает команды, имя которых на языке locale отличается от name на пару символов
+func (registry *CommandRegistry) Suggest(locale *Locale, name string) []string {
+	if name == "" {
+		return nil
+	}
+
+	var suggestions []string
+	for _, command := range registry.commands {
+		for _, candidate := range locale.commandNamesOf(command) {
+			distance := levenshtein(name, candidate)
+			if distance <= maxSuggestDistance && distance < len([]rune(candidate)) {
+				suggestions = append(suggestions, locale.CommandName(command))
+				break
+			}
+		}
+	}
+
+	return suggestions
+}
+
+func (registry *CommandRegistry) Help(locale *Locale) string {
+	var builder strings.Builder
+
+	builder.WriteString(locale.Text(msgHelp, nil))
+	for _, command := range registry.commands {
+		builder.WriteString("\n" + locale.UsageLine(command))
+		if description := locale.CommandDescription(command); description != "" {
+			builder.WriteString(" - " + description)
+		}
+		if aliases := locale.CommandAliases(command); len(aliases) > 0 {
+			builder.WriteString(locale.Text(msgHelpAliases, Params{"Aliases": aliases}))
+		}
+	}
+
+	return builder.String()
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	result := first
+	for _, value := range rest {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}
+
+func handleLookAround(user *User, args ...string) string {
+	return user.HandleLookAround(args...)
+}
+
+func handleWalk(user *User, args ...string) string {
+	return user.HandleWalk(args[0], args[1:]...)
+}
+
+func handleWear(user *User, args ...string) string {
+	return user.HandleTakeItem(args[0], true)
+}
+
+func handleTake(user *User, args ...string) string {
+	return user.HandleTakeItem(args[0], false)
+}
+
+func handlePut(user *User, args ...string) string {
+	if len(args) == 3 {
+		return user.HandlePutInto(args[0], args[2])
+	}
+
+	return user.HandlePutItem(args[0], "")
+}
+
+func handleDrop(user *User, args ...string) string {
+	return user.HandlePutItem(args[0], dropPosition)
+}
+
+func handleOpen(user *User, args ...string) string {
+	return user.HandleOpen(args[0], true)
+}
+
+func handleClose(user *User, args ...string) string {
+	return user.HandleOpen(args[0], false)
+}
+
+func handleInventory(user *User, _ ...string) string {
+	return user.HandleInventory()
+}
+
+func handleApply(user *User, args ...string) string {
+	return user.HandleApply(args[0], args[1], args[2:]...)
+}
+
+func handleSay(user *User, args ...string) string {
+	return user.HandleSay(args...)
+}
+
+func handleSayPlayer(user *User, args ...string) string {
+	return user.HandleSayPlayer(args[0], args[1:]...)
+}
+
+func handleGoals(user *User, _ ...string) string {
+	return user.HandleGoals()
+}
+
+func handleSave(user *User, args ...string) string {
+	return user.HandleSave(args[0])
+}
+
+func handleLoad(user *User, args ...string) string {
+	return user.HandleLoad(args[0])
+}
+
+func handleHelp(user *User, _ ...string) string {
+	return user.game.Commands.Help(user.Locale)
+}
+
+func handleLanguage(user *User, args ...string) string {
+	return user.HandleLanguage(args[0])
+}
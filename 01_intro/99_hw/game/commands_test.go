@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	p := players[0]
+
+	steps := []gameCase{
+		{1, "оглядеться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор"},
+		{2, "идт коридор", "неизвестная команда, возможно вы имели в виду: идти"},
+		{3, "завтракать", "неизвестная команда"},
+		{4, "пойти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{5, "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{6, "инвентарь", "инвентарь пуст"},
+		{7, "надеть рюкзак", "вы надели: рюкзак"},
+		{8, "взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{9, "подобрать конспекты", "предмет добавлен в инвентарь: конспекты"},
+		{10, "инвентарь", "в инвентаре: рюкзак (ключи, конспекты)"},
+		{11, "выбросить рюкзак", "вы выбросили: рюкзак"},
+		{12, "осмотреться", "на полу: рюкзак (ключи, конспекты). можно пройти - коридор"},
+		{13, "взять ключи", "некуда класть"},
+		{14, "положить ключи", "нет предмета в инвентаре - ключи"},
+		{15, "выбросить телефон", "нет предмета в инвентаре - телефон"},
+		{16, "надеть рюкзак", "вы надели: рюкзак"},
+		{17, "положить ключи", "вы положили: ключи"},
+		{18, "осмотреться", "на столе: ключи. можно пройти - коридор"},
+	}
+
+	for _, step := range steps {
+		answer, err := testGame.HandleCommand(p, step.command)
+		if err != nil {
+			t.Error("step", step.step, "unexpected error:", err)
+		}
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+
+	for _, command := range []string{"идти", "идти коридор кухня", "применить ключи", "осмотреться вокруг"} {
+		_, err := testGame.HandleCommand(players[0], command)
+		if !errors.Is(err, errInvalidCommand) {
+			t.Errorf("%s: expected errInvalidCommand, got %v", command, err)
+		}
+	}
+
+	if _, err := testGame.HandleCommand(players[0], "сказать привет всем"); err != nil {
+		t.Error("say must accept any number of words, got", err)
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+
+	err := testGame.Commands.Register(&Command{
+		CommandName: "прыгнуть",
+		Aliases:     []string{"подпрыгнуть"},
+		Description: "прыгнуть на месте",
+		Handler: func(user *User, _ ...string) string {
+			return user.Name + " прыгает"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if answer, _ := testGame.HandleCommand(players[0], "подпрыгнуть"); answer != "Tristan прыгает" { //nolint:errcheck
+		t.Error("unexpected answer:", answer)
+	}
+
+	help, _ := testGame.HandleCommand(players[0], "помощь") //nolint:errcheck
+	if !strings.Contains(help, "\nпрыгнуть - прыгнуть на месте (также: подпрыгнуть)") {
+		t.Error("help does not list the new command:\n", help)
+	}
+	if !strings.Contains(help, "\nприменить <предмет> <к чему> - ") {
+		t.Error("help does not list command usage:\n", help)
+	}
+
+	if err := testGame.Commands.Register(&Command{CommandName: "идти", Handler: handleWalk}); !errors.Is(err, errCommandExists) {
+		t.Error("expected errCommandExists, got", err)
+	}
+	if err := testGame.Commands.Register(&Command{CommandName: "спать"}); !errors.Is(err, errNoHandler) {
+		t.Error("expected errNoHandler, got", err)
+	}
+}
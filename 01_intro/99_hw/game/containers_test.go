@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const containersWorld = `{
+	"start": "кухня",
+	"containers_only": true,
+	"items": [
+		{"name": "сумка", "position": "на стуле", "capacity": 1},
+		{"name": "шкаф", "position": "у стены", "capacity": 2, "closable": true, "closed": true, "contents": ["шкатулка"]},
+		{"name": "шкатулка", "capacity": 1, "closable": true, "contents": ["кольцо"]},
+		{"name": "кольцо"},
+		{"name": "ложка", "position": "на столе"}
+	],
+	"rooms": [
+		{
+			"name": "кухня",
+			"description": "кухня, ",
+			"walk_description": "кухня",
+			"items": [{"item": "сумка"}, {"item": "шкаф"}, {"item": "ложка"}],
+			"connections": [{"room": "кладовка"}]
+		},
+		{
+			"name": "кладовка",
+			"walk_description": "кладовка",
+			"connections": [{"room": "кухня"}]
+		}
+	]
+}`
+
+func TestContainers(t *testing.T) {
+	world, err := ParseWorld([]byte(containersWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame := NewGame(world)
+	p, _ := testGame.AddPlayer("Tristan") //nolint:errcheck
+
+	// формы слов тестового мира добавляются в отдельную копию каталога
+	loaded, err := LoadLocales(langFiles, "lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Locale = loaded[defaultLanguage]
+	p.Locale.Words["шкатулка"] = Word{Text: "шкатулка", Gender: "ж", Forms: map[string]string{"вин": "шкатулку", "пред": "шкатулке"}}
+	p.Locale.Words["ложка"] = Word{Text: "ложка", Gender: "ж", Forms: map[string]string{"вин": "ложку"}}
+
+	steps := []gameCase{
+		{1, "осмотреться", "кухня, на стуле: сумка, у стены: шкаф, на столе: ложка. можно пройти - кладовка"},
+		{2, "взять кольцо", "нет такого"}, // шкаф закрыт
+		{3, "взять ложка", "некуда класть"},
+		{4, "открыть шкаф", "вы открыли шкаф"},
+		{5, "открыть шкаф", "шкаф уже открыт"},
+		{6, "осмотреться", "кухня, на стуле: сумка, у стены: шкаф (шкатулка (кольцо)), на столе: ложка. можно пройти - кладовка"},
+		{7, "открыть ложка", "ложку нельзя открыть"},
+		{8, "взять сумка", "предмет добавлен в инвентарь: сумка"},
+		{9, "взять кольцо", "предмет добавлен в инвентарь: кольцо"},
+		{10, "взять ложка", "некуда класть"}, // сумка полная
+		{11, "положить кольцо в шкатулка", "вы положили кольцо в шкатулку"},
+		{12, "взять ложка", "предмет добавлен в инвентарь: ложка"},
+		{13, "положить ложка в шкатулка", "в шкатулке нет места"},
+		{14, "закрыть шкатулка", "вы закрыли шкатулку"},
+		{15, "закрыть шкатулка", "шкатулка уже закрыта"},
+		{16, "положить ложка в шкатулка", "сначала откройте шкатулку"},
+		{17, "взять шкатулка", "предмет добавлен в инвентарь: шкатулка"},
+		{18, "положить ложка в кольцо", "нет такого"}, // кольцо в закрытой шкатулке
+		{19, "открыть шкатулка", "вы открыли шкатулку"},
+		{20, "положить шкатулка в шкатулка", "нельзя положить предмет в самого себя"},
+		{21, "положить сумка в ложка", "в ложку ничего не положить"},
+		{22, "инвентарь", "в инвентаре: сумка (ложка), шкатулка (кольцо)"},
+		{23, "выбросить шкатулка", "вы выбросили: шкатулка"},
+		{24, "положить ложка в шкаф", "вы положили ложку в шкаф"},
+		{25, "осмотреться", "кухня, у стены: шкаф (ложка), на полу: шкатулка (кольцо). можно пройти - кладовка"},
+	}
+
+	for _, step := range steps {
+		answer, err := testGame.HandleCommand(p, step.command)
+		if err != nil {
+			t.Error("step", step.step, "unexpected error:", err)
+		}
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+
+	if _, err := testGame.HandleCommand(p, "положить ложка на шкаф"); !errors.Is(err, errInvalidCommand) {
+		t.Error("expected errInvalidCommand, got", err)
+	}
+}
+
+func TestContainersSave(t *testing.T) {
+	world, err := ParseWorld([]byte(containersWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame := NewGame(world)
+	testGame.SaveDir = t.TempDir()
+	p, _ := testGame.AddPlayer("Tristan") //nolint:errcheck
+
+	for _, command := range []string{"открыть шкаф", "взять шкатулка", "закрыть шкатулка", "сохранить s", "открыть шкатулка", "загрузить s"} {
+		testGame.HandleCommand(p, command) //nolint:errcheck
+	}
+
+	if answer, _ := testGame.HandleCommand(p, "взять кольцо"); answer != "нет такого" { //nolint:errcheck
+		t.Error("closed box must be restored closed, got:", answer)
+	}
+	if !p.HasItem("кольцо") {
+		t.Error("ring must be restored inside the box")
+	}
+	if answer, _ := testGame.HandleCommand(p, "инвентарь"); answer != "в инвентаре: шкатулка" { //nolint:errcheck
+		t.Error("unexpected inventory:", answer)
+	}
+}
+
+// предметы, которые эффекты отдают игроку, кладутся как при "взять"
+const containerEffectsWorld = `{
+	"start": "кухня",
+	"containers_only": true,
+	"items": [
+		{"name": "сумка", "position": "на стуле", "capacity": 2},
+		{
+			"name": "ключ",
+			"position": "на столе",
+			"can_apply": {
+				"сейф": {"result": "сейф открыт", "effects": [{"type": "spawn_item", "item": "монета"}]},
+				"шкаф": {"result": "шкаф открыт", "effects": [{"type": "move_item", "item": "ложка"}]}
+			}
+		},
+		{"name": "монета"},
+		{"name": "ложка", "position": "на столе"}
+	],
+	"rooms": [
+		{
+			"name": "кухня",
+			"description": "кухня, ",
+			"walk_description": "кухня",
+			"items": [{"item": "сумка"}, {"item": "ключ"}, {"item": "ложка"}],
+			"connections": [{"room": "кладовка"}]
+		},
+		{
+			"name": "кладовка",
+			"walk_description": "кладовка",
+			"connections": [{"room": "кухня"}]
+		}
+	]
+}`
+
+func TestContainersEffects(t *testing.T) {
+	world, err := ParseWorld([]byte(containerEffectsWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame := NewGame(world)
+	p, _ := testGame.AddPlayer("Tristan") //nolint:errcheck
+
+	steps := []gameCase{
+		{1, "взять сумка", "предмет добавлен в инвентарь: сумка"},
+		{2, "взять ключ", "предмет добавлен в инвентарь: ключ"},
+		{3, "применить ключ сейф", "сейф открыт"},
+		{4, "инвентарь", "в инвентаре: сумка (ключ, монета)"},
+		{5, "применить ключ шкаф", "шкаф открыт"}, // в сумке нет места
+		{6, "осмотреться", "кухня, на полу: ложка. можно пройти - кладовка"},
+	}
+
+	for _, step := range steps {
+		answer, _ := testGame.HandleCommand(p, step.command) //nolint:errcheck
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	errUnknownCondition  = errors.New("неизвестное условие")
+	errUnknownEffect     = errors.New("неизвестный эффект")
+	errUnknownConnection = errors.New("нет прохода")
+	errUnknownOutcome    = errors.New("неизвестный исход игры")
+)
+
+const (
+	ConditionHasItem    = "has_item"
+	ConditionInRoom     = "in_room"
+	ConditionDoorOpen   = "door_open"
+	ConditionItemInRoom = "item_in_room"
+
+	EffectOpen           = "open"
+	EffectClose          = "close"
+	EffectMoveItem       = "move_item"
+	EffectSpawnItem      = "spawn_item"
+	EffectSetDescription = "set_description"
+	EffectEndGame        = "end_game"
+
+	OutcomeWin  = "win"
+	OutcomeLose = "lose"
+)
+
+// Condition - условие срабатывания реакции, Not инвертирует результат
+type Condition struct {
+	Type string `json:"type"`
+	Not  bool   `json:"not,omitempty"`
+	Item string `json:"item,omitempty"`
+	Room string `json:"room,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Effect - изменение мира; для дверей пустой From означает комнату игрока, пустой To - все проходы из комнаты
+type Effect struct {
+	Type            string `json:"type"`
+	From            string `json:"from,omitempty"`
+	To              string `json:"to,omitempty"`
+	Item            string `json:"item,omitempty"`
+	Room            string `json:"room,omitempty"`
+	Position        string `json:"position,omitempty"`
+	Description     string `json:"description,omitempty"`
+	WalkDescription string `json:"walk_description,omitempty"`
+	Outcome         string `json:"outcome,omitempty"`
+}
+
+// Reaction описывает, что происходит при применении предмета к цели
+type Reaction struct {
+	Result     string      `json:"result"`
+	Fail       string      `json:"fail,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Effects    []Effect    `json:"effects,omitempty"`
+}
+
+var _ json.Unmarshaler = new(Reaction)
+
+// UnmarshalJSON позволяет задать реакцию без условий и эффектов просто строкой
+func (reaction *Reaction) UnmarshalJSON(data []byte) error {
+	var result string
+	if err := json.Unmarshal(data, &result); err == nil {
+		*reaction = Reaction{Result: result}
+		return nil
+	}
+
+	type plainReaction Reaction
+	return json.Unmarshal(data, (*plainReaction)(reaction))
+}
+
+func (reaction *Reaction) Check(user *User) bool {
+	for i := range reaction.Conditions {
+		if !reaction.Conditions[i].Check(user) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Do проверяет условия и применяет эффекты, возвращая ответ игроку
+func (reaction *Reaction) Do(user *User) string {
+	if !reaction.Check(user) {
+		if reaction.Fail == "" {
+			return user.Text(msgNothingHappened, nil)
+		}
+		return user.Locale.Word(reaction.Fail)
+	}
+
+	for i := range reaction.Effects {
+		reaction.Effects[i].Apply(user)
+	}
+
+	return user.Locale.Word(reaction.Result)
+}
+
+var conditionCheckers = map[string]func(condition *Condition, user *User) bool{
+	ConditionHasItem: func(condition *Condition, user *User) bool {
+		return user.HasItem(condition.Item)
+	},
+	ConditionInRoom: func(condition *Condition, user *User) bool {
+		return user.Position.Name == condition.Room
+	},
+	ConditionDoorOpen: func(condition *Condition, user *User) bool {
+		from, to := user.game.Map.Rooms[condition.From], user.game.Map.Rooms[condition.To]
+		return from != nil && from.ConnectionsSet[to]
+	},
+	ConditionItemInRoom: func(condition *Condition, user *User) bool {
+		room := user.game.Map.Rooms[condition.Room]
+		return room != nil && room.FindItem(condition.Item) != nil
+	},
+}
+
+func (condition *Condition) Check(user *User) bool {
+	checker, exist := conditionCheckers[condition.Type]
+	if !exist {
+		return false
+	}
+
+	return checker(condition, user) != condition.Not
+}
+
+var effectAppliers = map[string]func(effect *Effect, user *User){
+	EffectOpen: func(effect *Effect, user *User) {
+		setDoors(effect, user, true)
+	},
+	EffectClose: func(effect *Effect, user *User) {
+		setDoors(effect, user, false)
+	},
+	EffectMoveItem: func(effect *Effect, user *User) {
+		item := user.game.Map.Items[effect.Item]
+		if !user.game.RemoveItem(item) {
+			return
+		}
+		placeItem(effect, user, item)
+	},
+	EffectSpawnItem: func(effect *Effect, user *User) {
+		item := user.game.Map.Items[effect.Item]
+		if item == nil || user.game.ItemPlaced(item) {
+			return
+		}
+		placeItem(effect, user, item)
+	},
+	EffectSetDescription: func(effect *Effect, user *User) {
+		room := user.game.Map.Rooms[effect.Room]
+		if room == nil {
+			room = user.Position
+		}
+		if effect.Description != "" {
+			room.Description = effect.Description
+		}
+		if effect.WalkDescription != "" {
+			room.WalkDescription = effect.WalkDescription
+		}
+	},
+	EffectEndGame: func(effect *Effect, user *User) {
+		if effect.Outcome == OutcomeLose {
+			user.State = StateLost
+		} else {
+			user.State = StateWon
+		}
+	},
+}
+
+func (effect *Effect) Apply(user *User) {
+	if applier, exist := effectAppliers[effect.Type]; exist {
+		applier(effect, user)
+	}
+}
+
+func setDoors(effect *Effect, user *User, open bool) {
+	from := user.Position
+	if effect.From != "" {
+		from = user.game.Map.Rooms[effect.From]
+	}
+	if from == nil {
+		return
+	}
+
+	for _, next := range from.Connections {
+		if effect.To == "" || next.Name == effect.To {
+			from.ConnectionsSet[next] = open
+		}
+	}
+}
+
+// placeItem кладёт предмет в комнату эффекта, а если комната не задана - игроку, как при "взять";
+// если игроку класть некуда, предмет падает на пол его комнаты
+func placeItem(effect *Effect, user *User, item *Item) {
+	if effect.Room == "" {
+		if user.stow(item) {
+			return
+		}
+		user.Position.Items = append(user.Position.Items, &RoomTarget{
+			Item:     item,
+			Position: dropPosition,
+		})
+		return
+	}
+
+	room := user.game.Map.Rooms[effect.Room]
+	room.Items = append(room.Items, &RoomTarget{
+		Item:     item,
+		Position: effect.Position,
+	})
+}
+
+func (condition *Condition) validate(rooms map[string][]string, items map[string]struct{}) error {
+	switch condition.Type {
+	case ConditionHasItem:
+		return checkItem(items, condition.Item)
+	case ConditionInRoom:
+		return checkRoom(rooms, condition.Room)
+	case ConditionDoorOpen:
+		return checkConnection(rooms, condition.From, condition.To)
+	case ConditionItemInRoom:
+		return errors.Join(checkRoom(rooms, condition.Room), checkItem(items, condition.Item))
+	}
+
+	return fmt.Errorf("%w %q", errUnknownCondition, condition.Type)
+}
+
+func (effect *Effect) validate(rooms map[string][]string, items map[string]struct{}) error {
+	switch effect.Type {
+	case EffectOpen, EffectClose:
+		if effect.From == "" {
+			return nil
+		}
+		if effect.To == "" {
+			return checkRoom(rooms, effect.From)
+		}
+		return checkConnection(rooms, effect.From, effect.To)
+	case EffectMoveItem, EffectSpawnItem:
+		err := checkItem(items, effect.Item)
+		if effect.Room != "" {
+			err = errors.Join(err, checkRoom(rooms, effect.Room))
+		}
+		return err
+	case EffectSetDescription:
+		if effect.Room == "" {
+			return nil
+		}
+		return checkRoom(rooms, effect.Room)
+	case EffectEndGame:
+		if effect.Outcome != OutcomeWin && effect.Outcome != OutcomeLose {
+			return fmt.Errorf("%w %q", errUnknownOutcome, effect.Outcome)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w %q", errUnknownEffect, effect.Type)
+}
+
+func (reaction *Reaction) validate(rooms map[string][]string, items map[string]struct{}) error {
+	var errs []error
+
+	for i := range reaction.Conditions {
+		errs = append(errs, reaction.Conditions[i].validate(rooms, items))
+	}
+	for i := range reaction.Effects {
+		errs = append(errs, reaction.Effects[i].validate(rooms, items))
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkRoom(rooms map[string][]string, name string) error {
+	if _, exist := rooms[name]; !exist {
+		return fmt.Errorf("%w %q", errUnknownRoom, name)
+	}
+
+	return nil
+}
+
+func checkItem(items map[string]struct{}, name string) error {
+	if _, exist := items[name]; !exist {
+		return fmt.Errorf("%w %q", errUnknownItem, name)
+	}
+
+	return nil
+}
+
+func checkConnection(rooms map[string][]string, from, to string) error {
+	if err := errors.Join(checkRoom(rooms, from), checkRoom(rooms, to)); err != nil {
+		return err
+	}
+
+	if !slices.Contains(rooms[from], to) {
+		return fmt.Errorf("%w из %q в %q", errUnknownConnection, from, to)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+const effectsWorld = `{
+	"start": "зал",
+	"items": [
+		{"name": "рычаг", "position": "на стене"},
+		{"name": "монета", "position": "на полу"},
+		{"name": "сундук", "position": "в углу"},
+		{
+			"name": "фонарь",
+			"position": "на столе",
+			"can_apply": {
+				"решётка": {
+					"result": "решётка поднялась",
+					"fail": "слишком темно",
+					"conditions": [
+						{"type": "in_room", "room": "зал"},
+						{"type": "door_open", "from": "зал", "to": "подвал", "not": true}
+					],
+					"effects": [
+						{"type": "open", "from": "зал", "to": "подвал"},
+						{"type": "spawn_item", "item": "сундук", "room": "подвал"},
+						{"type": "move_item", "item": "монета", "room": "подвал", "position": "в сундуке"},
+						{"type": "set_description", "room": "зал", "description": "решётка поднята, "}
+					]
+				},
+				"дракон": {
+					"result": "дракон испугался",
+					"conditions": [{"type": "has_item", "item": "монета"}],
+					"effects": [{"type": "end_game", "outcome": "win"}]
+				}
+			}
+		}
+	],
+	"rooms": [
+		{
+			"name": "зал",
+			"description": "вы в зале, ",
+			"walk_description": "зал",
+			"items": [{"item": "фонарь"}, {"item": "монета"}, {"item": "рычаг"}],
+			"connections": [{"room": "подвал", "locked": true}]
+		},
+		{
+			"name": "подвал",
+			"walk_description": "подвал",
+			"connections": [{"room": "зал"}]
+		}
+	]
+}`
+
+func TestEffects(t *testing.T) {
+	world, err := ParseWorld([]byte(effectsWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame := NewGame(world)
+	p, _ := testGame.AddPlayer("Tristan") //nolint:errcheck
+
+	steps := []gameCase{
+		{1, "взять фонарь", "предмет добавлен в инвентарь: фонарь"},
+		{2, "идти подвал", "дверь закрыта"},
+		{3, "применить фонарь дракон", "ничего не произошло"},
+		{4, "применить фонарь решётка", "решётка поднялась"},
+		{5, "применить фонарь решётка", "слишком темно"},
+		{6, "осмотреться", "решётка поднята, на стене: рычаг. можно пройти - подвал"},
+		{7, "идти подвал", "подвал. можно пройти - зал"},
+		{8, "осмотреться", "в углу: сундук, в сундуке: монета. можно пройти - зал"},
+		{9, "взять монета", "предмет добавлен в инвентарь: монета"},
+		{10, "применить фонарь дракон", "дракон испугался"},
+	}
+
+	for _, step := range steps {
+		answer, _ := testGame.HandleCommand(p, step.command) //nolint:errcheck
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+
+	if p.State != StateWon {
+		t.Error("end_game effect must finish the game with a win")
+	}
+}
+
+func TestConditions(t *testing.T) {
+	world, err := ParseWorld([]byte(effectsWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame := NewGame(world)
+	p, _ := testGame.AddPlayer("Tristan") //nolint:errcheck
+
+	cases := []struct {
+		condition Condition
+		expected  bool
+	}{
+		{Condition{Type: ConditionInRoom, Room: "зал"}, true},
+		{Condition{Type: ConditionInRoom, Room: "подвал"}, false},
+		{Condition{Type: ConditionInRoom, Room: "подвал", Not: true}, true},
+		{Condition{Type: ConditionHasItem, Item: "фонарь"}, false},
+		{Condition{Type: ConditionDoorOpen, From: "подвал", To: "зал"}, true},
+		{Condition{Type: ConditionDoorOpen, From: "зал", To: "подвал"}, false},
+		{Condition{Type: ConditionItemInRoom, Room: "зал", Item: "монета"}, true},
+		{Condition{Type: ConditionItemInRoom, Room: "подвал", Item: "монета"}, false},
+		{Condition{Type: "unknown"}, false},
+	}
+
+	for _, c := range cases {
+		if result := c.condition.Check(p); result != c.expected {
+			t.Errorf("%+v: expected %v, got %v", c.condition, c.expected, result)
+		}
+	}
+
+	effect := Effect{Type: EffectClose, From: "подвал"}
+	effect.Apply(p)
+	if (&Condition{Type: ConditionDoorOpen, From: "подвал", To: "зал"}).Check(p) {
+		t.Error("close effect must lock all doors from the room")
+	}
+
+	effect = Effect{Type: EffectEndGame, Outcome: OutcomeLose}
+	effect.Apply(p)
+	if p.State != StateLost {
+		t.Error("end_game effect must finish the game with a loss")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// размер очереди сообщений игрока, лишние сообщения отбрасываются
+const inboxSize = 64
+
+var (
+	errPlayerExists    = errors.New("игрок с таким именем уже есть")
+	errEmptyPlayerName = errors.New("пустое имя игрока")
+)
+
+// Game - один общий мир, в котором могут находиться несколько игроков
+type Game struct {
+	mu sync.Mutex
+
+	Map     Map
+	Players map[string]*User
+
+	// каталог, в котором хранятся сохранения игры
+	SaveDir string
+
+	Commands *CommandRegistry
+
+	// генератор случайных чисел для событий мира, seed пишется в запись игры для воспроизводимости
+	Seed int64
+	Rand *rand.Rand
+
+	// если задан - каждая команда игроков записывается в запись игры
+	Recorder *Recorder
+
+	// сообщения другим игрокам, отправленные текущей командой, - для записи и сверки игры
+	notified []TranscriptMessage
+
+	// язык новых игроков и сообщений сервера до входа в игру
+	Locale *Locale
+}
+
+func NewGame(world *World) *Game {
+	game := &Game{
+		Players:  make(map[string]*User),
+		SaveDir:  defaultSaveDir,
+		Commands: NewDefaultCommands(),
+		Locale:   DefaultLocale(),
+	}
+	world.Build(&game.Map)
+	game.SetSeed(time.Now().UnixNano())
+
+	return game
+}
+
+func (game *Game) SetSeed(seed int64) {
+	game.Seed = seed
+	game.Rand = rand.New(rand.NewSource(seed)) //nolint:gosec
+}
+
+func (game *Game) AddPlayer(name string) (*User, error) {
+	if name == "" {
+		return nil, errEmptyPlayerName
+	}
+
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	if _, exist := game.Players[name]; exist {
+		return nil, errPlayerExists
+	}
+
+	player := &User{
+		Name:   name,
+		Locale: game.Locale,
+		Inbox:  make(chan string, inboxSize),
+		game:   game,
+	}
+	player.SetPosition(game.Map.Start)
+	player.ClearItems()
+	player.ClearGoals()
+
+	game.Players[name] = player
+	game.record(TranscriptEntry{Player: name, Event: eventJoin})
+
+	return player, nil
+}
+
+// RemovePlayer убирает игрока из мира; всё, что он нёс, остаётся на полу его комнаты,
+// иначе ключи и рюкзак ушли бы вместе с ним и остальные не смогли бы пройти игру
+func (game *Game) RemovePlayer(name string) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	player, exist := game.Players[name]
+	if !exist {
+		return
+	}
+
+	for _, item := range player.carried() {
+		player.Position.Items = append(player.Position.Items, &RoomTarget{
+			Item:     item,
+			Position: dropPosition,
+		})
+	}
+	player.ClearItems()
+
+	delete(game.Players, name)
+	game.record(TranscriptEntry{Player: name, Event: eventLeave})
+}
+
+// HandleCommand разбирает строку команды и выполняет её от имени игрока
+func (game *Game) HandleCommand(player *User, command string) (string, error) {
+	parameters := strings.Split(command, " ")
+
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	state := player.State
+	game.notified = nil
+	result, err := player.DoCommand(parameters[0], parameters[1:]...)
+
+	if game.Recorder != nil {
+		game.record(newTranscriptEntry(player, command, result, err, state, game.notified))
+	}
+
+	return result, err
+}
+
+// record пишет событие в запись игры, если она ведётся
+func (game *Game) record(entry TranscriptEntry) {
+	if game.Recorder == nil {
+		return
+	}
+
+	if err := game.Recorder.Record(entry); err != nil {
+		log.Println("не удалось записать игру:", err)
+	}
+}
+
+// TakeOutcome возвращает сообщение об окончании игры один раз - сразу после того, как игра закончилась
+func (game *Game) TakeOutcome(player *User) string {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	if player.State == StatePlaying || player.outcomeReported {
+		return ""
+	}
+	player.outcomeReported = true
+
+	return player.Outcome()
+}
+
+// RemoveItem убирает предмет из комнаты или инвентаря игрока, где бы он ни находился
+func (game *Game) RemoveItem(item *Item) bool {
+	if item.Container != nil {
+		return item.Container.Remove(item)
+	}
+
+	for _, room := range game.Map.Rooms {
+		if room.RemoveItem(item) {
+			return true
+		}
+	}
+
+	for _, player := range game.Players {
+		if _, have := player.Items[item]; have {
+			delete(player.Items, item)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ItemPlaced проверяет, лежит ли предмет в какой-нибудь комнате, контейнере или у игрока
+func (game *Game) ItemPlaced(item *Item) bool {
+	if item.Container != nil {
+		return true
+	}
+
+	for _, room := range game.Map.Rooms {
+		if slices.ContainsFunc(room.Items, func(roomTarget *RoomTarget) bool {
+			return roomTarget.Item == item
+		}) {
+			return true
+		}
+	}
+
+	for _, player := range game.Players {
+		if _, have := player.Items[item]; have {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PlayersInRoom возвращает остальных игроков, находящихся в той же комнате
+func (game *Game) PlayersInRoom(player *User) []*User {
+	var players []*User
+
+	for _, other := range game.Players {
+		if other != player && other.Position == player.Position {
+			players = append(players, other)
+		}
+	}
+
+	slices.SortFunc(players, func(a, b *User) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return players
+}
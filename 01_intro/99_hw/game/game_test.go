@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func newTestGame(t *testing.T, names ...string) (*Game, []*User) {
+	t.Helper()
+
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGame := NewGame(world)
+	players := make([]*User, 0, len(names))
+	for _, name := range names {
+		p, err := testGame.AddPlayer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, p)
+	}
+
+	return testGame, players
+}
+
+func readInbox(p *User) string {
+	select {
+	case message := <-p.Inbox:
+		return message
+	default:
+		return ""
+	}
+}
+
+type playerCase struct {
+	player  int
+	command string
+	answer  string
+	inbox   map[int]string
+}
+
+func TestMultiplayer(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan", "Izolda")
+
+	cases := []playerCase{
+		{0, "осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор. Кроме вас тут ещё Izolda", nil},
+		{1, "осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор. Кроме вас тут ещё Tristan", nil},
+		{0, "сказать Привет", "Tristan говорит: Привет", map[int]string{1: "Tristan говорит: Привет"}},
+		{1, "сказать_игроку Tristan вижу тебя", "", map[int]string{0: "Izolda говорит вам: вижу тебя"}},
+		{1, "сказать_игроку Tristan", "", map[int]string{0: "Izolda выразительно молчит, смотря на вас"}},
+		{1, "сказать_игроку Merlin привет", "тут нет такого игрока", nil},
+		{0, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица", nil},
+		{1, "сказать_игроку Tristan привет", "тут нет такого игрока", nil},
+		{0, "идти комната", "ты в своей комнате. можно пройти - коридор", nil},
+		{0, "надеть рюкзак", "вы надели: рюкзак", nil},
+		{0, "взять ключи", "предмет добавлен в инвентарь: ключи", nil},
+		{1, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица", nil},
+		{1, "идти комната", "ты в своей комнате. можно пройти - коридор. Кроме вас тут ещё Tristan", nil},
+		{1, "осмотреться", "на столе: конспекты. можно пройти - коридор. Кроме вас тут ещё Tristan", nil},
+		{1, "надеть рюкзак", "нет такого", nil},
+		{1, "взять ключи", "нет такого", nil},
+	}
+
+	for i, c := range cases {
+		answer, err := testGame.HandleCommand(players[c.player], c.command)
+		if err != nil {
+			t.Error("step", i, "unexpected error:", err)
+		}
+		if answer != c.answer {
+			t.Error("step", i,
+				"\n\tcmd:", c.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", c.answer)
+		}
+		for idx, expected := range c.inbox {
+			if message := readInbox(players[idx]); message != expected {
+				t.Error("step", i, "player", players[idx].Name, "got message", message, "expected", expected)
+			}
+		}
+	}
+}
+
+func TestAddPlayer(t *testing.T) {
+	testGame, _ := newTestGame(t, "Tristan")
+
+	if _, err := testGame.AddPlayer("Tristan"); err != errPlayerExists {
+		t.Error("expected errPlayerExists, got", err)
+	}
+	if _, err := testGame.AddPlayer(""); err != errEmptyPlayerName {
+		t.Error("expected errEmptyPlayerName, got", err)
+	}
+
+	testGame.RemovePlayer("Tristan")
+	if _, err := testGame.AddPlayer("Tristan"); err != nil {
+		t.Error("player must be added after removal, got", err)
+	}
+}
+
+// несколько игроков одновременно бегут за одним рюкзаком, надеть его может только один
+func TestConcurrentPlayers(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	testGame, players := newTestGame(t, names...)
+
+	results := make([]string, len(players))
+	wg := &sync.WaitGroup{}
+	for i, p := range players {
+		wg.Add(1)
+		go func(i int, p *User) {
+			defer wg.Done()
+			for _, command := range []string{"идти коридор", "идти комната", "осмотреться", "сказать я тут"} {
+				testGame.HandleCommand(p, command) //nolint:errcheck
+			}
+			results[i], _ = testGame.HandleCommand(p, "надеть рюкзак") //nolint:errcheck
+		}(i, p)
+	}
+	wg.Wait()
+
+	var winners int
+	for _, result := range results {
+		if result == "вы надели: рюкзак" {
+			winners++
+		}
+	}
+
+	if winners != 1 {
+		t.Error("expected exactly one player with the backpack, got", winners)
+	}
+}
+
+// вещи ушедшего игрока остаются в комнате, и игру могут пройти остальные
+func TestRemovePlayerDropsItems(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan", "Izolda")
+	tristan, izolda := players[0], players[1]
+
+	for _, command := range []string{"идти коридор", "идти комната", "надеть рюкзак", "взять ключи"} {
+		testGame.HandleCommand(tristan, command) //nolint:errcheck
+	}
+	testGame.RemovePlayer("Tristan")
+
+	cases := []playerCase{
+		{1, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица", nil},
+		{1, "идти комната", "ты в своей комнате. можно пройти - коридор", nil},
+		{1, "осмотреться", "на столе: конспекты, на полу: рюкзак (ключи). можно пройти - коридор", nil},
+		{1, "надеть рюкзак", "вы надели: рюкзак", nil},
+	}
+	for i, c := range cases {
+		answer, err := testGame.HandleCommand(players[c.player], c.command)
+		if err != nil {
+			t.Error("step", i, "unexpected error:", err)
+		}
+		if answer != c.answer {
+			t.Error("step", i,
+				"\n\tcmd:", c.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", c.answer)
+		}
+	}
+
+	if !izolda.HasItem("ключи") {
+		t.Error("keys must stay in the backpack left by Tristan")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import "strings"
+
+// Goal - задание игрока, выполняется, когда все его условия выполнены одновременно
+type Goal struct {
+	Name       string      `json:"name"`
+	Points     int         `json:"points,omitempty"`
+	Conditions []Condition `json:"conditions"`
+}
+
+func (goal *Goal) Check(user *User) bool {
+	for i := range goal.Conditions {
+		if !goal.Conditions[i].Check(user) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Quest - задания мира и условия окончания игры
+type Quest struct {
+	Goals   []Goal `json:"goals,omitempty"`
+	Ordered bool   `json:"ordered_goals,omitempty"`
+
+	// после стольких ходов без выполнения всех заданий игра проиграна, 0 - без ограничения
+	MaxMoves int `json:"max_moves,omitempty"`
+}
+
+// UpdateGoals отмечает выполненные задания, начисляет очки и определяет исход игры
+func (user *User) UpdateGoals() {
+	if user.State != StatePlaying {
+		return
+	}
+
+	quest := &user.game.Map.Quest
+	for i := range quest.Goals {
+		goal := &quest.Goals[i]
+		if user.CompletedGoals[goal.Name] {
+			continue
+		}
+		if !goal.Check(user) {
+			if quest.Ordered {
+				break
+			}
+			continue
+		}
+
+		user.CompletedGoals[goal.Name] = true
+		user.Score += goal.Points
+	}
+
+	switch {
+	case len(quest.Goals) > 0 && len(user.RemainingGoals()) == 0:
+		user.State = StateWon
+	case quest.MaxMoves > 0 && user.Moves >= quest.MaxMoves:
+		user.State = StateLost
+	}
+}
+
+func (user *User) RemainingGoals() []*Goal {
+	var goals []*Goal
+
+	quest := &user.game.Map.Quest
+	for i := range quest.Goals {
+		if !user.CompletedGoals[quest.Goals[i].Name] {
+			goals = append(goals, &quest.Goals[i])
+		}
+	}
+
+	return goals
+}
+
+func (user *User) ClearGoals() {
+	user.CompletedGoals = make(map[string]bool)
+	user.Score = 0
+	user.Moves = 0
+	user.State = StatePlaying
+}
+
+func (user *User) HandleGoals() string {
+	var builder strings.Builder
+
+	quest := &user.game.Map.Quest
+	if len(quest.Goals) == 0 {
+		builder.WriteString(user.Text(msgGoalsNone, nil))
+	} else {
+		builder.WriteString(user.Text(msgGoals, nil))
+	}
+
+	for _, goal := range quest.Goals {
+		if user.CompletedGoals[goal.Name] {
+			builder.WriteString("\n" + user.Text(msgGoalDone, Params{"Goal": goal.Name}))
+		} else {
+			builder.WriteString("\n" + user.Text(msgGoalTodo, Params{"Goal": goal.Name}))
+		}
+	}
+
+	builder.WriteString("\n" + user.Text(msgScore, Params{"Score": user.Score, "Moves": user.Moves}))
+	if quest.MaxMoves > 0 {
+		left := quest.MaxMoves - user.Moves
+		if left < 0 {
+			left = 0
+		}
+		builder.WriteString(user.Text(msgMovesLeft, Params{"Left": left}))
+	}
+
+	return builder.String()
+}
+
+// Outcome - сообщение об окончании игры, пустое, пока игра идёт
+func (user *User) Outcome() string {
+	params := Params{"Score": user.Score, "Moves": user.Moves}
+
+	switch user.State {
+	case StateWon:
+		return user.Text(msgWin, params)
+	case StateLost:
+		return user.Text(msgLose, params)
+	}
+
+	return ""
+}
+
+func DisplayGoals(user *User) string {
+	if !user.Position.ShowGoals {
+		return ""
+	}
+
+	goals := user.RemainingGoals()
+	if len(goals) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(goals))
+	for _, goal := range goals {
+		names = append(names, goal.Name)
+	}
+
+	return user.Text(msgGoalsNeed, Params{"Goals": names})
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestGoals(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	p := players[0]
+
+	steps := []gameCase{
+		{1, "задания", "задания:\n[ ] собрать рюкзак\n[ ] идти в универ\nочки: 0, ходов: 0"},
+		{2, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{3, "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{4, "надеть рюкзак", "вы надели: рюкзак"},
+		{5, "задания", "задания:\n[x] собрать рюкзак\n[ ] идти в универ\nочки: 10, ходов: 3"},
+		{6, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{7, "идти кухня", "кухня, ничего интересного. можно пройти - коридор"},
+		{8, "осмотреться", "ты находишься на кухне, на столе: чай, надо идти в универ. можно пройти - коридор"},
+	}
+
+	for _, step := range steps {
+		answer, _ := testGame.HandleCommand(p, step.command) //nolint:errcheck
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+
+	if outcome := testGame.TakeOutcome(p); outcome != "" {
+		t.Error("game must not be finished yet, got", outcome)
+	}
+}
+
+func TestOrderedGoals(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	p := players[0]
+
+	// без рюкзака выход на улицу не засчитывается, задания выполняются по порядку
+	testGame.Map.Rooms["коридор"].ConnectionsSet[testGame.Map.Rooms["улица"]] = true
+	for _, command := range []string{"идти коридор", "идти улица"} {
+		testGame.HandleCommand(p, command) //nolint:errcheck
+	}
+
+	if p.State != StatePlaying || len(p.RemainingGoals()) != 2 {
+		t.Error("ordered goals must be completed in order, remaining:", len(p.RemainingGoals()))
+	}
+}
+
+func TestGameOutcome(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan", "Izolda")
+	winner, loser := players[0], players[1]
+
+	testGame.Map.Rooms["коридор"].ConnectionsSet[testGame.Map.Rooms["улица"]] = true
+	for _, command := range []string{"идти коридор", "идти комната", "надеть рюкзак", "идти коридор", "идти улица"} {
+		testGame.HandleCommand(winner, command) //nolint:errcheck
+	}
+
+	if outcome := testGame.TakeOutcome(winner); outcome != "победа! очки: 30, ходов: 5" {
+		t.Error("unexpected outcome:", outcome)
+	}
+	if outcome := testGame.TakeOutcome(winner); outcome != "" {
+		t.Error("outcome must be reported once, got", outcome)
+	}
+	if answer, _ := testGame.HandleCommand(winner, "осмотреться"); answer != "игра окончена" { //nolint:errcheck
+		t.Error("commands must not work after the game is over, got", answer)
+	}
+
+	testGame.Map.Quest.MaxMoves = 3
+	for _, command := range []string{"осмотреться", "задания", "идти коридор", "завтракать", "идти кухня"} {
+		testGame.HandleCommand(loser, command) //nolint:errcheck
+	}
+
+	if loser.State != StateLost {
+		t.Error("player must lose after max moves")
+	}
+	if outcome := testGame.TakeOutcome(loser); outcome != "поражение. очки: 0, ходов: 3" {
+		t.Error("unexpected outcome:", outcome)
+	}
+}
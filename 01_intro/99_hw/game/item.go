@@ -1,11 +1,76 @@
 package main
 
+import "slices"
+
 type Item struct {
 	Name         string
-	CanApply     map[string]string
-	ItemAction   string
+	CanApply     map[string]*Reaction
 	ActionResult string
 	ItemPosition string
 
-	UseItem func(*User)
+	// сколько предметов помещается внутрь, 0 - предмет не контейнер
+	Capacity int
+	Closable bool
+	Closed   bool
+	Contents []*Item
+
+	// контейнер, в котором лежит предмет, nil - предмет лежит в комнате или у игрока
+	Container *Item
+}
+
+func (item *Item) IsContainer() bool {
+	return item.Capacity > 0
+}
+
+// HasSpace проверяет, что в открытый контейнер можно положить ещё один предмет
+func (item *Item) HasSpace() bool {
+	return item.IsContainer() && !item.Closed && len(item.Contents) < item.Capacity
+}
+
+func (item *Item) Put(inner *Item) {
+	item.Contents = append(item.Contents, inner)
+	inner.Container = item
+}
+
+func (item *Item) Remove(inner *Item) bool {
+	index := slices.Index(item.Contents, inner)
+	if index == -1 {
+		return false
+	}
+
+	item.Contents = slices.Delete(item.Contents, index, index+1)
+	inner.Container = nil
+
+	return true
+}
+
+// Holds проверяет, лежит ли inner внутри предмета на любой глубине
+func (item *Item) Holds(inner *Item) bool {
+	for container := inner.Container; container != nil; container = container.Container {
+		if container == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findItem ищет предмет среди items и внутри них; в закрытые контейнеры заглядывает, только если closed
+func findItem(items []*Item, name string, closed bool) *Item {
+	for _, item := range items {
+		if item.Name == name {
+			return item
+		}
+	}
+
+	for _, item := range items {
+		if item.Closed && !closed {
+			continue
+		}
+		if inner := findItem(item.Contents, name, closed); inner != nil {
+			return inner
+		}
+	}
+
+	return nil
 }
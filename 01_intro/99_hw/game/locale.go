@@ -0,0 +1,466 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// язык по умолчанию, на нём же написаны имена команд и мир
+const defaultLanguage = "ru"
+
+//go:embed lang/*.json
+var langFiles embed.FS
+
+var (
+	errUnknownLanguage = errors.New("нет такого языка")
+	errNoMessage       = errors.New("нет сообщения")
+	errNoDefault       = errors.New("нет языка по умолчанию")
+)
+
+// ключи сообщений каталога
+const (
+	msgUnknownCommand = "unknown_command"
+	msgDidYouMean     = "did_you_mean"
+	msgInvalidCommand = "invalid_command"
+	msgGameOver       = "game_over"
+	msgHelp           = "help"
+	msgHelpAliases    = "help_aliases"
+
+	msgNotInInventory  = "not_in_inventory"
+	msgNoSuchItem      = "no_such_item"
+	msgNowhereToPut    = "nowhere_to_put"
+	msgWear            = "wear"
+	msgTake            = "take"
+	msgPut             = "put"
+	msgDrop            = "drop"
+	msgPutInto         = "put_into"
+	msgNotContainer    = "not_container"
+	msgIntoItself      = "into_itself"
+	msgOpenFirst       = "open_first"
+	msgContainerFull   = "container_full"
+	msgCannotOpen      = "cannot_open"
+	msgCannotClose     = "cannot_close"
+	msgAlreadyOpen     = "already_open"
+	msgAlreadyClosed   = "already_closed"
+	msgOpened          = "opened"
+	msgClosed          = "closed"
+	msgInventoryEmpty  = "inventory_empty"
+	msgInventory       = "inventory"
+	msgCannotApply     = "cannot_apply"
+	msgNothingHappened = "nothing_happened"
+
+	msgEmptyRoom     = "empty_room"
+	msgItemsAt       = "items_at"
+	msgPossibleMoves = "possible_moves"
+	msgHome          = "home"
+	msgOtherPlayers  = "other_players"
+	msgNoPath        = "no_path"
+	msgDoorClosed    = "door_closed"
+
+	msgSay             = "say"
+	msgSayPlayer       = "say_player"
+	msgSayPlayerSilent = "say_player_silent"
+	msgNoSuchPlayer    = "no_such_player"
+
+	msgGoalsNeed = "goals_need"
+	msgGoalsNone = "goals_none"
+	msgGoals     = "goals"
+	msgGoalDone  = "goal_done"
+	msgGoalTodo  = "goal_todo"
+	msgScore     = "score"
+	msgMovesLeft = "moves_left"
+	msgWin       = "win"
+	msgLose      = "lose"
+
+	msgSaved      = "saved"
+	msgSaveFailed = "save_failed"
+	msgLoaded     = "loaded"
+	msgLoadFailed = "load_failed"
+
+	msgInvalidSaveName = "invalid_save_name"
+	msgSaveNotFound    = "save_not_found"
+	msgUnsupportedSave = "unsupported_save"
+	msgLoadWithOthers  = "load_with_others"
+	msgPlayerExists    = "player_exists"
+	msgEmptyPlayerName = "empty_player_name"
+	msgInternalError   = "internal_error"
+
+	msgLanguage        = "language"
+	msgUnknownLanguage = "unknown_language"
+
+	msgEnterName   = "enter_name"
+	msgWelcome     = "welcome"
+	msgIdleTimeout = "idle_timeout"
+)
+
+// Params - параметры шаблона сообщения
+type Params map[string]interface{}
+
+// Word - перевод слова мира; Forms - падежи и другие формы, например "род" для родительного падежа
+type Word struct {
+	Text   string            `json:"text"`
+	Gender string            `json:"gender,omitempty"`
+	Forms  map[string]string `json:"forms,omitempty"`
+}
+
+// в файле языка слово без форм можно записать просто строкой
+func (word *Word) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*word = Word{Text: text}
+		return nil
+	}
+
+	type plainWord Word
+	return json.Unmarshal(data, (*plainWord)(word))
+}
+
+// CommandText - перевод команды: имя, синонимы и описание для помощи
+type CommandText struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Usage       string   `json:"usage,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Locale - каталог сообщений одного языка; чего нет в каталоге, берётся из языка по умолчанию
+type Locale struct {
+	Language string                 `json:"language"`
+	Name     string                 `json:"name"`
+	Commands map[string]CommandText `json:"commands,omitempty"`
+	Messages map[string]string      `json:"messages"`
+	Words    map[string]Word        `json:"words,omitempty"`
+
+	fallback  *Locale
+	templates map[string]*template.Template
+
+	// переведённые имена команд и слова мира -> исходные
+	commandNames map[string]string
+	worldWords   map[string]string
+}
+
+// правила выбора формы множественного числа: номер формы для числа n
+var pluralRules = map[string]func(n int) int{
+	"ru": func(n int) int {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20):
+			return 1
+		default:
+			return 2
+		}
+	},
+	"en": func(n int) int {
+		if n == 1 {
+			return 0
+		}
+		return 1
+	},
+}
+
+// роды слов по языкам, в порядке форм для согласования; для языков без родов форма одна
+var genders = map[string][]string{
+	"ru": {"м", "ж", "с", "мн"},
+}
+
+var locales = mustLoadLocales()
+
+func mustLoadLocales() map[string]*Locale {
+	loaded, err := LoadLocales(langFiles, "lang")
+	if err != nil {
+		panic(err)
+	}
+
+	return loaded
+}
+
+// LoadLocales читает все файлы языков из каталога dir
+func LoadLocales(fsys fs.FS, dir string) (map[string]*Locale, error) {
+	fileNames, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(map[string]*Locale)
+	for _, fileName := range fileNames {
+		data, err := fs.ReadFile(fsys, fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		locale := &Locale{}
+		if err := json.Unmarshal(data, locale); err != nil {
+			return nil, fmt.Errorf("язык %s: %w", fileName, err)
+		}
+		loaded[locale.Language] = locale
+	}
+
+	defaultLocale, exist := loaded[defaultLanguage]
+	if !exist {
+		return nil, fmt.Errorf("%w: %s", errNoDefault, defaultLanguage)
+	}
+
+	var errs []error
+	for _, language := range sortedKeys(loaded) {
+		locale := loaded[language]
+		if locale != defaultLocale {
+			locale.fallback = defaultLocale
+		}
+		if err := locale.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("язык %s: %w", language, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
+
+func FindLocale(language string) (*Locale, error) {
+	locale, exist := locales[language]
+	if !exist {
+		return nil, fmt.Errorf("%w: %s", errUnknownLanguage, language)
+	}
+
+	return locale, nil
+}
+
+func DefaultLocale() *Locale {
+	return locales[defaultLanguage]
+}
+
+// Languages - коды всех доступных языков
+func Languages() []string {
+	return sortedKeys(locales)
+}
+
+func (locale *Locale) compile() error {
+	funcs := template.FuncMap{
+		"name":   locale.Word,
+		"names":  locale.WordList,
+		"form":   locale.Form,
+		"plural": locale.Plural,
+		"agree":  locale.Agree,
+		"join": func(list []string, sep ...string) string {
+			if len(sep) > 0 {
+				return strings.Join(list, sep[0])
+			}
+			return strings.Join(list, ", ")
+		},
+	}
+
+	var errs []error
+	locale.templates = make(map[string]*template.Template, len(locale.Messages))
+	for _, key := range sortedKeys(locale.Messages) {
+		tmpl, err := template.New(key).Funcs(funcs).Option("missingkey=error").Parse(locale.Messages[key])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		locale.templates[key] = tmpl
+	}
+
+	if _, exist := pluralRules[locale.Language]; !exist {
+		errs = append(errs, fmt.Errorf("нет правила множественного числа для %s", locale.Language))
+	}
+
+	locale.commandNames = make(map[string]string)
+	for canonical, text := range locale.Commands {
+		for _, name := range append([]string{text.Name}, text.Aliases...) {
+			locale.commandNames[name] = canonical
+		}
+	}
+
+	locale.worldWords = make(map[string]string)
+	for canonical, word := range locale.Words {
+		locale.worldWords[word.Text] = canonical
+	}
+
+	return errors.Join(errs...)
+}
+
+// Text подставляет параметры в сообщение с ключом key
+func (locale *Locale) Text(key string, params Params) string {
+	for current := locale; current != nil; current = current.fallback {
+		tmpl, exist := current.templates[key]
+		if !exist {
+			continue
+		}
+
+		var builder strings.Builder
+		if err := tmpl.Execute(&builder, params); err != nil {
+			log.Printf("язык %s, сообщение %s: %v", current.Language, key, err)
+			return key
+		}
+		return builder.String()
+	}
+
+	log.Printf("%v: %s", errNoMessage, key)
+	return key
+}
+
+// Word переводит слово мира: имя предмета, комнаты, описание; непереведённое слово возвращается как есть
+func (locale *Locale) Word(canonical string) string {
+	if word, exist := locale.Words[canonical]; exist && word.Text != "" {
+		return word.Text
+	}
+
+	return canonical
+}
+
+func (locale *Locale) WordList(canonical []string) []string {
+	words := make([]string, 0, len(canonical))
+	for _, word := range canonical {
+		words = append(words, locale.Word(word))
+	}
+
+	return words
+}
+
+// Form возвращает форму слова, например падеж; если формы нет - обычный перевод
+func (locale *Locale) Form(canonical string, form string) string {
+	if word, exist := locale.Words[canonical]; exist {
+		if text, exist := word.Forms[form]; exist {
+			return text
+		}
+	}
+
+	return locale.Word(canonical)
+}
+
+// Plural выбирает форму по числу n по правилам языка, %d в форме заменяется на число
+func (locale *Locale) Plural(n int, forms ...string) string {
+	if len(forms) == 0 {
+		return strconv.Itoa(n)
+	}
+
+	index := pluralRules[locale.Language](n)
+	if index >= len(forms) {
+		index = len(forms) - 1
+	}
+
+	return strings.ReplaceAll(forms[index], "%d", strconv.Itoa(n))
+}
+
+// Agree выбирает форму, согласованную с родом слова, например "открыт", "открыта", "открыто", "открыты"
+func (locale *Locale) Agree(canonical string, forms ...string) string {
+	if len(forms) == 0 {
+		return ""
+	}
+
+	index := slices.Index(genders[locale.Language], locale.Words[canonical].Gender)
+	if index == -1 || index >= len(forms) {
+		index = 0
+	}
+
+	return forms[index]
+}
+
+// Canonical переводит слово, введённое игроком, обратно в слово мира
+func (locale *Locale) Canonical(word string) string {
+	if canonical, exist := locale.worldWords[word]; exist {
+		return canonical
+	}
+
+	return word
+}
+
+// CommandName - имя команды на этом языке
+func (locale *Locale) CommandName(command *Command) string {
+	if text, exist := locale.Commands[command.CommandName]; exist && text.Name != "" {
+		return text.Name
+	}
+
+	return command.CommandName
+}
+
+func (locale *Locale) CommandAliases(command *Command) []string {
+	if text, exist := locale.Commands[command.CommandName]; exist {
+		return text.Aliases
+	}
+
+	return command.Aliases
+}
+
+func (locale *Locale) UsageLine(command *Command) string {
+	usage := command.Usage
+	if text, exist := locale.Commands[command.CommandName]; exist {
+		usage = text.Usage
+	}
+
+	if usage == "" {
+		return locale.CommandName(command)
+	}
+
+	return locale.CommandName(command) + " " + usage
+}
+
+func (locale *Locale) CommandDescription(command *Command) string {
+	if text, exist := locale.Commands[command.CommandName]; exist && text.Description != "" {
+		return text.Description
+	}
+
+	return command.Description
+}
+
+// commandNamesOf - все имена команды, которые понимает игрок с этим языком
+func (locale *Locale) commandNamesOf(command *Command) []string {
+	names := append([]string{locale.CommandName(command)}, locale.CommandAliases(command)...)
+	if locale.CommandName(command) != command.CommandName {
+		names = append(names, command.CommandName)
+	}
+
+	return names
+}
+
+// errorMessages - сообщения каталога для ошибок, о которых рассказывают игроку
+var errorMessages = []struct {
+	err error
+	key string
+}{
+	{errInvalidSaveName, msgInvalidSaveName},
+	{errSaveNotFound, msgSaveNotFound},
+	{errUnsupportedVersion, msgUnsupportedSave},
+	{errLoadWithOthers, msgLoadWithOthers},
+	{errPlayerExists, msgPlayerExists},
+	{errEmptyPlayerName, msgEmptyPlayerName},
+}
+
+// ErrorText переводит известную ошибку на язык каталога. Текст остальных (ошибки файлов, разбора JSON)
+// не переведён и говорит о внутренностях сервера, поэтому он идёт в лог, а игрок получает общее сообщение
+func (locale *Locale) ErrorText(err error) string {
+	for _, known := range errorMessages {
+		if errors.Is(err, known.err) {
+			return locale.Text(known.key, nil)
+		}
+	}
+
+	log.Println("ошибка игры:", err)
+	return locale.Text(msgInternalError, nil)
+}
+
+// LocalizedError - ошибка с текстом на языке игрока, errors.Is продолжает работать по исходной ошибке
+type LocalizedError struct {
+	Err     error
+	Message string
+}
+
+func (localizedErr *LocalizedError) Error() string {
+	return localizedErr.Message
+}
+
+func (localizedErr *LocalizedError) Unwrap() error {
+	return localizedErr.Err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEnglishGame(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	testGame.SaveDir = t.TempDir()
+	p := players[0]
+
+	steps := []gameCase{
+		{1, "язык en", "language: English"},
+		{2, "look", "you are in the kitchen, on the table: tea, you need to pack the backpack and go to the university. you can go to - hallway"},
+		{3, "gi hallway", "unknown command, did you mean: go"},
+		{4, "go room", "there is no way to room"},
+		{5, "go hallway", "nothing interesting. you can go to - kitchen, room, street"},
+		{6, "go street", "the door is closed"},
+		{7, "идти комната", "you are in your room. you can go to - hallway"},
+		{8, "take keys", "nowhere to put it"},
+		{9, "wear backpack", "you put on: backpack"},
+		{10, "pick keys", "added to the inventory: keys"},
+		{11, "take phone", "there is no such thing"},
+		{12, "i", "in the inventory: backpack (keys)"},
+		{13, "put keys into keys", "you cannot put anything into the keys"},
+		{14, "go hallway", "nothing interesting. you can go to - kitchen, room, street"},
+		{15, "use keys door", "the door is open"},
+		{16, "go street", "it is spring outside. you can go to - home"},
+		{17, "look", "the game is over"},
+		{18, "goals", "goals:\n[x] pack the backpack\n[x] go to the university\npoints: 30, moves: 14"},
+		{19, "load missing", "could not load the game: there is no such save"},
+		{20, "save ../first", "could not save the game: invalid save name"},
+		{21, "language ru", "язык: русский"},
+		{22, "осмотреться", "игра окончена"},
+	}
+
+	for _, step := range steps {
+		answer, err := testGame.HandleCommand(p, step.command)
+		if err != nil {
+			t.Error("step", step.step, "unexpected error:", err)
+		}
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+}
+
+func TestLocalizedPlayers(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan", "Izolda")
+	tristan, izolda := players[0], players[1]
+
+	testGame.HandleCommand(izolda, "язык English") //nolint:errcheck
+
+	if answer, _ := testGame.HandleCommand(tristan, "сказать привет"); answer != "Tristan говорит: привет" { //nolint:errcheck
+		t.Error("unexpected answer:", answer)
+	}
+	if message := readInbox(izolda); message != "Tristan says: привет" {
+		t.Error("message must be in the receiver's language, got:", message)
+	}
+
+	if answer, _ := testGame.HandleCommand(izolda, "язык klingon"); answer != "no such language, available: en, ru" { //nolint:errcheck
+		t.Error("unexpected answer:", answer)
+	}
+
+	_, err := testGame.HandleCommand(izolda, "go")
+	if err == nil || err.Error() != "invalid command format: go <room>" {
+		t.Error("unexpected error:", err)
+	}
+
+	help, _ := testGame.HandleCommand(izolda, "help") //nolint:errcheck
+	if !strings.Contains(help, "\napply <item> <target> - apply an item from the inventory (also: use)") {
+		t.Error("help is not translated:\n", help)
+	}
+}
+
+func TestPlural(t *testing.T) {
+	ru, err := FindLocale("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, err := FindLocale("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		n      int
+		ru, en string
+	}{
+		{1, "1 ход", "1 move"},
+		{2, "2 хода", "2 moves"},
+		{5, "5 ходов", "5 moves"},
+		{11, "11 ходов", "11 moves"},
+		{21, "21 ход", "21 moves"},
+		{104, "104 хода", "104 moves"},
+		{112, "112 ходов", "112 moves"},
+	}
+
+	for _, c := range cases {
+		if got := ru.Plural(c.n, "%d ход", "%d хода", "%d ходов"); got != c.ru {
+			t.Errorf("ru %d: got %q, expected %q", c.n, got, c.ru)
+		}
+		if got := en.Plural(c.n, "%d move", "%d moves"); got != c.en {
+			t.Errorf("en %d: got %q, expected %q", c.n, got, c.en)
+		}
+	}
+}
+
+func TestMovesLeft(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	testGame.Map.Quest.MaxMoves = 3
+
+	testGame.HandleCommand(players[0], "осмотреться")         //nolint:errcheck
+	goals, _ := testGame.HandleCommand(players[0], "задания") //nolint:errcheck
+	if !strings.HasSuffix(goals, "очки: 0, ходов: 1, осталось 2 хода") {
+		t.Error("unexpected goals:", goals)
+	}
+
+	testGame.HandleCommand(players[0], "осмотреться")        //nolint:errcheck
+	goals, _ = testGame.HandleCommand(players[0], "задания") //nolint:errcheck
+	if !strings.HasSuffix(goals, "очки: 0, ходов: 2, остался 1 ход") {
+		t.Error("unexpected goals:", goals)
+	}
+}
+
+// каждый язык должен переводить все сообщения языка по умолчанию
+func TestLocalesComplete(t *testing.T) {
+	ru := DefaultLocale()
+
+	for _, language := range Languages() {
+		locale := locales[language]
+		for key := range ru.Messages {
+			if _, exist := locale.Messages[key]; !exist {
+				t.Errorf("%s: no message %q", language, key)
+			}
+		}
+	}
+}
+
+func TestLoadLocalesErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lang/en.json": {Data: []byte(`{"language": "en", "messages": {}}`)},
+	}
+	if _, err := LoadLocales(fsys, "lang"); err == nil {
+		t.Error("expected error for missing default language")
+	}
+
+	fsys["lang/ru.json"] = &fstest.MapFile{Data: []byte(`{"language": "ru", "messages": {"say": "{{.Player"}}`)}
+	if _, err := LoadLocales(fsys, "lang"); err == nil {
+		t.Error("expected error for broken template")
+	}
+}
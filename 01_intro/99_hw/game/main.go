@@ -2,13 +2,78 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultPlayerName = "игрок"
+
+var (
+	// путь к файлу мира, если пустой - используется встроенный мир
+	worldFileName string
+
+	// адрес сетевого сервера, если пустой - команды читаются из stdin
+	listenAddr  string
+	idleTimeout time.Duration
+
+	saveDir string
+
+	// язык игры по умолчанию, каждый игрок может сменить его командой "язык"
+	language string
+
+	recordFileName string
+	replayFileName string
+	seed           int64
+
+	// игра и игрок для режима с вводом команд из stdin
+	game   *Game
+	player *User
 )
 
 func main() {
-	initGame()
+	flag.StringVar(&worldFileName, "world", "", "путь к json файлу с описанием мира")
+	flag.StringVar(&listenAddr, "listen", "", "адрес сетевого сервера, например :4000")
+	flag.DurationVar(&idleTimeout, "idle-timeout", defaultIdleTimeout, "время бездействия, после которого игрок отключается")
+	flag.StringVar(&saveDir, "saves", defaultSaveDir, "каталог для сохранений игры")
+	flag.StringVar(&language, "lang", defaultLanguage, "язык игры: "+strings.Join(Languages(), ", "))
+	flag.StringVar(&recordFileName, "record", "", "файл, в который записывается игра")
+	flag.StringVar(&replayFileName, "replay", "", "файл записи игры, которую надо проиграть заново и сверить")
+	flag.Int64Var(&seed, "seed", 0, "seed генератора случайных чисел, 0 - случайный")
+	flag.Parse()
+
+	world, err := loadGameWorld()
+	if err != nil {
+		log.Fatalln("не удалось загрузить мир:", err)
+	}
+
+	if _, err := FindLocale(language); err != nil {
+		log.Fatalln(err)
+	}
+
+	if replayFileName != "" {
+		runReplay(world)
+		return
+	}
+
+	if listenAddr != "" {
+		runServer(world)
+		return
+	}
+
+	// запись включается до входа игрока, чтобы в неё попал и он
+	game = newGame(world)
+	closeRecorder := startRecording(game)
+	defer closeRecorder()
+	joinGame()
+
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -16,140 +81,121 @@ func main() {
 			break
 		}
 		fmt.Println(handleCommand(line))
+		if outcome := game.TakeOutcome(player); outcome != "" {
+			fmt.Println(outcome)
+		}
+	}
+}
+
+func runServer(world *World) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatalln("не удалось запустить сервер:", err)
+	}
+	log.Println("сервер запущен на", listener.Addr())
+
+	serverGame := newGame(world)
+	closeRecorder := startRecording(serverGame)
+	defer closeRecorder()
+
+	srv := NewServer(serverGame, idleTimeout)
+	if err := srv.Serve(ctx, listener); err != nil {
+		log.Fatalln("ошибка сервера:", err)
+	}
+	log.Println("сервер остановлен")
+}
+
+func runReplay(world *World) {
+	transcript, err := LoadTranscript(replayFileName)
+	if err != nil {
+		log.Fatalln("не удалось прочитать запись игры:", err)
+	}
+
+	if err := transcript.Replay(world); err != nil {
+		log.Fatalln("расхождение с записью:", err)
+	}
+	fmt.Println("запись проиграна без расхождений, команд:", len(transcript.Entries))
+}
+
+// startRecording включает запись игры, если задан флаг -record, и возвращает функцию закрытия файла
+func startRecording(recordGame *Game) func() {
+	if seed != 0 {
+		recordGame.SetSeed(seed)
+	}
+
+	if recordFileName == "" {
+		return func() {}
+	}
+
+	file, err := os.Create(recordFileName)
+	if err != nil {
+		log.Fatalln("не удалось создать файл записи:", err)
+	}
+
+	recordGame.Recorder, err = NewRecorder(file, TranscriptHeader{
+		Seed:     recordGame.Seed,
+		World:    worldFileName,
+		Language: recordGame.Locale.Language,
+	})
+	if err != nil {
+		log.Fatalln("не удалось начать запись:", err)
+	}
+
+	return func() {
+		if err := file.Close(); err != nil {
+			log.Println("ошибка закрытия файла записи:", err)
+		}
 	}
 }
 
 func initGame() {
-	var kitchen Room
-	var corridor Room
-	var street Room
-	var appartment Room
-
-	tea := Item{
-		Name:         "чай",
-		ItemPosition: "на столе",
-	}
-
-	key := Item{
-		Name:         "ключи",
-		ItemPosition: "на столе",
-		CanApply: map[string]string{
-			"дверь": "дверь открыта",
-		},
-		UseItem: func(user *User) {
-			for key := range user.Position.ConnectionsSet {
-				user.Position.ConnectionsSet[key] = true
-			}
-		},
-	}
-
-	univer := Item{
-		Name:       "универ",
-		ItemAction: "идти в",
-	}
-
-	notes := Item{
-		Name:         "конспекты",
-		ItemPosition: "на столе",
-	}
-
-	backpack := Item{
-		Name:         "рюкзак",
-		ItemAction:   "собрать",
-		ItemPosition: "на стуле",
-	}
-
-	corridor = Room{
-		Name:            "коридор",
-		WalkDescription: "ничего интересного",
-		Connections:     []*Room{&kitchen, &appartment, &street},
-		ConnectionsSet: map[*Room]bool{
-			&kitchen:    true,
-			&appartment: true,
-			&street:     false,
-		},
-	}
-
-	street = Room{
-		Name:            "улица",
-		WalkDescription: "на улице весна",
-		Connections:     []*Room{&corridor},
-		ConnectionsSet: map[*Room]bool{
-			&corridor: false,
-		},
-		IsOutsideHome: true,
-	}
-
-	appartment = Room{
-		Name:            "комната",
-		WalkDescription: "ты в своей комнате",
-		Connections:     []*Room{&corridor},
-		ConnectionsSet: map[*Room]bool{
-			&corridor: true,
-		},
-
-		Items: []*RoomTarget{
-			{
-				Item:           &key,
-				IsNeededToFind: false,
-			},
-			{
-				Item:           &notes,
-				IsNeededToFind: false,
-			},
-			{
-				Item:           &backpack,
-				IsNeededToFind: false,
-			},
-		},
-	}
-
-	kitchen = Room{
-		Name:            "кухня",
-		Description:     "ты находишься на кухне, ",
-		WalkDescription: "кухня, ничего интересного",
-
-		Items: []*RoomTarget{
-			{
-				Item:           &tea,
-				IsNeededToFind: false,
-			},
-			{
-				Item:           &backpack,
-				IsNeededToFind: true,
-			},
-			{
-				Item:           &univer,
-				IsNeededToFind: true,
-			},
-		},
-
-		Connections: []*Room{&corridor},
-		ConnectionsSet: map[*Room]bool{
-			&corridor: true,
-		},
-
-		IsOutsideHome: false,
-	}
-
-	user.SetPosition(&kitchen)
-	user.Storage = &backpack
-	user.ClearItems()
-
-	gameMap.ClearMap()
-	gameMap.AddRoom(&corridor)
-	gameMap.AddRoom(&kitchen)
-	gameMap.AddRoom(&appartment)
-	gameMap.AddRoom(&street)
+	world, err := loadGameWorld()
+	if err != nil {
+		panic(err)
+	}
 
+	game = newGame(world)
+	joinGame()
 }
 
-func handleCommand(command string) string {
-	parameters := strings.Split(command, " ")
-	cmd := parameters[0]
-	args := parameters[1:]
+// joinGame добавляет в игру единственного игрока режима stdin
+func joinGame() {
+	var err error
+
+	player, err = game.AddPlayer(defaultPlayerName)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newGame создаёт игру с настройками из флагов
+func newGame(world *World) *Game {
+	newGame := NewGame(world)
+	if saveDir != "" {
+		newGame.SaveDir = saveDir
+	}
+	if locale, err := FindLocale(language); err == nil {
+		newGame.Locale = locale
+	}
+
+	return newGame
+}
+
+func loadGameWorld() (*World, error) {
+	if worldFileName != "" {
+		return LoadWorld(worldFileName)
+	}
+
+	return ParseWorld(defaultWorld)
+}
 
-	commandResult, err := user.DoCommand(cmd, args...)
+// handleCommand выполняет команду игрока; сообщение об окончании игры забирает вызывающий
+// через TakeOutcome и печатает после результата команды
+func handleCommand(command string) string {
+	commandResult, err := game.HandleCommand(player, command)
 	if err != nil {
 		fmt.Println(err)
 	}
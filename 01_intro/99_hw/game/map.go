@@ -1,13 +1,19 @@
 package main
 
-var gameMap Map
-
 type Map struct {
 	Rooms map[string]*Room
+	Items map[string]*Item
+
+	Start *Room
+	Quest Quest
+
+	// вещи можно носить только в контейнерах, например в рюкзаке
+	ContainersOnly bool
 }
 
 func (gameMap *Map) ClearMap() {
 	gameMap.Rooms = make(map[string]*Room)
+	gameMap.Items = make(map[string]*Item)
 }
 
 func (gameMap *Map) AddRoom(r *Room) {
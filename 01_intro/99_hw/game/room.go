@@ -1,9 +1,23 @@
 package main
 
+import "slices"
+
+// куда попадают выброшенные предметы
+const dropPosition = "на полу"
+
 type RoomTarget struct {
-	Item           *Item
-	IsNeededToFind bool
+	Item     *Item
+	Position string // если пусто - используется ItemPosition предмета
 }
+
+func (roomTarget *RoomTarget) ItemPosition() string {
+	if roomTarget.Position != "" {
+		return roomTarget.Position
+	}
+
+	return roomTarget.Item.ItemPosition
+}
+
 type Room struct {
 	Name            string
 	Description     string
@@ -14,4 +28,29 @@ type Room struct {
 
 	Items         []*RoomTarget
 	IsOutsideHome bool
+	ShowGoals     bool // показывать оставшиеся задания при осмотре комнаты
+}
+
+// FindItem ищет предмет в комнате и в открытых контейнерах в ней
+func (room *Room) FindItem(name string) *Item {
+	items := make([]*Item, 0, len(room.Items))
+	for _, roomTarget := range room.Items {
+		items = append(items, roomTarget.Item)
+	}
+
+	return findItem(items, name, false)
+}
+
+// RemoveItem убирает предмет, лежащий прямо в комнате
+func (room *Room) RemoveItem(item *Item) bool {
+	index := slices.IndexFunc(room.Items, func(roomTarget *RoomTarget) bool {
+		return roomTarget.Item == item
+	})
+	if index == -1 {
+		return false
+	}
+
+	room.Items = slices.Delete(room.Items, index, index+1)
+
+	return true
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// версия формата сохранения, увеличивается при несовместимых изменениях
+const saveVersion = 1
+
+const defaultSaveDir = "saves"
+
+var (
+	errInvalidSaveName    = errors.New("некорректное имя сохранения")
+	errSaveNotFound       = errors.New("нет такого сохранения")
+	errUnsupportedVersion = errors.New("неподдерживаемая версия сохранения")
+	errLoadWithOthers     = errors.New("в игре есть другие игроки, загрузка заменила бы мир и у них")
+)
+
+type SavePlayer struct {
+	Name     string    `json:"name"`
+	Position string    `json:"position"`
+	Items    []string  `json:"items"`
+	State    GameState `json:"state,omitempty"`
+	Goals    []string  `json:"goals,omitempty"`
+	Score    int       `json:"score,omitempty"`
+	Moves    int       `json:"moves,omitempty"`
+}
+
+// SaveFile - состояние мира в формате файла мира и состояния всех игроков
+type SaveFile struct {
+	Version int          `json:"version"`
+	World   World        `json:"world"`
+	Players []SavePlayer `json:"players"`
+}
+
+// Snapshot переводит текущее состояние игры обратно в описание мира
+func (game *Game) Snapshot() *SaveFile {
+	gameMap := &game.Map
+
+	save := &SaveFile{
+		Version: saveVersion,
+		World: World{
+			Start:          gameMap.Start.Name,
+			Quest:          gameMap.Quest,
+			ContainersOnly: gameMap.ContainersOnly,
+		},
+	}
+
+	for _, name := range sortedKeys(gameMap.Items) {
+		item := gameMap.Items[name]
+		worldItem := WorldItem{
+			Name:     item.Name,
+			Position: item.ItemPosition,
+			CanApply: item.CanApply,
+			Capacity: item.Capacity,
+			Closable: item.Closable,
+			Closed:   item.Closed,
+		}
+		for _, inner := range item.Contents {
+			worldItem.Contents = append(worldItem.Contents, inner.Name)
+		}
+
+		save.World.Items = append(save.World.Items, worldItem)
+	}
+
+	for _, name := range sortedKeys(gameMap.Rooms) {
+		room := gameMap.Rooms[name]
+		worldRoom := WorldRoom{
+			Name:            room.Name,
+			Description:     room.Description,
+			WalkDescription: room.WalkDescription,
+			OutsideHome:     room.IsOutsideHome,
+			ShowGoals:       room.ShowGoals,
+		}
+
+		for _, roomTarget := range room.Items {
+			worldRoom.Items = append(worldRoom.Items, WorldRoomItem{
+				Item:     roomTarget.Item.Name,
+				Position: roomTarget.Position,
+			})
+		}
+
+		for _, next := range room.Connections {
+			worldRoom.Connections = append(worldRoom.Connections, WorldConnection{
+				Room:   next.Name,
+				Locked: !room.ConnectionsSet[next],
+			})
+		}
+
+		save.World.Rooms = append(save.World.Rooms, worldRoom)
+	}
+
+	for _, name := range sortedKeys(game.Players) {
+		player := game.Players[name]
+		savePlayer := SavePlayer{
+			Name:     player.Name,
+			Position: player.Position.Name,
+			State:    player.State,
+			Score:    player.Score,
+			Moves:    player.Moves,
+		}
+
+		for item := range player.Items {
+			savePlayer.Items = append(savePlayer.Items, item.Name)
+		}
+		slices.Sort(savePlayer.Items)
+
+		for _, goal := range gameMap.Quest.Goals {
+			if player.CompletedGoals[goal.Name] {
+				savePlayer.Goals = append(savePlayer.Goals, goal.Name)
+			}
+		}
+
+		save.Players = append(save.Players, savePlayer)
+	}
+
+	return save
+}
+
+// Restore заменяет мир сохранённым, игроки без сохранённого состояния начинают со старта
+func (game *Game) Restore(save *SaveFile) error {
+	if save.Version != saveVersion {
+		return fmt.Errorf("%w: %d", errUnsupportedVersion, save.Version)
+	}
+
+	if err := save.World.Validate(); err != nil {
+		return err
+	}
+
+	var restored Map
+	save.World.Build(&restored)
+
+	players := make(map[string]SavePlayer, len(save.Players))
+	for _, savePlayer := range save.Players {
+		if _, exist := restored.Rooms[savePlayer.Position]; !exist {
+			return fmt.Errorf("игрок %q: %w %q", savePlayer.Name, errUnknownRoom, savePlayer.Position)
+		}
+		for _, item := range savePlayer.Items {
+			if _, exist := restored.Items[item]; !exist {
+				return fmt.Errorf("игрок %q: %w %q", savePlayer.Name, errUnknownItem, item)
+			}
+		}
+		players[savePlayer.Name] = savePlayer
+	}
+
+	game.Map = restored
+
+	for _, player := range game.Players {
+		player.ClearItems()
+		player.ClearGoals()
+
+		savePlayer, exist := players[player.Name]
+		if !exist {
+			player.SetPosition(restored.Start)
+			continue
+		}
+
+		player.SetPosition(restored.Rooms[savePlayer.Position])
+		player.State = savePlayer.State
+		player.Score = savePlayer.Score
+		player.Moves = savePlayer.Moves
+		player.outcomeReported = player.State != StatePlaying
+		for _, goal := range savePlayer.Goals {
+			player.CompletedGoals[goal] = true
+		}
+		for _, item := range savePlayer.Items {
+			player.Items[restored.Items[item]] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (game *Game) saveFileName(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errInvalidSaveName
+	}
+
+	return filepath.Join(game.SaveDir, name+".json"), nil
+}
+
+func (game *Game) saveGame(name string) error {
+	fileName, err := game.saveFileName(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(game.Snapshot(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(game.SaveDir, 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, data, 0o644) //nolint:gosec
+}
+
+// loadGame заменяет мир сохранённым; мир общий, поэтому загружать можно, только пока игрок в нём один
+func (game *Game) loadGame(name string) error {
+	fileName, err := game.saveFileName(name)
+	if err != nil {
+		return err
+	}
+	if len(game.Players) > 1 {
+		return errLoadWithOthers
+	}
+
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return errSaveNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	save := &SaveFile{}
+	if err := json.Unmarshal(data, save); err != nil {
+		return fmt.Errorf("ошибка разбора сохранения: %w", err)
+	}
+
+	return game.Restore(save)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	testGame.SaveDir = t.TempDir()
+	p := players[0]
+
+	steps := []gameCase{
+		{1, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{2, "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{3, "надеть рюкзак", "вы надели: рюкзак"},
+		{4, "взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{5, "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{6, "применить ключи дверь", "дверь открыта"},
+		{7, "сохранить first", "игра сохранена: first"},
+		{8, "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{9, "взять конспекты", "предмет добавлен в инвентарь: конспекты"},
+		{10, "осмотреться", "пустая комната. можно пройти - коридор"},
+		{11, "загрузить first", "игра загружена: first"},
+		{12, "осмотреться", "пустая комната. можно пройти - кухня, комната, улица"},
+		{13, "идти улица", "на улице весна. можно пройти - домой"},
+		{14, "идти коридор", "игра окончена"},
+		{15, "загрузить first", "игра загружена: first"},
+		{16, "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{17, "осмотреться", "на столе: конспекты. можно пройти - коридор"},
+		{18, "взять ключи", "нет такого"},
+		{19, "загрузить second", "не удалось загрузить игру: нет такого сохранения"},
+		{20, "сохранить ../first", "не удалось сохранить игру: некорректное имя сохранения"},
+	}
+
+	for _, step := range steps {
+		answer, _ := testGame.HandleCommand(p, step.command) //nolint:errcheck
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(testGame.SaveDir, "first.json")); err != nil {
+		t.Error("save file must exist:", err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan")
+	for _, command := range []string{"идти коридор", "идти комната", "надеть рюкзак", "взять ключи", "идти коридор", "применить ключи дверь"} {
+		testGame.HandleCommand(players[0], command) //nolint:errcheck
+	}
+
+	snapshot := testGame.Snapshot()
+	if err := testGame.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored := testGame.Snapshot(); !reflect.DeepEqual(snapshot, restored) {
+		t.Errorf("snapshot changed after restore:\n%+v\n%+v", snapshot, restored)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	testGame, _ := newTestGame(t, "Tristan")
+
+	snapshot := testGame.Snapshot()
+	snapshot.Version = saveVersion + 1
+	if err := testGame.Restore(snapshot); err == nil || !strings.Contains(err.Error(), errUnsupportedVersion.Error()) {
+		t.Error("expected unsupported version error, got", err)
+	}
+
+	snapshot = testGame.Snapshot()
+	snapshot.Players[0].Position = "чердак"
+	if err := testGame.Restore(snapshot); err == nil {
+		t.Error("expected unknown room error")
+	}
+}
+
+func TestLoadUnsupportedVersion(t *testing.T) {
+	testGame, players := newTestGame(t, defaultPlayerName)
+	testGame.SaveDir = t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(testGame.SaveDir, "future.json"), []byte(`{"version": 99}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	answer, _ := testGame.HandleCommand(players[0], "загрузить future") //nolint:errcheck
+	expected := "не удалось загрузить игру: неподдерживаемая версия сохранения"
+	if answer != expected {
+		t.Errorf("\n\tresult:  %s\n\texpected:%s", answer, expected)
+	}
+}
+
+// мир общий, и пока в игре есть кто-то ещё, загрузка запрещена
+func TestLoadWithOtherPlayers(t *testing.T) {
+	testGame, players := newTestGame(t, "Tristan", "Izolda")
+	testGame.SaveDir = t.TempDir()
+
+	steps := []gameCase{
+		{1, "сохранить first", "игра сохранена: first"},
+		{2, "загрузить first", "не удалось загрузить игру: " + errLoadWithOthers.Error()},
+	}
+	for _, step := range steps {
+		answer, _ := testGame.HandleCommand(players[0], step.command) //nolint:errcheck
+		if answer != step.answer {
+			t.Error("step", step.step,
+				"\n\tcmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+
+	testGame.RemovePlayer("Izolda")
+	if answer, _ := testGame.HandleCommand(players[0], "загрузить first"); answer != "игра загружена: first" { //nolint:errcheck
+		t.Error("load must work when the player is alone, got", answer)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultIdleTimeout = 5 * time.Minute
+
+// Server - сетевой (telnet-совместимый) фронтенд игры, на каждое соединение создаётся свой игрок
+type Server struct {
+	Game        *Game
+	IdleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func NewServer(game *Game, idleTimeout time.Duration) *Server {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	return &Server{
+		Game:        game,
+		IdleTimeout: idleTimeout,
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve принимает соединения, пока не отменён контекст, после чего закрывает все соединения и дожидается их обработчиков.
+// Ошибки Accept, кроме закрытого слушателя, не останавливают сервер: приём повторяется с растущей паузой
+func (srv *Server) Serve(ctx context.Context, listener net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stop:
+		}
+	}()
+
+	var retryDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+			// например, кончились файловые дескрипторы - ждём и пробуем снова, как net/http.Server
+			retryDelay = nextAcceptDelay(retryDelay)
+			log.Printf("ошибка приёма соединения: %v; повтор через %v", err, retryDelay)
+			select {
+			case <-time.After(retryDelay):
+				continue
+			case <-ctx.Done():
+			}
+		}
+		if err != nil {
+			srv.closeConns()
+			srv.wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		retryDelay = 0
+
+		if !srv.trackConn(conn) {
+			conn.Close()
+			continue
+		}
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer srv.untrackConn(conn)
+			srv.handleConn(conn)
+		}()
+	}
+}
+
+// nextAcceptDelay - пауза перед следующей попыткой Accept: от 5мс, вдвое больше каждый раз, но не больше секунды
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	const (
+		minDelay = 5 * time.Millisecond
+		maxDelay = time.Second
+	)
+
+	switch {
+	case delay == 0:
+		return minDelay
+	case delay*2 > maxDelay:
+		return maxDelay
+	}
+	return delay * 2
+}
+
+func (srv *Server) trackConn(conn net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.conns == nil {
+		return false
+	}
+	srv.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (srv *Server) untrackConn(conn net.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.conns, conn)
+	conn.Close()
+}
+
+func (srv *Server) closeConns() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+}
+
+type session struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (s *session) write(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := io.WriteString(s.conn, message+"\n"); err != nil {
+		log.Println("ошибка записи в соединение:", err)
+	}
+}
+
+func (srv *Server) handleConn(conn net.Conn) {
+	s := &session{conn: conn}
+	scanner := bufio.NewScanner(conn)
+
+	// до входа в игру сообщения пишутся на языке игры по умолчанию
+	var player *User
+	text := func(key string, params Params) string {
+		if player != nil {
+			return player.Text(key, params)
+		}
+		return srv.Game.Locale.Text(key, params)
+	}
+
+	readLine := func() (string, bool) {
+		conn.SetReadDeadline(time.Now().Add(srv.IdleTimeout)) //nolint:errcheck
+		if !scanner.Scan() {
+			var netErr net.Error
+			if errors.As(scanner.Err(), &netErr) && netErr.Timeout() {
+				s.write(text(msgIdleTimeout, nil))
+			}
+			return "", false
+		}
+		return strings.TrimRight(scanner.Text(), "\r"), true
+	}
+
+	for player == nil {
+		s.write(text(msgEnterName, nil))
+		name, ok := readLine()
+		if !ok {
+			return
+		}
+
+		newPlayer, err := srv.Game.AddPlayer(strings.TrimSpace(name))
+		if err != nil {
+			s.write(srv.Game.Locale.ErrorText(err))
+			continue
+		}
+		player = newPlayer
+	}
+	defer srv.Game.RemovePlayer(player.Name)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case message := <-player.Inbox:
+				s.write(message)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s.write(text(msgWelcome, Params{"Player": player.Name}))
+
+	for {
+		line, ok := readLine()
+		if !ok || line == "exit" {
+			return
+		}
+
+		result, err := srv.Game.HandleCommand(player, line)
+		if err != nil {
+			s.write(err.Error())
+			continue
+		}
+		if result != "" {
+			s.write(result)
+		}
+		if outcome := srv.Game.TakeOutcome(player); outcome != "" {
+			s.write(outcome)
+		}
+	}
+}
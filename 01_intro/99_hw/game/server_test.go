@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, addr string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, line+"\r\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) expect(expected string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatal("read error:", err, "expected:", expected)
+	}
+	if line = strings.TrimRight(line, "\n"); line != expected {
+		c.t.Errorf("\n\tresult:  %s\n\texpected:%s", line, expected)
+	}
+}
+
+func (c *testClient) login(name string) {
+	c.t.Helper()
+	c.expect("введите имя")
+	c.send(name)
+	c.expect("добро пожаловать, " + name)
+}
+
+func startTestServer(t *testing.T, idleTimeout time.Duration) (string, context.CancelFunc, chan error) {
+	t.Helper()
+
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := NewServer(NewGame(world), idleTimeout)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx, listener)
+	}()
+	t.Cleanup(cancel)
+
+	return listener.Addr().String(), cancel, done
+}
+
+func TestServer(t *testing.T) {
+	addr, _, _ := startTestServer(t, time.Second)
+
+	tristan := dialTestServer(t, addr)
+	tristan.login("Tristan")
+
+	izolda := dialTestServer(t, addr)
+	izolda.expect("введите имя")
+	izolda.send("Tristan")
+	izolda.expect(errPlayerExists.Error())
+	izolda.expect("введите имя")
+	izolda.send("Izolda")
+	izolda.expect("добро пожаловать, Izolda")
+
+	tristan.send("осмотреться")
+	tristan.expect("ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор. Кроме вас тут ещё Izolda")
+
+	izolda.send("сказать привет")
+	izolda.expect("Izolda говорит: привет")
+	tristan.expect("Izolda говорит: привет")
+
+	tristan.send("завтракать")
+	tristan.expect("неизвестная команда")
+
+	izolda.send("exit")
+	if _, err := izolda.reader.ReadString('\n'); err != io.EOF {
+		t.Fatal("expected closed connection, got", err)
+	}
+	tristan.send("осмотреться")
+	tristan.expect("ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор")
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	addr, _, _ := startTestServer(t, 100*time.Millisecond)
+
+	client := dialTestServer(t, addr)
+	client.login("Tristan")
+	client.expect("отключение по таймауту")
+
+	if _, err := client.reader.ReadString('\n'); err != io.EOF {
+		t.Error("expected closed connection, got", err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	addr, cancel, done := startTestServer(t, time.Minute)
+
+	client := dialTestServer(t, addr)
+	client.login("Tristan")
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+
+	if _, err := client.reader.ReadString('\n'); err != io.EOF {
+		t.Error("expected closed connection, got", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("server must not accept connections after shutdown")
+	}
+}
+
+// flakyListener сначала несколько раз не может принять соединение, как при нехватке дескрипторов
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, syscall.EMFILE
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerAcceptRetry(t *testing.T) {
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(NewGame(world), time.Second).Serve(ctx, &flakyListener{Listener: listener, failures: 3})
+	}()
+
+	client := dialTestServer(t, listener.Addr().String())
+	client.login("Tristan")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestNotifyFullInbox(t *testing.T) {
+	_, players := newTestGame(t, "Tristan")
+
+	for i := 0; i < inboxSize+1; i++ {
+		players[0].Notify("привет")
+	}
+	if len(players[0].Inbox) != inboxSize {
+		t.Error("expected full inbox, got", len(players[0].Inbox))
+	}
+}
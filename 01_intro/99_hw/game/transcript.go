@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// версия формата записи игры; в версии 1 не было сообщений игрокам, такие записи сверяются без них
+const transcriptVersion = 2
+
+var (
+	errEmptyTranscript   = errors.New("пустая запись игры")
+	errTranscriptVersion = errors.New("неподдерживаемая версия записи игры")
+	errBadTranscript     = errors.New("ошибка разбора записи игры")
+)
+
+type TranscriptHeader struct {
+	Version int       `json:"version"`
+	Seed    int64     `json:"seed"`
+	World   string    `json:"world,omitempty"`
+	Started time.Time `json:"started"`
+
+	// язык игры по умолчанию, пустой - русский
+	Language string `json:"language,omitempty"`
+}
+
+// события записи, кроме команд: игрок вошёл в игру или вышел из неё
+const (
+	eventJoin  = "join"
+	eventLeave = "leave"
+)
+
+type TranscriptEntry struct {
+	Time    time.Time `json:"time"`
+	Player  string    `json:"player"`
+	Event   string    `json:"event,omitempty"`
+	Command string    `json:"command,omitempty"`
+	Output  string    `json:"output,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Outcome string    `json:"outcome,omitempty"`
+
+	// что команда отправила другим игрокам ("сказать", "сказать_игроку")
+	Messages []TranscriptMessage `json:"messages,omitempty"`
+}
+
+type TranscriptMessage struct {
+	Player string `json:"player"`
+	Text   string `json:"text"`
+}
+
+// Transcript - запись игры: заголовок и команды игроков с ответами в порядке выполнения
+type Transcript struct {
+	Header  TranscriptHeader
+	Entries []TranscriptEntry
+}
+
+// Recorder пишет запись игры в формате json lines: первой строкой заголовок, дальше по строке на команду
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func NewRecorder(w io.Writer, header TranscriptHeader) (*Recorder, error) {
+	recorder := &Recorder{
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+
+	header.Version = transcriptVersion
+	if header.Started.IsZero() {
+		header.Started = recorder.now()
+	}
+
+	if err := recorder.enc.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+func (recorder *Recorder) Record(entry TranscriptEntry) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	entry.Time = recorder.now()
+
+	return recorder.enc.Encode(entry)
+}
+
+func ReadTranscript(r io.Reader) (*Transcript, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errEmptyTranscript
+	}
+
+	transcript := &Transcript{}
+	if err := json.Unmarshal(scanner.Bytes(), &transcript.Header); err != nil {
+		return nil, fmt.Errorf("%w: заголовок: %w", errBadTranscript, err)
+	}
+	if transcript.Header.Version < 1 || transcript.Header.Version > transcriptVersion {
+		return nil, fmt.Errorf("%w: %d", errTranscriptVersion, transcript.Header.Version)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := TranscriptEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%w: строка %d: %w", errBadTranscript, line, err)
+		}
+		transcript.Entries = append(transcript.Entries, entry)
+	}
+
+	return transcript, scanner.Err()
+}
+
+func LoadTranscript(fileName string) (*Transcript, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadTranscript(file)
+}
+
+// newTranscriptEntry описывает выполненную команду; исход игры пишется, только если он изменился этой командой
+func newTranscriptEntry(player *User, command, output string, err error, stateBefore GameState, messages []TranscriptMessage) TranscriptEntry {
+	entry := TranscriptEntry{
+		Player:   player.Name,
+		Command:  command,
+		Output:   output,
+		Messages: messages,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if player.State != stateBefore {
+		entry.Outcome = player.Outcome()
+	}
+
+	return entry
+}
+
+// ReplayError - первое расхождение между записью и повторным прогоном
+type ReplayError struct {
+	Step     int
+	Player   string
+	Command  string
+	Field    string
+	Expected string
+	Got      string
+}
+
+func (replayErr *ReplayError) Error() string {
+	return fmt.Sprintf("шаг %d, %s: %q - %s отличается\n\tожидалось: %q\n\tполучено:  %q",
+		replayErr.Step, replayErr.Player, replayErr.Command, replayErr.Field, replayErr.Expected, replayErr.Got)
+}
+
+// Replay заново проигрывает запись на мире world и сверяет каждый ответ
+func (transcript *Transcript) Replay(world *World) error {
+	replayGame := NewGame(world)
+	replayGame.SetSeed(transcript.Header.Seed)
+	if transcript.Header.Language != "" {
+		locale, err := FindLocale(transcript.Header.Language)
+		if err != nil {
+			return err
+		}
+		replayGame.Locale = locale
+	}
+
+	// сохранения из записи не должны перетирать настоящие
+	saveDir, err := os.MkdirTemp("", "replay-saves")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(saveDir)
+	replayGame.SaveDir = saveDir
+
+	for i, entry := range transcript.Entries {
+		switch entry.Event {
+		case eventJoin:
+			if _, err := replayGame.AddPlayer(entry.Player); err != nil {
+				return err
+			}
+			continue
+		case eventLeave:
+			replayGame.RemovePlayer(entry.Player)
+			continue
+		}
+
+		// игрок мог войти до начала записи
+		player, exist := replayGame.Players[entry.Player]
+		if !exist {
+			var err error
+			if player, err = replayGame.AddPlayer(entry.Player); err != nil {
+				return err
+			}
+		}
+
+		state := player.State
+		output, err := replayGame.HandleCommand(player, entry.Command)
+		record := newTranscriptEntry(player, entry.Command, output, err, state, replayGame.notified)
+		// сообщения сверяются по записи, из очередей их никто не читает
+		for _, other := range replayGame.Players {
+			drainInbox(other)
+		}
+
+		fields := []string{"ответ", "ошибка", "исход игры", "сообщения игрокам"}
+		got := []string{record.Output, record.Error, record.Outcome, formatMessages(record.Messages)}
+		expected := []string{entry.Output, entry.Error, entry.Outcome, formatMessages(entry.Messages)}
+		if transcript.Header.Version == 1 {
+			fields = fields[:3]
+		}
+		for j, field := range fields {
+			if got[j] != expected[j] {
+				return &ReplayError{
+					Step:     i + 1,
+					Player:   entry.Player,
+					Command:  entry.Command,
+					Field:    field,
+					Expected: expected[j],
+					Got:      got[j],
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func formatMessages(messages []TranscriptMessage) string {
+	lines := make([]string, len(messages))
+	for i, message := range messages {
+		lines[i] = message.Player + ": " + message.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+func drainInbox(player *User) {
+	for {
+		select {
+		case <-player.Inbox:
+		default:
+			return
+		}
+	}
+}
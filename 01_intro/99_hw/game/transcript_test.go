@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	testGame, _ := newTestGame(t)
+
+	buf := &bytes.Buffer{}
+	recorder, err := NewRecorder(buf, TranscriptHeader{Seed: 42, World: "world.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGame.Recorder = recorder
+
+	players := make([]*User, 0, 2)
+	for _, name := range []string{"Tristan", "Izolda"} {
+		player, err := testGame.AddPlayer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, player)
+	}
+
+	for _, command := range []string{"осмотреться", "идти коридор", "идти", "идти комната", "надеть рюкзак"} {
+		testGame.HandleCommand(players[0], command)            //nolint:errcheck
+		testGame.HandleCommand(players[1], "сказать "+command) //nolint:errcheck
+	}
+
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcript, err := ReadTranscript(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript.Header.Seed != 42 || transcript.Header.World != "world.json" || len(transcript.Entries) != 12 {
+		t.Fatalf("unexpected transcript: %+v", transcript.Header)
+	}
+	if transcript.Entries[6].Error == "" {
+		t.Error("command error must be recorded")
+	}
+	expectedMessages := []TranscriptMessage{{Player: "Tristan", Text: "Izolda говорит: осмотреться"}}
+	if !reflect.DeepEqual(transcript.Entries[3].Messages, expectedMessages) {
+		t.Errorf("unexpected messages: %+v", transcript.Entries[3].Messages)
+	}
+
+	if err := transcript.Replay(world); err != nil {
+		t.Error("replay of an unchanged game must not diverge:", err)
+	}
+
+	transcript.Entries[10].Output = "вы надели: шляпу"
+	err = transcript.Replay(world)
+
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) {
+		t.Fatal("expected ReplayError, got", err)
+	}
+	if replayErr.Step != 11 || replayErr.Expected != "вы надели: шляпу" || replayErr.Got != "вы надели: рюкзак" {
+		t.Errorf("unexpected divergence: %+v", replayErr)
+	}
+
+	transcript.Entries[10].Output = "вы надели: рюкзак"
+	transcript.Entries[3].Messages[0].Text = "Izolda говорит: привет"
+	err = transcript.Replay(world)
+	if !errors.As(err, &replayErr) {
+		t.Fatal("expected ReplayError, got", err)
+	}
+	if replayErr.Step != 4 || replayErr.Field != "сообщения игрокам" {
+		t.Errorf("unexpected divergence: %+v", replayErr)
+	}
+}
+
+func TestReadTranscriptErrors(t *testing.T) {
+	if _, err := ReadTranscript(strings.NewReader("")); !errors.Is(err, errEmptyTranscript) {
+		t.Error("expected errEmptyTranscript, got", err)
+	}
+	if _, err := ReadTranscript(strings.NewReader(`{"version": 100}`)); !errors.Is(err, errTranscriptVersion) {
+		t.Error("expected errTranscriptVersion, got", err)
+	}
+	if _, err := ReadTranscript(strings.NewReader("{\"version\": 1}\n{")); !errors.Is(err, errBadTranscript) {
+		t.Error("expected errBadTranscript, got", err)
+	}
+}
+
+// записи из testdata - регрессионные тесты: игра со встроенным миром должна отвечать так же
+func TestReplayTestdata(t *testing.T) {
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no transcripts in testdata")
+	}
+
+	for _, fileName := range files {
+		transcript, err := LoadTranscript(fileName)
+		if err != nil {
+			t.Error(fileName, err)
+			continue
+		}
+		if err := transcript.Replay(world); err != nil {
+			t.Error(fileName, err)
+		}
+	}
+}
@@ -1,227 +1,422 @@
 package main
 
 import (
+	"log"
 	"slices"
 	"strings"
 )
 
-var user User
+type GameState int
+
+const (
+	StatePlaying GameState = iota
+	StateWon
+	StateLost
+)
 
 type User struct {
+	Name     string
 	Position *Room
-	Storage  *Item
-	Items    map[*Item]struct{}
+
+	// предметы, которые игрок несёт сам; остальные лежат внутри них
+	Items map[*Item]struct{}
+
+	State          GameState
+	CompletedGoals map[string]bool
+	Score          int
+	Moves          int
+
+	outcomeReported bool
+
+	// язык, на котором игрок вводит команды и получает ответы
+	Locale *Locale
+
+	// сообщения от других игроков
+	Inbox chan string
+
+	game *Game
 }
 
 func (user *User) SetPosition(room *Room) {
 	user.Position = room
 }
 
-func (user *User) ItemRoomIndex(item string) (int, error) {
-	itemIndex := slices.IndexFunc(user.Position.Items, func(n *RoomTarget) bool {
-		return n.Item.Name == item
-	})
-
-	if itemIndex == -1 {
-		return itemIndex, errItemNotInRoom
+// Notify кладёт сообщение в очередь игрока, не блокируясь. Если игрок не успевает читать
+// и в очереди уже inboxSize сообщений, новое теряется - об этом пишется в лог
+func (user *User) Notify(message string) {
+	if user.game != nil {
+		user.game.notified = append(user.game.notified, TranscriptMessage{Player: user.Name, Text: message})
 	}
 
-	return itemIndex, nil
-}
-
-func (user *User) TakeItem(item string) error {
-	itemIndex, err := user.ItemRoomIndex(item)
-	if err != nil {
-		return err
+	select {
+	case user.Inbox <- message:
+	default:
+		log.Printf("очередь сообщений игрока %s переполнена, сообщение потеряно: %s", user.Name, message)
 	}
+}
 
-	user.Items[user.Position.Items[itemIndex].Item] = struct{}{}
-	user.Position.Items[itemIndex].IsNeededToFind = false
-	user.Position.Items = append(user.Position.Items[:itemIndex], user.Position.Items[itemIndex+1:]...)
-
-	return nil
+// Text - сообщение каталога на языке игрока
+func (user *User) Text(key string, params Params) string {
+	return user.Locale.Text(key, params)
 }
 
 func (user *User) ClearItems() {
 	user.Items = make(map[*Item]struct{})
 }
 
-func (user *User) DoCommand(command string, parameters ...string) (string, error) {
-	var result string
+// HasItem проверяет, есть ли предмет у игрока, в том числе в закрытых контейнерах
+func (user *User) HasItem(name string) bool {
+	return findItem(user.carried(), name, true) != nil
+}
 
-	switch command {
+// InventoryItem ищет предмет у игрока и в его открытых контейнерах
+func (user *User) InventoryItem(name string) *Item {
+	return findItem(user.carried(), name, false)
+}
 
-	case CommandLookAround.CommandName:
-		result = user.HandleLookAround(parameters...)
+// carried - предметы, которые игрок несёт сам, а не в контейнерах, по порядку имён
+func (user *User) carried() []*Item {
+	items := make([]*Item, 0, len(user.Items))
+	for item := range user.Items {
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b *Item) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 
-	case CommandWalk.CommandName:
-		if len(parameters) < CommandWalk.ArgsNum {
-			return "", errInvalidCommand
-		}
-		result = user.HandleWalk(parameters[0], parameters[1:]...)
+	return items
+}
 
-	case CommandTake.CommandName, CommandWear.CommandName:
-		if len(parameters) < CommandTake.ArgsNum {
-			return "", errInvalidCommand
-		}
-		result = user.HandleTakeItem(command, parameters[0], parameters[1:]...)
+// FreeContainer - первый контейнер игрока, в котором есть место
+func (user *User) FreeContainer() *Item {
+	queue := user.carried()
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
 
-	case CommandApply.CommandName:
-		if len(parameters) < CommandApply.ArgsNum {
-			return "", errInvalidCommand
+		if item.HasSpace() {
+			return item
 		}
-		result = user.HandleApply(parameters[0], parameters[1], parameters[2:]...)
+		if !item.Closed {
+			queue = append(queue, item.Contents...)
+		}
+	}
 
+	return nil
+}
+
+// detach убирает предмет оттуда, где он лежит: из контейнера, из рук игрока или из комнаты
+func (user *User) detach(item *Item) {
+	switch {
+	case item.Container != nil:
+		item.Container.Remove(item)
 	default:
-		result = UnknownCommand.CommandName
+		delete(user.Items, item)
+		user.Position.RemoveItem(item)
+	}
+}
+
+// stow забирает предмет оттуда, где он лежит, и кладёт игроку: контейнеры игрок несёт сам,
+// остальное - в свои контейнеры. false - положить некуда, предмет остаётся на месте
+func (user *User) stow(item *Item) bool {
+	var container *Item
+	if !item.IsContainer() {
+		container = user.FreeContainer()
+		if container == nil && user.game.Map.ContainersOnly {
+			return false
+		}
+	}
 
+	user.detach(item)
+	if container != nil {
+		container.Put(item)
+	} else {
+		user.Items[item] = struct{}{}
 	}
 
-	return result, nil
+	return true
+}
+
+func (user *User) DoCommand(command string, parameters ...string) (string, error) {
+	return user.game.Commands.Dispatch(user, command, parameters...)
 }
 
 func (user *User) HandleApply(what string, toWhat string, _ ...string) string {
-	var result strings.Builder
-	var item *Item
-	for key := range user.Items {
-		if key.Name == what {
-			item = key
-			break
-		}
+	item := user.InventoryItem(what)
+	if item == nil {
+		return user.Text(msgNotInInventory, Params{"Item": what})
 	}
+
+	reaction, ok := item.CanApply[toWhat]
+	if !ok {
+		return user.Text(msgCannotApply, nil)
+	}
+
+	return reaction.Do(user)
+}
+
+// HandleTakeItem берёт предмет из комнаты: контейнеры игрок несёт сам, остальное кладёт в свои контейнеры
+func (user *User) HandleTakeItem(what string, wear bool) string {
+	item := user.Position.FindItem(what)
 	if item == nil {
-		return "нет предмета в инвентаре - " + what
+		return user.Text(msgNoSuchItem, nil)
 	}
 
-	if item.CanApply != nil {
-		if actionResult, ok := item.CanApply[toWhat]; !ok {
-			result.WriteString("не к чему применить")
-		} else {
-			item.UseItem(user)
-			result.WriteString(actionResult)
-		}
+	if !user.stow(item) {
+		return user.Text(msgNowhereToPut, nil)
 	}
 
-	return result.String()
+	if wear {
+		return user.Text(msgWear, Params{"Item": what})
+	}
+
+	return user.Text(msgTake, Params{"Item": what})
 }
 
-func (user *User) HandleTakeItem(command string, what string, _ ...string) string {
-	storeName := user.Storage.Name
+// HandlePutItem выкладывает предмет из инвентаря в текущую комнату вместе с его содержимым,
+// если position пустой - предмет кладётся на своё обычное место
+func (user *User) HandlePutItem(what string, position string) string {
+	item := user.InventoryItem(what)
+	if item == nil {
+		return user.Text(msgNotInInventory, Params{"Item": what})
+	}
 
-	var checkStore bool
-	for key := range user.Items {
-		if key.Name == storeName {
-			checkStore = true
-			break
-		}
+	user.detach(item)
+	user.Position.Items = append(user.Position.Items, &RoomTarget{
+		Item:     item,
+		Position: position,
+	})
+
+	if position == dropPosition {
+		return user.Text(msgDrop, Params{"Item": what})
 	}
 
-	if !checkStore && what != storeName {
-		_, err := user.ItemRoomIndex(what)
-		if err != nil {
-			return err.Error()
-		}
+	return user.Text(msgPut, Params{"Item": what})
+}
 
-		return "некуда класть"
+// HandlePutInto перекладывает предмет из инвентаря в контейнер у игрока или в комнате
+func (user *User) HandlePutInto(what string, into string) string {
+	item := user.InventoryItem(what)
+	if item == nil {
+		return user.Text(msgNotInInventory, Params{"Item": what})
 	}
 
-	err := user.TakeItem(what)
-	if err != nil {
-		return err.Error()
+	container := user.reachableItem(into)
+	if container == nil {
+		return user.Text(msgNoSuchItem, nil)
 	}
 
-	var result strings.Builder
+	params := Params{"Item": what, "Container": into}
+	switch {
+	case !container.IsContainer():
+		return user.Text(msgNotContainer, params)
+	case container == item || item.Holds(container):
+		return user.Text(msgIntoItself, params)
+	case container.Closed:
+		return user.Text(msgOpenFirst, params)
+	case !container.HasSpace():
+		return user.Text(msgContainerFull, params)
+	}
+
+	user.detach(item)
+	container.Put(item)
 
-	switch command {
-	case CommandWear.CommandName:
-		result.WriteString("вы надели")
+	return user.Text(msgPutInto, params)
+}
 
-	case CommandTake.CommandName:
-		result.WriteString("предмет добавлен в инвентарь")
+// HandleOpen открывает или закрывает контейнер у игрока или в комнате
+func (user *User) HandleOpen(what string, open bool) string {
+	item := user.reachableItem(what)
+	if item == nil {
+		return user.Text(msgNoSuchItem, nil)
 	}
 
-	result.WriteString(": " + what)
+	cannot, already, done := msgCannotClose, msgAlreadyClosed, msgClosed
+	if open {
+		cannot, already, done = msgCannotOpen, msgAlreadyOpen, msgOpened
+	}
 
-	return result.String()
+	params := Params{"Item": what}
+	switch {
+	case !item.Closable:
+		return user.Text(cannot, params)
+	case item.Closed != open:
+		return user.Text(already, params)
+	}
+
+	item.Closed = !open
+
+	return user.Text(done, params)
+}
+
+// reachableItem ищет предмет сначала у игрока, потом в комнате
+func (user *User) reachableItem(name string) *Item {
+	if item := user.InventoryItem(name); item != nil {
+		return item
+	}
+
+	return user.Position.FindItem(name)
+}
+
+func (user *User) HandleInventory() string {
+	if len(user.Items) == 0 {
+		return user.Text(msgInventoryEmpty, nil)
+	}
+
+	names := make([]string, 0, len(user.Items))
+	for item := range user.Items {
+		names = append(names, DisplayItem(user, item))
+	}
+	slices.Sort(names)
+
+	return user.Text(msgInventory, Params{"Items": names})
 }
 
 func (user *User) HandleLookAround(_ ...string) string {
 	var result strings.Builder
 
 	if len(user.Position.Items) > 0 {
-		result.WriteString(user.Position.Description)
+		result.WriteString(user.Locale.Word(user.Position.Description))
 	} else {
-		result.WriteString("пустая комната")
+		result.WriteString(user.Text(msgEmptyRoom, nil))
 	}
 
 	result.WriteString(DisplayItems(user))
-	result.WriteString(DisplayRoomTarget(user))
+	result.WriteString(DisplayGoals(user))
 	result.WriteString(DisplayPossibleMoves(user))
+	result.WriteString(DisplayPlayers(user))
 
 	return result.String()
 }
 
+func (user *User) HandleSay(words ...string) string {
+	params := Params{"Player": user.Name, "Text": strings.Join(words, " ")}
+
+	for _, other := range user.game.PlayersInRoom(user) {
+		other.Notify(other.Text(msgSay, params))
+	}
+
+	return user.Text(msgSay, params)
+}
+
+func (user *User) HandleSayPlayer(name string, words ...string) string {
+	var receiver *User
+	for _, other := range user.game.PlayersInRoom(user) {
+		if other.Name == name {
+			receiver = other
+			break
+		}
+	}
+
+	if receiver == nil {
+		return user.Text(msgNoSuchPlayer, nil)
+	}
+
+	if len(words) == 0 {
+		receiver.Notify(receiver.Text(msgSayPlayerSilent, Params{"Player": user.Name}))
+	} else {
+		receiver.Notify(receiver.Text(msgSayPlayer, Params{"Player": user.Name, "Text": strings.Join(words, " ")}))
+	}
+
+	return ""
+}
+
+func (user *User) HandleSave(name string) string {
+	if err := user.game.saveGame(name); err != nil {
+		return user.Text(msgSaveFailed, Params{"Error": user.Locale.ErrorText(err)})
+	}
+
+	return user.Text(msgSaved, Params{"Name": name})
+}
+
+func (user *User) HandleLoad(name string) string {
+	if err := user.game.loadGame(name); err != nil {
+		return user.Text(msgLoadFailed, Params{"Error": user.Locale.ErrorText(err)})
+	}
+
+	return user.Text(msgLoaded, Params{"Name": name})
+}
+
+// HandleLanguage переключает язык игрока, язык можно указать кодом или названием
+func (user *User) HandleLanguage(language string) string {
+	for _, code := range Languages() {
+		locale := locales[code]
+		if language == code || strings.EqualFold(language, locale.Name) {
+			user.Locale = locale
+			return user.Text(msgLanguage, Params{"Name": locale.Name})
+		}
+	}
+
+	return user.Text(msgUnknownLanguage, Params{"Languages": Languages()})
+}
+
 func (user *User) HandleWalk(where string, _ ...string) string {
-	isDoorOpen, exists := user.Position.ConnectionsSet[gameMap.Rooms[where]]
+	isDoorOpen, exists := user.Position.ConnectionsSet[user.game.Map.Rooms[where]]
 	if !exists {
-		return "нет пути в " + where
+		return user.Text(msgNoPath, Params{"Room": where})
 	}
 	if !isDoorOpen {
-		return "дверь закрыта"
+		return user.Text(msgDoorClosed, nil)
 	}
 
-	user.SetPosition(gameMap.Rooms[where])
+	user.SetPosition(user.game.Map.Rooms[where])
 
 	var result strings.Builder
 
-	result.WriteString(user.Position.WalkDescription)
+	result.WriteString(user.Locale.Word(user.Position.WalkDescription))
 	result.WriteString(DisplayPossibleMoves(user))
+	result.WriteString(DisplayPlayers(user))
 
 	return result.String()
 }
 
 func DisplayPossibleMoves(user *User) string {
-	var builder strings.Builder
-
-	builder.WriteString(". можно пройти - ")
-
-	for i, room := range user.Position.Connections {
+	rooms := make([]string, 0, len(user.Position.Connections))
+	for _, room := range user.Position.Connections {
 		if user.Position.IsOutsideHome {
-			builder.WriteString("домой")
+			rooms = append(rooms, user.Text(msgHome, nil))
 		} else {
-			builder.WriteString(room.Name)
-		}
-		if i < len(user.Position.Connections)-1 {
-			builder.WriteString(", ")
+			rooms = append(rooms, user.Locale.Word(room.Name))
 		}
 	}
 
-	return builder.String()
+	return user.Text(msgPossibleMoves, Params{"Rooms": rooms})
+}
+
+func DisplayPlayers(user *User) string {
+	players := user.game.PlayersInRoom(user)
+	if len(players) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(players))
+	for _, player := range players {
+		names = append(names, player.Name)
+	}
+
+	return user.Text(msgOtherPlayers, Params{"Players": names})
 }
 
 func DisplayItems(user *User) string {
 	var builder strings.Builder
-	var items []*Item
+	var items []*RoomTarget
 
-	for _, roomTarget := range user.Position.Items {
-		if !roomTarget.IsNeededToFind {
-			items = append(items, roomTarget.Item)
-		}
-	}
+	items = append(items, user.Position.Items...)
 
 	if len(items) == 0 {
 		return ""
 	}
 
-	itemPlace := items[0].ItemPosition
-	builder.WriteString(items[0].ItemPosition + ": ")
+	itemPlace := items[0].ItemPosition()
+	builder.WriteString(user.Text(msgItemsAt, Params{"Position": itemPlace}))
 
 	for i, item := range items {
-		if item.ItemPosition != itemPlace {
-			builder.WriteString(item.ItemPosition + ": ")
+		if item.ItemPosition() != itemPlace {
+			itemPlace = item.ItemPosition()
+			builder.WriteString(user.Text(msgItemsAt, Params{"Position": itemPlace}))
 		}
 
-		builder.WriteString(item.Name)
+		builder.WriteString(DisplayItem(user, item.Item))
 
 		if i < len(items)-1 {
 			builder.WriteString(", ")
@@ -231,32 +426,17 @@ func DisplayItems(user *User) string {
 	return builder.String()
 }
 
-func DisplayRoomTarget(user *User) string {
-	var builder strings.Builder
-	var targetItems []*RoomTarget
-
-	for _, roomTarget := range user.Position.Items {
-		if roomTarget.IsNeededToFind {
-			targetItems = append(targetItems, roomTarget)
-		}
+// DisplayItem - имя предмета, а для открытого контейнера ещё и его содержимое в скобках
+func DisplayItem(user *User, item *Item) string {
+	name := user.Locale.Word(item.Name)
+	if item.Closed || len(item.Contents) == 0 {
+		return name
 	}
 
-	if len(targetItems) == 0 {
-		return ""
-	}
-
-	builder.WriteString(", надо ")
-	for i, targetItem := range targetItems {
-		if _, have := user.Items[targetItem.Item]; have {
-			continue
-		}
-
-		builder.WriteString(targetItem.Item.ItemAction + " " + targetItem.Item.Name)
-
-		if i < len(targetItems)-1 {
-			builder.WriteString(" и ")
-		}
+	contents := make([]string, 0, len(item.Contents))
+	for _, inner := range item.Contents {
+		contents = append(contents, DisplayItem(user, inner))
 	}
 
-	return builder.String()
+	return name + " (" + strings.Join(contents, ", ") + ")"
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// мир по умолчанию: кухня, коридор, комната и улица
+//
+//go:embed world.json
+var defaultWorld []byte
+
+var (
+	errUnknownRoom   = errors.New("неизвестная комната")
+	errUnknownItem   = errors.New("неизвестный предмет")
+	errDuplicateName = errors.New("повторяющееся имя")
+	errEmptyName     = errors.New("пустое имя")
+	errNotContainer  = errors.New("предмет не контейнер")
+	errOverCapacity  = errors.New("не хватает места в контейнере")
+	errPlacedTwice   = errors.New("предмет лежит в нескольких местах")
+	errNotClosable   = errors.New("контейнер нельзя закрыть")
+	errItemCycle     = errors.New("контейнер лежит сам в себе")
+)
+
+type WorldItem struct {
+	Name     string               `json:"name"`
+	Position string               `json:"position,omitempty"`
+	CanApply map[string]*Reaction `json:"can_apply,omitempty"`
+
+	// контейнер: вместимость и предметы, которые лежат внутри
+	Capacity int      `json:"capacity,omitempty"`
+	Closable bool     `json:"closable,omitempty"`
+	Closed   bool     `json:"closed,omitempty"`
+	Contents []string `json:"contents,omitempty"`
+}
+
+type WorldRoomItem struct {
+	Item     string `json:"item"`
+	Position string `json:"position,omitempty"`
+}
+
+type WorldConnection struct {
+	Room   string `json:"room"`
+	Locked bool   `json:"locked"`
+}
+
+type WorldRoom struct {
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	WalkDescription string            `json:"walk_description"`
+	OutsideHome     bool              `json:"outside_home,omitempty"`
+	ShowGoals       bool              `json:"show_goals,omitempty"`
+	Items           []WorldRoomItem   `json:"items"`
+	Connections     []WorldConnection `json:"connections"`
+}
+
+type World struct {
+	Start string      `json:"start"`
+	Items []WorldItem `json:"items"`
+	Rooms []WorldRoom `json:"rooms"`
+
+	// вещи можно носить только в контейнерах: без рюкзака брать некуда
+	ContainersOnly bool `json:"containers_only,omitempty"`
+
+	Quest
+}
+
+func LoadWorld(fileName string) (*World, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseWorld(data)
+}
+
+func ParseWorld(data []byte) (*World, error) {
+	world := &World{}
+	if err := json.Unmarshal(data, world); err != nil {
+		return nil, fmt.Errorf("ошибка разбора файла мира: %w", err)
+	}
+
+	if err := world.Validate(); err != nil {
+		return nil, err
+	}
+
+	return world, nil
+}
+
+// Validate проверяет, что все ссылки на комнаты, предметы и действия в мире существуют
+func (world *World) Validate() error {
+	var errs []error
+
+	items := make(map[string]struct{}, len(world.Items))
+	for _, item := range world.Items {
+		if item.Name == "" {
+			errs = append(errs, fmt.Errorf("предмет: %w", errEmptyName))
+			continue
+		}
+		if _, exist := items[item.Name]; exist {
+			errs = append(errs, fmt.Errorf("предмет %q: %w", item.Name, errDuplicateName))
+		}
+		items[item.Name] = struct{}{}
+	}
+
+	// комната -> комнаты, в которые из неё есть проход
+	rooms := make(map[string][]string, len(world.Rooms))
+	for _, room := range world.Rooms {
+		if room.Name == "" {
+			errs = append(errs, fmt.Errorf("комната: %w", errEmptyName))
+			continue
+		}
+		if _, exist := rooms[room.Name]; exist {
+			errs = append(errs, fmt.Errorf("комната %q: %w", room.Name, errDuplicateName))
+		}
+
+		connections := make([]string, 0, len(room.Connections))
+		for _, connection := range room.Connections {
+			connections = append(connections, connection.Room)
+		}
+		rooms[room.Name] = connections
+	}
+
+	// предмет -> где он лежит, каждый предмет может лежать только в одном месте
+	placed := make(map[string]string, len(world.Items))
+	place := func(item, where string) {
+		if _, exist := placed[item]; exist {
+			errs = append(errs, fmt.Errorf("%s: %w %q", where, errPlacedTwice, item))
+		}
+		placed[item] = where
+	}
+
+	for _, room := range world.Rooms {
+		for _, roomItem := range room.Items {
+			if _, exist := items[roomItem.Item]; !exist {
+				errs = append(errs, fmt.Errorf("комната %q: %w %q", room.Name, errUnknownItem, roomItem.Item))
+			}
+			place(roomItem.Item, fmt.Sprintf("комната %q", room.Name))
+		}
+		for _, connection := range room.Connections {
+			if _, exist := rooms[connection.Room]; !exist {
+				errs = append(errs, fmt.Errorf("комната %q: проход в %w %q", room.Name, errUnknownRoom, connection.Room))
+			}
+		}
+	}
+
+	// предмет -> контейнер, в котором он лежит, для поиска циклов
+	containers := make(map[string]string)
+	for _, item := range world.Items {
+		if len(item.Contents) > item.Capacity {
+			errs = append(errs, fmt.Errorf("предмет %q: %w", item.Name, errOverCapacity))
+		}
+		if item.Closed && !item.Closable {
+			errs = append(errs, fmt.Errorf("предмет %q: %w", item.Name, errNotClosable))
+		}
+		if (item.Closable || len(item.Contents) > 0) && item.Capacity == 0 {
+			errs = append(errs, fmt.Errorf("предмет %q: %w", item.Name, errNotContainer))
+		}
+		for _, inner := range item.Contents {
+			if _, exist := items[inner]; !exist {
+				errs = append(errs, fmt.Errorf("предмет %q: %w %q", item.Name, errUnknownItem, inner))
+			}
+			place(inner, fmt.Sprintf("предмет %q", item.Name))
+			containers[inner] = item.Name
+		}
+	}
+
+	for _, item := range world.Items {
+		for container, steps := containers[item.Name], 0; container != ""; container, steps = containers[container], steps+1 {
+			if container == item.Name || steps > len(world.Items) {
+				errs = append(errs, fmt.Errorf("предмет %q: %w", item.Name, errItemCycle))
+				break
+			}
+		}
+
+		for _, target := range sortedKeys(item.CanApply) {
+			if err := item.CanApply[target].validate(rooms, items); err != nil {
+				errs = append(errs, fmt.Errorf("предмет %q, цель %q: %w", item.Name, target, err))
+			}
+		}
+	}
+
+	if _, exist := rooms[world.Start]; !exist {
+		errs = append(errs, fmt.Errorf("стартовая комната: %w %q", errUnknownRoom, world.Start))
+	}
+
+	goals := make(map[string]struct{}, len(world.Goals))
+	for _, goal := range world.Goals {
+		if goal.Name == "" {
+			errs = append(errs, fmt.Errorf("задание: %w", errEmptyName))
+			continue
+		}
+		if _, exist := goals[goal.Name]; exist {
+			errs = append(errs, fmt.Errorf("задание %q: %w", goal.Name, errDuplicateName))
+		}
+		goals[goal.Name] = struct{}{}
+
+		for i := range goal.Conditions {
+			if err := goal.Conditions[i].validate(rooms, items); err != nil {
+				errs = append(errs, fmt.Errorf("задание %q: %w", goal.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Build заполняет карту комнатами и предметами мира
+func (world *World) Build(gameMap *Map) {
+	gameMap.ClearMap()
+
+	for _, worldItem := range world.Items {
+		item := &Item{
+			Name:         worldItem.Name,
+			CanApply:     worldItem.CanApply,
+			ItemPosition: worldItem.Position,
+			Capacity:     worldItem.Capacity,
+			Closable:     worldItem.Closable,
+			Closed:       worldItem.Closed,
+		}
+		gameMap.Items[item.Name] = item
+	}
+
+	for _, worldItem := range world.Items {
+		for _, inner := range worldItem.Contents {
+			gameMap.Items[worldItem.Name].Put(gameMap.Items[inner])
+		}
+	}
+
+	for _, worldRoom := range world.Rooms {
+		gameMap.AddRoom(&Room{
+			Name:            worldRoom.Name,
+			Description:     worldRoom.Description,
+			WalkDescription: worldRoom.WalkDescription,
+			ConnectionsSet:  make(map[*Room]bool, len(worldRoom.Connections)),
+			IsOutsideHome:   worldRoom.OutsideHome,
+			ShowGoals:       worldRoom.ShowGoals,
+		})
+	}
+
+	for _, worldRoom := range world.Rooms {
+		room := gameMap.Rooms[worldRoom.Name]
+
+		for _, roomItem := range worldRoom.Items {
+			room.Items = append(room.Items, &RoomTarget{
+				Item:     gameMap.Items[roomItem.Item],
+				Position: roomItem.Position,
+			})
+		}
+
+		for _, connection := range worldRoom.Connections {
+			next := gameMap.Rooms[connection.Room]
+			room.Connections = append(room.Connections, next)
+			room.ConnectionsSet[next] = !connection.Locked
+		}
+	}
+
+	gameMap.Start = gameMap.Rooms[world.Start]
+	gameMap.ContainersOnly = world.ContainersOnly
+	gameMap.Quest = world.Quest
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultWorld(t *testing.T) {
+	world, err := ParseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal("default world is invalid:", err)
+	}
+
+	var testMap Map
+	world.Build(&testMap)
+
+	if len(testMap.Rooms) != 4 {
+		t.Error("expected 4 rooms, got", len(testMap.Rooms))
+	}
+	if testMap.Start == nil || testMap.Start.Name != "кухня" {
+		t.Error("unexpected start room:", testMap.Start)
+	}
+
+	corridor := testMap.Rooms["коридор"]
+	if corridor.ConnectionsSet[testMap.Rooms["улица"]] {
+		t.Error("door to the street must be locked")
+	}
+	if !corridor.ConnectionsSet[testMap.Rooms["кухня"]] {
+		t.Error("door to the kitchen must be open")
+	}
+}
+
+func TestInvalidWorld(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{
+			name: "dangling connection",
+			data: `{"start": "a", "rooms": [{"name": "a", "connections": [{"room": "b"}]}]}`,
+			err:  errUnknownRoom,
+		},
+		{
+			name: "unknown start",
+			data: `{"start": "b", "rooms": [{"name": "a"}]}`,
+			err:  errUnknownRoom,
+		},
+		{
+			name: "unknown item",
+			data: `{"start": "a", "rooms": [{"name": "a", "items": [{"item": "x"}]}]}`,
+			err:  errUnknownItem,
+		},
+		{
+			name: "unknown effect",
+			data: `{"start": "a", "items": [{"name": "x", "can_apply": {"y": {"effects": [{"type": "fly"}]}}}], "rooms": [{"name": "a"}]}`,
+			err:  errUnknownEffect,
+		},
+		{
+			name: "effect on missing door",
+			data: `{"start": "a", "items": [{"name": "x", "can_apply": {"y": {"effects": [{"type": "open", "from": "a", "to": "b"}]}}}], "rooms": [{"name": "a"}, {"name": "b"}]}`,
+			err:  errUnknownConnection,
+		},
+		{
+			name: "duplicate room",
+			data: `{"start": "a", "rooms": [{"name": "a"}, {"name": "a"}]}`,
+			err:  errDuplicateName,
+		},
+		{
+			name: "goal in unknown room",
+			data: `{"start": "a", "rooms": [{"name": "a"}], "goals": [{"name": "g", "conditions": [{"type": "in_room", "room": "b"}]}]}`,
+			err:  errUnknownRoom,
+		},
+		{
+			name: "item in two places",
+			data: `{"start": "a", "items": [{"name": "x"}, {"name": "box", "capacity": 1, "contents": ["x"]}], "rooms": [{"name": "a", "items": [{"item": "x"}]}]}`,
+			err:  errPlacedTwice,
+		},
+		{
+			name: "over capacity",
+			data: `{"start": "a", "items": [{"name": "x"}, {"name": "y"}, {"name": "box", "capacity": 1, "contents": ["x", "y"]}], "rooms": [{"name": "a"}]}`,
+			err:  errOverCapacity,
+		},
+		{
+			name: "contents of not a container",
+			data: `{"start": "a", "items": [{"name": "x"}, {"name": "y", "contents": ["x"]}], "rooms": [{"name": "a"}]}`,
+			err:  errNotContainer,
+		},
+		{
+			name: "container inside itself",
+			data: `{"start": "a", "items": [{"name": "x", "capacity": 1, "contents": ["y"]}, {"name": "y", "capacity": 1, "contents": ["x"]}], "rooms": [{"name": "a"}]}`,
+			err:  errItemCycle,
+		},
+		{
+			name: "duplicate goal",
+			data: `{"start": "a", "rooms": [{"name": "a"}], "goals": [{"name": "g"}, {"name": "g"}]}`,
+			err:  errDuplicateName,
+		},
+	}
+
+	for _, c := range cases {
+		_, err := ParseWorld([]byte(c.data))
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+		}
+	}
+
+	if _, err := ParseWorld([]byte(`{`)); err == nil {
+		t.Error("expected error for broken json")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "context"
+
+// UserDirectory находит пользователя по email, в том числе по алиасу
+type UserDirectory interface {
+	GetUser(ctx context.Context, email string) (User, error)
+}
+
+// MessageStore отдаёт письма пользователей; пользователей можно передавать батчами,
+// но не больше, чем разрешает хранилище
+type MessageStore interface {
+	GetMessages(ctx context.Context, users ...User) ([]MsgID, error)
+}
+
+// AntispamChecker проверяет письмо на спам
+type AntispamChecker interface {
+	HasSpam(ctx context.Context, id MsgID) (bool, error)
+}
+
+// Backend - сервисы, к которым ходят стадии конвейера
+type Backend struct {
+	Users    UserDirectory
+	Messages MessageStore
+	Antispam AntispamChecker
+}
+
+// Simulation - бэкенд из common.go: задержки, алиасы и антибрут как в задании.
+// Симуляция спит, не глядя на контекст, так что отмена дождётся текущих вызовов
+type Simulation struct{}
+
+func (Simulation) GetUser(_ context.Context, email string) (User, error) {
+	return GetUser(email), nil
+}
+
+func (Simulation) GetMessages(_ context.Context, users ...User) ([]MsgID, error) {
+	return GetMessages(users...)
+}
+
+func (Simulation) HasSpam(_ context.Context, id MsgID) (bool, error) {
+	return HasSpam(id)
+}
+
+func SimulatedBackend() Backend {
+	return Backend{Users: Simulation{}, Messages: Simulation{}, Antispam: Simulation{}}
+}
+
+// Pipeline - весь конвейер поверх backend: вызовы защищены resilience,
+// результаты собирает combine, например CombineResultsStage или Combiner.Run
+func (backend Backend) Pipeline(resilience Resilience, combine Stage[MsgData, string]) Stage[string, string] {
+	return resilience.wrap(backend).pipeline(combine)
+}
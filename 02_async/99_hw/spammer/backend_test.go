@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend - быстрая заглушка без задержек: у пользователя с id n письма n*10..n*10+2,
+// спам - нечётные письма. Каждый failEvery-й запрос к антиспаму отказывает
+type fakeBackend struct {
+	maxBatch  int
+	failEvery int32
+	requests  int32
+}
+
+func (backend *fakeBackend) GetUser(_ context.Context, email string) (User, error) {
+	return User{ID: uint64(len(email)), Email: email}, nil
+}
+
+func (backend *fakeBackend) GetMessages(_ context.Context, users ...User) ([]MsgID, error) {
+	if len(users) > backend.maxBatch {
+		return nil, errTooManyUsers
+	}
+
+	var msgIDs []MsgID
+	for _, user := range users {
+		for i := uint64(0); i < 3; i++ {
+			msgIDs = append(msgIDs, MsgID(user.ID*10+i))
+		}
+	}
+	return msgIDs, nil
+}
+
+func (backend *fakeBackend) HasSpam(_ context.Context, id MsgID) (bool, error) {
+	if backend.failEvery > 0 && atomic.AddInt32(&backend.requests, 1)%backend.failEvery == 0 {
+		return false, errTooManyRequests
+	}
+	return id%2 == 1, nil
+}
+
+func (backend *fakeBackend) Backend() Backend {
+	return Backend{Users: backend, Messages: backend, Antispam: backend}
+}
+
+func runSpam(t *testing.T, stage Stage[string, string], emails ...string) ([]string, error) {
+	t.Helper()
+
+	in := make(chan string, len(emails))
+	for _, email := range emails {
+		in <- email
+	}
+	close(in)
+
+	out := make(chan string, 100)
+	err := RunStage(context.Background(), stage, in, out)
+
+	var results []string
+	for line := range out {
+		results = append(results, line)
+	}
+	return results, err
+}
+
+func TestHTTPBackendPipeline(t *testing.T) {
+	fake := &fakeBackend{maxBatch: GetMessagesMaxUsersBatch}
+	server := httptest.NewServer(NewStubServer(fake.Backend()))
+	defer server.Close()
+
+	emails := []string{"a@mail.ru", "bb@mail.ru", "ccc@mail.ru"}
+
+	expected, err := runSpam(t, fake.Backend().Pipeline(Resilience{}, CombineResultsStage), emails...)
+	require.NoError(t, err)
+	require.Len(t, expected, 9)
+
+	results, err := runSpam(t, NewHTTPBackend(server.URL, server.Client()).Backend().Pipeline(Resilience{}, CombineResultsStage), emails...)
+	require.NoError(t, err)
+	assert.Equal(t, expected, results)
+}
+
+// 429 от сервиса - та же errTooManyRequests, поэтому повторы работают и через HTTP
+func TestHTTPBackendRetry(t *testing.T) {
+	stat = Stat{}
+
+	fake := &fakeBackend{maxBatch: GetMessagesMaxUsersBatch, failEvery: 3}
+	server := httptest.NewServer(NewStubServer(fake.Backend()))
+	defer server.Close()
+
+	backend := NewHTTPBackend(server.URL, server.Client()).Backend()
+
+	results, err := runSpam(t, backend.Pipeline(Resilience{}, CombineResultsStage), "a@mail.ru", "bb@mail.ru")
+	assert.ErrorIs(t, err, errTooManyRequests)
+	assert.Empty(t, results)
+
+	resilience := Resilience{
+		HasSpam: Guard{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}},
+	}
+	results, err = runSpam(t, backend.Pipeline(resilience, CombineResultsStage), "a@mail.ru", "bb@mail.ru")
+	require.NoError(t, err)
+	assert.Len(t, results, 6)
+	assert.NotZero(t, stat.Retries)
+}
+
+// 503 и обрыв соединения - errBackendUnavailable, повтор проходит, когда сервис поднялся
+func TestHTTPBackendUnavailable(t *testing.T) {
+	stat = Stat{}
+
+	fake := &fakeBackend{maxBatch: GetMessagesMaxUsersBatch}
+	stub := NewStubServer(fake.Backend())
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			writeJSON(w, http.StatusServiceUnavailable, wireError{Error: "перезапускаемся"})
+			return
+		}
+		stub.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	backend := NewHTTPBackend(server.URL, server.Client())
+	guard := Guard{Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}}
+
+	var isSpam bool
+	err := guard.Do(context.Background(), func() (err error) {
+		isSpam, err = backend.HasSpam(context.Background(), 1)
+		return err
+	})
+	require.NoError(t, err)
+	assert.True(t, isSpam)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, uint32(1), stat.Retries)
+
+	server.Close()
+	_, err = backend.HasSpam(context.Background(), 1)
+	assert.ErrorIs(t, err, errBackendUnavailable)
+	assert.True(t, isRetryable(err))
+}
+
+func TestHTTPBackendErrors(t *testing.T) {
+	fake := &fakeBackend{maxBatch: 1}
+	server := httptest.NewServer(NewStubServer(fake.Backend()))
+	defer server.Close()
+
+	backend := NewHTTPBackend(server.URL, server.Client())
+	ctx := context.Background()
+
+	_, err := backend.GetMessages(ctx, User{ID: 1}, User{ID: 2})
+	assert.ErrorIs(t, err, errTooManyUsers)
+
+	_, err = backend.GetUser(ctx, "")
+	assert.ErrorIs(t, err, errBackendStatus)
+	assert.Contains(t, err.Error(), "400 не указан email")
+
+	resp, err := server.Client().Post(server.URL+spamPath, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = backend.HasSpam(cancelled, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	msgIDs, err := backend.GetMessages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []MsgID{}, msgIDs)
+}
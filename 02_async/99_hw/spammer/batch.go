@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// GetMessagesBatchLinger - сколько неполный батч пользователей ждёт остальных перед GetMessages.
+// GetUser отвечает за секунду, так что пользователи, пришедшие вместе, попадают в один батч,
+// а одиночка не ждёт до конца входа. 0 - ждать, пока батч заполнится или закончится вход
+var GetMessagesBatchLinger = 100 * time.Millisecond
+
+// Clock - источник таймеров для стадий, в тестах подменяется
+type Clock interface {
+	NewTimer(d time.Duration) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (timer realTimer) C() <-chan time.Time {
+	return timer.Timer.C
+}
+
+// Batcher собирает значения в батчи не больше Size и отдаёт батч, когда он заполнился
+// или когда с первого значения в нём прошло Linger. Подходит любой стадии перед батчевым API
+type Batcher[T any] struct {
+	Size int
+	// Linger - 0 - ждать, пока батч заполнится или закончится вход
+	Linger time.Duration
+	// Clock - nil - настоящее время
+	Clock Clock
+}
+
+// Run - сама стадия, подставляется в Then как batcher.Run
+func (batcher Batcher[T]) Run(ctx context.Context, in <-chan T, out chan<- []T) error {
+	size := batcher.Size
+	if size < 1 {
+		size = 1
+	}
+
+	clock := batcher.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	batch := make([]T, 0, size)
+
+	var timer Timer
+	// nil, пока таймер не запущен: из nil канала select никогда не читает
+	var linger <-chan time.Time
+
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, linger = nil, nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		full := batch
+		batch = make([]T, 0, size)
+
+		return send(ctx, out, full)
+	}
+
+	for {
+		select {
+		case value, ok := <-in:
+			if !ok {
+				return flush()
+			}
+
+			batch = append(batch, value)
+			if len(batch) == 1 && batcher.Linger > 0 {
+				timer = clock.NewTimer(batcher.Linger)
+				linger = timer.C()
+			}
+
+			if len(batch) < size {
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-linger:
+			timer, linger = nil, nil
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock - время стоит на месте, пока тест не вызовет Advance
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	created chan struct{}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+	stopped  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		created: make(chan struct{}, 100),
+	}
+}
+
+func (clock *fakeClock) NewTimer(d time.Duration) Timer {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	timer := &fakeTimer{clock: clock, deadline: clock.now.Add(d), c: make(chan time.Time, 1)}
+	clock.timers = append(clock.timers, timer)
+	clock.created <- struct{}{}
+
+	return timer
+}
+
+// Advance сдвигает время и срабатывает таймеры, срок которых прошёл
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	active := clock.timers[:0]
+	for _, timer := range clock.timers {
+		switch {
+		case timer.stopped:
+		case !timer.deadline.After(clock.now):
+			timer.c <- clock.now
+		default:
+			active = append(active, timer)
+		}
+	}
+	clock.timers = active
+}
+
+// waitTimer ждёт, пока стадия заведёт таймер, иначе Advance может его опередить
+func (clock *fakeClock) waitTimer(t *testing.T) {
+	t.Helper()
+	select {
+	case <-clock.created:
+	case <-time.After(time.Second):
+		t.Fatal("таймер не создан")
+	}
+}
+
+func (timer *fakeTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *fakeTimer) Stop() bool {
+	timer.clock.mu.Lock()
+	defer timer.clock.mu.Unlock()
+
+	wasActive := !timer.stopped
+	timer.stopped = true
+	return wasActive
+}
+
+func startBatcher(batcher Batcher[int]) (chan int, chan []int, chan error) {
+	in := make(chan int)
+	out := make(chan []int)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- RunStage(context.Background(), batcher.Run, in, out)
+	}()
+
+	return in, out, done
+}
+
+func assertNoBatch(t *testing.T, out chan []int) {
+	t.Helper()
+	select {
+	case batch := <-out:
+		t.Errorf("неожиданный батч %v", batch)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestBatcherLinger(t *testing.T) {
+	clock := newFakeClock()
+	in, out, done := startBatcher(Batcher[int]{Size: 3, Linger: time.Second, Clock: clock})
+
+	in <- 1
+	clock.waitTimer(t)
+	clock.Advance(999 * time.Millisecond)
+	assertNoBatch(t, out)
+
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, []int{1}, <-out)
+
+	// полный батч уходит сразу, его таймер остановлен
+	in <- 2
+	clock.waitTimer(t)
+	in <- 3
+	in <- 4
+	assert.Equal(t, []int{2, 3, 4}, <-out)
+	clock.Advance(time.Second)
+	assertNoBatch(t, out)
+
+	in <- 5
+	clock.waitTimer(t)
+	close(in)
+	assert.Equal(t, []int{5}, <-out)
+
+	_, open := <-out
+	assert.False(t, open)
+	assert.NoError(t, <-done)
+}
+
+func TestBatcherWithoutLinger(t *testing.T) {
+	in, out, done := startBatcher(Batcher[int]{Size: 2})
+
+	in <- 1
+	assertNoBatch(t, out)
+	in <- 2
+	assert.Equal(t, []int{1, 2}, <-out)
+
+	in <- 3
+	close(in)
+	assert.Equal(t, []int{3}, <-out)
+	assert.NoError(t, <-done)
+}
+
+func TestBatcherCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := make(chan []int)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Batcher[int]{Size: 2, Linger: time.Hour}.Run(ctx, in, out)
+	}()
+
+	in <- 1
+	cancel()
+
+	require.ErrorIs(t, <-done, context.Canceled)
+}
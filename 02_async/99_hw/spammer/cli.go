@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// форматы входа и выхода командной строки
+const (
+	formatLines = "lines"
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+	formatText  = "text"
+	formatJSON  = "json"
+)
+
+var (
+	inputFormats  = []string{formatLines, formatCSV, formatJSONL}
+	outputFormats = []string{formatText, formatJSON, formatCSV}
+)
+
+// createOutput создаёт файл результата, в тестах подменяется
+var createOutput = func(fileName string) (io.WriteCloser, error) {
+	return os.Create(fileName)
+}
+
+// как собирать результат
+const (
+	// CombineResults: всё в памяти, результат после конца входа
+	combineMemory = "memory"
+	// Combiner: в памяти не больше -combine-memory сообщений, остальное во временных файлах
+	combineExternal = "external"
+	// Combiner.Stream: результат по ходу, упорядоченный кусками
+	combineStream = "stream"
+)
+
+var (
+	errUnknownCombine = errors.New("неизвестный режим сборки результата")
+	errUnknownFormat  = errors.New("неизвестный формат")
+	errBadEmail       = errors.New("не похоже на email")
+	errBadResult      = errors.New("не удалось разобрать результат")
+)
+
+// newCombine - стадия сборки результата в режиме mode: для RunPipeline и для типизированного конвейера
+func newCombine(mode string, combiner Combiner) (cmd, Stage[MsgData, string], error) {
+	switch mode {
+	case combineMemory:
+		return CombineResults, CombineResultsStage, nil
+	case combineStream:
+		combiner.Stream = true
+		return combiner.Cmd(), combiner.Run, nil
+	case combineExternal:
+		return combiner.Cmd(), combiner.Run, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s", errUnknownCombine, mode)
+}
+
+// checkFormats проверяет форматы до того, как открыты файлы: с неизвестным форматом результат не создаётся
+func checkFormats(inFormat, outFormat string) error {
+	if !slices.Contains(inputFormats, inFormat) {
+		return fmt.Errorf("%w входа: %s", errUnknownFormat, inFormat)
+	}
+	if !slices.Contains(outputFormats, outFormat) {
+		return fmt.Errorf("%w выхода: %s", errUnknownFormat, outFormat)
+	}
+
+	return nil
+}
+
+// inputFormatOf угадывает формат входа по расширению файла
+func inputFormatOf(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return formatCSV
+	case ".jsonl", ".ndjson":
+		return formatJSONL
+	}
+
+	return formatLines
+}
+
+// RecordError - запись входа, которую не удалось прочитать; чтение продолжается со следующей
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (recordErr *RecordError) Error() string {
+	return fmt.Sprintf("строка %d: %v", recordErr.Line, recordErr.Err)
+}
+
+func (recordErr *RecordError) Unwrap() error {
+	return recordErr.Err
+}
+
+// readEmails читает email'ы из r в формате format и отдаёт их в emit.
+// Плохие записи пропускаются и отдаются в reject, ошибка возвращается, только если читать дальше нельзя
+func readEmails(r io.Reader, format string, emit func(email string), reject func(err *RecordError)) error {
+	accept := func(line int, email string) {
+		email = strings.TrimSpace(email)
+		if !strings.Contains(email, "@") {
+			reject(&RecordError{Line: line, Err: fmt.Errorf("%w: %q", errBadEmail, email)})
+			return
+		}
+		emit(email)
+	}
+
+	switch format {
+	case formatLines:
+		return readLines(r, func(line int, text string) {
+			if text == "" || strings.HasPrefix(text, "#") {
+				return
+			}
+			accept(line, text)
+		})
+
+	case formatJSONL:
+		return readLines(r, func(line int, text string) {
+			if text == "" {
+				return
+			}
+
+			var record struct {
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				// строка JSON тоже подходит
+				if err := json.Unmarshal([]byte(text), &record.Email); err != nil {
+					reject(&RecordError{Line: line, Err: err})
+					return
+				}
+			}
+			accept(line, record.Email)
+		})
+
+	case formatCSV:
+		return readCSV(r, accept, reject)
+	}
+
+	return fmt.Errorf("%w входа: %s", errUnknownFormat, format)
+}
+
+func readLines(r io.Reader, handle func(line int, text string)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		handle(line, strings.TrimSpace(scanner.Text()))
+	}
+
+	return scanner.Err()
+}
+
+// readCSV берёт колонку email, если в первой строке есть такой заголовок, иначе первую колонку
+func readCSV(r io.Reader, accept func(line int, email string), reject func(err *RecordError)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	column := 0
+	for record := 1; ; record++ {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			reject(&RecordError{Line: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if record == 1 {
+			if header := headerColumn(fields, "email"); header != -1 {
+				column = header
+				continue
+			}
+		}
+
+		if column >= len(fields) {
+			reject(&RecordError{Line: line, Err: fmt.Errorf("нет колонки %d", column+1)})
+			continue
+		}
+		accept(line, fields[column])
+	}
+}
+
+func headerColumn(fields []string, name string) int {
+	for i, field := range fields {
+		if strings.EqualFold(strings.TrimSpace(field), name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// parseMessage разбирает строку CombineResults обратно в MsgData
+func parseMessage(line string) (MsgData, error) {
+	hasSpam, id, found := strings.Cut(line, " ")
+	if !found {
+		return MsgData{}, fmt.Errorf("%w: %q", errBadResult, line)
+	}
+
+	message := MsgData{}
+	var err error
+	if message.HasSpam, err = strconv.ParseBool(hasSpam); err != nil {
+		return MsgData{}, fmt.Errorf("%w: %q", errBadResult, line)
+	}
+
+	msgID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return MsgData{}, fmt.Errorf("%w: %q", errBadResult, line)
+	}
+	message.ID = MsgID(msgID)
+
+	return message, nil
+}
+
+// resultWriter пишет результаты в выбранном формате, Close дописывает конец
+type resultWriter interface {
+	Write(message MsgData) error
+	Close() error
+}
+
+func newResultWriter(w io.Writer, format string) (resultWriter, error) {
+	switch format {
+	case formatText:
+		return &textWriter{w: bufio.NewWriter(w)}, nil
+	case formatJSON:
+		return &jsonWriter{w: w}, nil
+	case formatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"id", "has_spam"}); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: writer}, nil
+	}
+
+	return nil, fmt.Errorf("%w выхода: %s", errUnknownFormat, format)
+}
+
+// textWriter - как CombineResults: "true 123"
+type textWriter struct {
+	w *bufio.Writer
+}
+
+func (writer *textWriter) Write(message MsgData) error {
+	_, err := fmt.Fprintln(writer.w, formatMessage(message))
+	return err
+}
+
+func (writer *textWriter) Close() error {
+	return writer.w.Flush()
+}
+
+type jsonResult struct {
+	ID      MsgID `json:"id"`
+	HasSpam bool  `json:"has_spam"`
+}
+
+// jsonWriter собирает массив: результаты и так приходят разом после сортировки
+type jsonWriter struct {
+	w       io.Writer
+	results []jsonResult
+}
+
+func (writer *jsonWriter) Write(message MsgData) error {
+	writer.results = append(writer.results, jsonResult{ID: message.ID, HasSpam: message.HasSpam})
+	return nil
+}
+
+func (writer *jsonWriter) Close() error {
+	if writer.results == nil {
+		writer.results = []jsonResult{}
+	}
+
+	encoder := json.NewEncoder(writer.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(writer.results)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (writer *csvWriter) Write(message MsgData) error {
+	return writer.w.Write([]string{
+		strconv.FormatUint(uint64(message.ID), 10),
+		strconv.FormatBool(message.HasSpam),
+	})
+}
+
+func (writer *csvWriter) Close() error {
+	writer.w.Flush()
+	return writer.w.Error()
+}
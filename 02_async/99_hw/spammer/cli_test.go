@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, input, format string) ([]string, []int) {
+	t.Helper()
+
+	var emails []string
+	var rejected []int
+	err := readEmails(strings.NewReader(input), format,
+		func(email string) { emails = append(emails, email) },
+		func(err *RecordError) { rejected = append(rejected, err.Line) },
+	)
+	require.NoError(t, err)
+
+	return emails, rejected
+}
+
+func TestReadEmails(t *testing.T) {
+	cases := []struct {
+		format   string
+		input    string
+		emails   []string
+		rejected []int
+	}{
+		{
+			formatLines,
+			"# комментарий\nharry.dubois@mail.ru\n\n  k.kitsuragi@mail.ru  \nне email\n",
+			[]string{"harry.dubois@mail.ru", "k.kitsuragi@mail.ru"},
+			[]int{5},
+		},
+		{
+			formatCSV,
+			"name,Email\nГарри,harry.dubois@mail.ru\nКим\n\"Ким\"x,k.kitsuragi@mail.ru\nКим,k.kitsuragi@mail.ru\n",
+			[]string{"harry.dubois@mail.ru", "k.kitsuragi@mail.ru"},
+			[]int{3, 4},
+		},
+		{
+			formatCSV,
+			"harry.dubois@mail.ru,1\nk.kitsuragi@mail.ru,2\n",
+			[]string{"harry.dubois@mail.ru", "k.kitsuragi@mail.ru"},
+			nil,
+		},
+		{
+			formatJSONL,
+			"{\"email\": \"harry.dubois@mail.ru\"}\n\"k.kitsuragi@mail.ru\"\n{\"email\": \n{\"name\": \"Ким\"}\n",
+			[]string{"harry.dubois@mail.ru", "k.kitsuragi@mail.ru"},
+			[]int{3, 4},
+		},
+	}
+
+	for _, c := range cases {
+		emails, rejected := readAll(t, c.input, c.format)
+		assert.Equal(t, c.emails, emails, c.format)
+		assert.Equal(t, c.rejected, rejected, c.format)
+	}
+
+	err := readEmails(strings.NewReader(""), "xml", nil, nil)
+	assert.ErrorIs(t, err, errUnknownFormat)
+}
+
+func TestInputFormatOf(t *testing.T) {
+	assert.Equal(t, formatCSV, inputFormatOf("users.CSV"))
+	assert.Equal(t, formatJSONL, inputFormatOf("users.jsonl"))
+	assert.Equal(t, formatLines, inputFormatOf("users.txt"))
+	assert.Equal(t, formatLines, inputFormatOf(""))
+}
+
+func TestResultWriters(t *testing.T) {
+	messages := []MsgData{{ID: 7, HasSpam: true}, {ID: 42}}
+
+	expected := map[string]string{
+		formatText: "true 7\nfalse 42\n",
+		formatCSV:  "id,has_spam\n7,true\n42,false\n",
+		formatJSON: "[\n  {\n    \"id\": 7,\n    \"has_spam\": true\n  },\n  {\n    \"id\": 42,\n    \"has_spam\": false\n  }\n]\n",
+	}
+
+	for format, output := range expected {
+		buf := &bytes.Buffer{}
+		writer, err := newResultWriter(buf, format)
+		require.NoError(t, err)
+
+		for _, message := range messages {
+			line := formatMessage(message)
+			parsed, err := parseMessage(line)
+			require.NoError(t, err)
+			require.NoError(t, writer.Write(parsed))
+		}
+		require.NoError(t, writer.Close())
+
+		assert.Equal(t, output, buf.String(), format)
+	}
+
+	_, err := parseMessage("true")
+	assert.ErrorIs(t, err, errBadResult)
+	_, err = parseMessage("да 7")
+	assert.ErrorIs(t, err, errBadResult)
+}
+
+func TestRunUsage(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	assert.Equal(t, exitUsage, run([]string{"-batch", "0"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-batch", "3"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-concurrency", "6"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-linger", "-1s"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-combine", "heap"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-combine", "stream", "-combine-memory", "0"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-format", "xml"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-unknown"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitFailure, run([]string{"-in", filepath.Join(t.TempDir(), "нет.txt")}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitFailure, run([]string{"-metrics", "127.0.0.1:-1"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.Equal(t, exitUsage, run([]string{"-in-format", "xml"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+
+	// с неправильными флагами файл результата не создаётся
+	outFileName := filepath.Join(t.TempDir(), "result.xml")
+	assert.Equal(t, exitUsage, run([]string{"-out", outFileName, "-format", "xml"}, strings.NewReader(""), &bytes.Buffer{}, stderr))
+	assert.NoFileExists(t, outFileName)
+}
+
+// failingFile - файл результата, который не удаётся закрыть
+type failingFile struct {
+	bytes.Buffer
+}
+
+var errCloseFailed = errors.New("диск переполнен")
+
+func (*failingFile) Close() error {
+	return errCloseFailed
+}
+
+func TestRunCloseError(t *testing.T) {
+	defer func(create func(string) (io.WriteCloser, error)) { createOutput = create }(createOutput)
+	file := &failingFile{}
+	createOutput = func(string) (io.WriteCloser, error) { return file, nil }
+
+	stderr := &bytes.Buffer{}
+	code := run([]string{"-out", "result.txt"}, strings.NewReader("harry.dubois@mail.ru\n"), &bytes.Buffer{}, stderr)
+
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr.String(), "ошибка записи результата: диск переполнен")
+	assert.NotEmpty(t, file.String())
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	inFileName := filepath.Join(dir, "users.csv")
+	outFileName := filepath.Join(dir, "result.json")
+	require.NoError(t, os.WriteFile(inFileName, []byte("email\nharry.dubois@mail.ru\nне email\n"), 0o600))
+
+	stderr := &bytes.Buffer{}
+	code := run([]string{"-in", inFileName, "-out", outFileName, "-format", "json", "-batch", "1", "-concurrency", "3", "-linger", "50ms"},
+		strings.NewReader(""), &bytes.Buffer{}, stderr)
+
+	assert.Equal(t, exitPartial, code, stderr.String())
+	// флаги настраивают клиента, ограничения бэкенда не меняются
+	assert.Equal(t, 2, GetMessagesMaxUsersBatch)
+	assert.Equal(t, 5, HasSpamMaxAsyncRequests)
+	assert.Zero(t, MessagesBatchSize)
+	assert.Zero(t, SpamCheckConcurrency)
+	assert.Equal(t, 100*time.Millisecond, GetMessagesBatchLinger)
+	assert.Contains(t, stderr.String(), "пропущена запись: строка 3")
+	assert.Contains(t, stderr.String(), "писем: 5, спам: 3, пропущено записей: 1, ошибок бэкенда: 0")
+
+	data, err := os.ReadFile(outFileName)
+	require.NoError(t, err)
+
+	var results []jsonResult
+	require.NoError(t, json.Unmarshal(data, &results))
+	require.Len(t, results, 5)
+	assert.Equal(t, jsonResult{ID: 9323185346293974544, HasSpam: true}, results[0])
+}
+
+// external даёт тот же результат, что и memory; stream - те же письма
+func TestRunCombine(t *testing.T) {
+	input := "harry.dubois@mail.ru\nbilly.herington@mail.ru\n"
+
+	runCombine := func(args ...string) []string {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		require.Equal(t, exitOK, run(args, strings.NewReader(input), stdout, stderr), stderr.String())
+		return strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	}
+
+	expected := runCombine()
+	assert.Equal(t, expected, runCombine("-combine", "external", "-combine-memory", "2"))
+	assert.ElementsMatch(t, expected, runCombine("-combine", "stream", "-combine-memory", "2"))
+}
+
+func TestRunMetrics(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-metrics", "127.0.0.1:0"}, strings.NewReader("harry.dubois@mail.ru\n"), stdout, stderr)
+
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Regexp(t, `метрики: http://127\.0\.0\.1:\d+/metrics`, stderr.String())
+	assert.NotEmpty(t, stdout.String())
+}
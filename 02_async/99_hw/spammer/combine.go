@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// сколько сообщений Combiner держит в памяти, если MaxInMemory не задан
+const defaultMaxInMemory = 100000
+
+// сколько кусков Combiner сливает за раз, если MaxMergeFanIn не задан
+const defaultMergeFanIn = 64
+
+// размер записи во временном файле: ID и флаг спама
+const messageRecordSize = 9
+
+// Combiner - замена CombineResults с ограниченной памятью, в двух режимах.
+//
+// Без Stream порядок тот же, что у CombineResults. Пока сообщений не больше MaxInMemory, работает так же;
+// дальше сортирует их кусками и сбрасывает во временные файлы, а в конце сливает куски и отдаёт результат
+// по одному сообщению, не собирая его целиком. Первое сообщение в этом порядке может прийти последним,
+// поэтому результат появляется только после того, как закрыт вход.
+//
+// Stream отдаёт результат по ходу работы: сообщения собираются в куски не больше MaxInMemory,
+// и кусок отдаётся, как только заполнился или с первого сообщения в нём прошло Linger. Внутри куска
+// порядок тот же, что у CombineResults, куски идут один за другим в порядке прихода, и отданное
+// уже не переставляется. Временные файлы в этом режиме не нужны
+type Combiner struct {
+	MaxInMemory int
+	// MaxMergeFanIn - сколько кусков сливается за раз, то есть сколько временных файлов открыто.
+	// Если кусков больше, они сливаются в несколько проходов, 0 - defaultMergeFanIn
+	MaxMergeFanIn int
+	// TempDir - где создавать временные файлы, "" - os.TempDir()
+	TempDir string
+
+	Stream bool
+	// Linger - для Stream: 0 - отдавать кусок, только когда он заполнился или закончился вход
+	Linger time.Duration
+	// Clock - для Stream, nil - настоящее время
+	Clock Clock
+}
+
+// Run - сама стадия, подставляется в Resilience.PipelineWith как combiner.Run
+func (combiner Combiner) Run(ctx context.Context, in <-chan MsgData, out chan<- string) error {
+	if combiner.Stream {
+		return combiner.stream(ctx, in, out)
+	}
+
+	sorter := newExternalSorter(combiner.MaxInMemory, combiner.TempDir)
+	if combiner.MaxMergeFanIn > 1 {
+		sorter.fanIn = combiner.MaxMergeFanIn
+	}
+	defer sorter.Close()
+
+	for {
+		message, ok := receive(ctx, in)
+		if !ok {
+			break
+		}
+
+		if err := sorter.Add(message); err != nil {
+			return err
+		}
+	}
+
+	// вход мог закончиться из-за отмены - неполный результат не отдаём
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return sorter.Merge(func(message MsgData) error {
+		return send(ctx, out, formatMessage(message))
+	})
+}
+
+func (combiner Combiner) stream(ctx context.Context, in <-chan MsgData, out chan<- string) error {
+	size := combiner.MaxInMemory
+	if size < 1 {
+		size = defaultMaxInMemory
+	}
+
+	batcher := Batcher[MsgData]{Size: size, Linger: combiner.Linger, Clock: combiner.Clock}
+	return Then(batcher.Run, emitSorted)(ctx, in, out)
+}
+
+// emitSorted отдаёт каждый кусок в порядке CombineResults
+func emitSorted(ctx context.Context, in <-chan []MsgData, out chan<- string) error {
+	for {
+		messages, ok := receive(ctx, in)
+		if !ok {
+			return ctx.Err()
+		}
+
+		sortMessages(messages)
+		for _, message := range messages {
+			if err := send(ctx, out, formatMessage(message)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Cmd - Combiner как обычная стадия для RunPipeline: ошибки пишутся в лог, как в CombineResults
+func (combiner Combiner) Cmd() cmd {
+	return func(in, out chan interface{}) {
+		messages := make(chan MsgData)
+		go func() {
+			defer close(messages)
+			for msgData := range in {
+				message, ok := msgData.(MsgData)
+				if !ok {
+					log.Println("В канал пришли данные, которые не удается преобразовать в MsgData")
+					continue
+				}
+				messages <- message
+			}
+		}()
+
+		lines := make(chan string)
+		go func() {
+			if err := RunStage(context.Background(), combiner.Run, messages, lines); err != nil {
+				log.Println("Ошибка при сборке результатов:", err)
+			}
+		}()
+
+		for line := range lines {
+			out <- line
+		}
+	}
+}
+
+// externalSorter сортирует больше сообщений, чем помещается в память:
+// отсортированные куски по max сообщений лежат во временных файлах
+// и сливаются не больше чем по fanIn за раз
+type externalSorter struct {
+	max     int
+	fanIn   int
+	tempDir string
+
+	dir     string
+	buffer  []MsgData
+	runs    []string
+	created int
+}
+
+func newExternalSorter(max int, tempDir string) *externalSorter {
+	if max < 1 {
+		max = defaultMaxInMemory
+	}
+
+	return &externalSorter{max: max, fanIn: defaultMergeFanIn, tempDir: tempDir}
+}
+
+func (sorter *externalSorter) Add(message MsgData) error {
+	sorter.buffer = append(sorter.buffer, message)
+	if len(sorter.buffer) < sorter.max {
+		return nil
+	}
+
+	return sorter.spill()
+}
+
+// spill сортирует буфер и сбрасывает его в новый временный файл
+func (sorter *externalSorter) spill() error {
+	sortMessages(sorter.buffer)
+
+	writer, err := sorter.newRun()
+	if err != nil {
+		return err
+	}
+	for _, message := range sorter.buffer {
+		if err := writer.write(message); err != nil {
+			writer.close()
+			return fmt.Errorf("не удалось записать кусок %s: %w", writer.fileName, err)
+		}
+	}
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("не удалось записать кусок %s: %w", writer.fileName, err)
+	}
+
+	sorter.runs = append(sorter.runs, writer.fileName)
+	sorter.buffer = sorter.buffer[:0]
+
+	return nil
+}
+
+// newRun создаёт файл для следующего куска
+func (sorter *externalSorter) newRun() (*runWriter, error) {
+	if sorter.dir == "" {
+		dir, err := os.MkdirTemp(sorter.tempDir, "spammer-combine-")
+		if err != nil {
+			return nil, fmt.Errorf("не удалось создать временный каталог: %w", err)
+		}
+		sorter.dir = dir
+	}
+
+	sorter.created++
+	return createRun(filepath.Join(sorter.dir, fmt.Sprintf("run-%d", sorter.created)))
+}
+
+// Merge сливает все куски и отдаёт сообщения по порядку. Пока кусков вместе с остатком
+// в памяти больше fanIn, первые fanIn файлов сливаются в один новый
+func (sorter *externalSorter) Merge(emit func(MsgData) error) error {
+	sortMessages(sorter.buffer)
+
+	for len(sorter.runs)+1 > sorter.fanIn {
+		if err := sorter.mergePass(); err != nil {
+			return err
+		}
+	}
+
+	// остаток в памяти - такой же кусок, только без файла
+	return mergeRuns(sorter.runs, &sliceRun{messages: sorter.buffer}, emit)
+}
+
+// mergePass сливает первые fanIn кусков в новый, который встаёт в конец очереди
+func (sorter *externalSorter) mergePass() error {
+	writer, err := sorter.newRun()
+	if err != nil {
+		return err
+	}
+
+	inputs := sorter.runs[:sorter.fanIn]
+	if err := mergeRuns(inputs, nil, writer.write); err != nil {
+		writer.close()
+		return err
+	}
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("не удалось записать кусок %s: %w", writer.fileName, err)
+	}
+
+	for _, fileName := range inputs {
+		if err := os.Remove(fileName); err != nil {
+			log.Println("Не удалось удалить временный файл:", err)
+		}
+	}
+	sorter.runs = append(sorter.runs[sorter.fanIn:], writer.fileName)
+
+	return nil
+}
+
+// mergeRuns сливает куски из файлов и кусок extra, если он есть, и отдаёт сообщения по порядку
+func mergeRuns(fileNames []string, extra sortedRun, emit func(MsgData) error) error {
+	merge := &runHeap{}
+	if extra != nil {
+		if err := merge.push(extra); err != nil {
+			return err
+		}
+	}
+
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := merge.push(&fileRun{reader: bufio.NewReader(file)}); err != nil {
+			return fmt.Errorf("кусок %s: %w", fileName, err)
+		}
+	}
+
+	for merge.Len() > 0 {
+		top := (*merge)[0]
+		if err := emit(top.current); err != nil {
+			return err
+		}
+
+		next, ok, err := top.run.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			heap.Pop(merge)
+			continue
+		}
+
+		top.current = next
+		heap.Fix(merge, 0)
+	}
+
+	return nil
+}
+
+// Close удаляет временные файлы
+func (sorter *externalSorter) Close() {
+	if sorter.dir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(sorter.dir); err != nil {
+		log.Println("Не удалось удалить временные файлы:", err)
+	}
+}
+
+// runWriter пишет отсортированный кусок во временный файл
+type runWriter struct {
+	fileName string
+	file     *os.File
+	writer   *bufio.Writer
+	record   [messageRecordSize]byte
+}
+
+func createRun(fileName string) (*runWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runWriter{fileName: fileName, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (w *runWriter) write(message MsgData) error {
+	binary.BigEndian.PutUint64(w.record[:8], uint64(message.ID))
+	w.record[8] = 0
+	if message.HasSpam {
+		w.record[8] = 1
+	}
+
+	_, err := w.writer.Write(w.record[:])
+	return err
+}
+
+func (w *runWriter) close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// sortedRun - отсортированный кусок сообщений
+type sortedRun interface {
+	next() (MsgData, bool, error)
+}
+
+type sliceRun struct {
+	messages []MsgData
+}
+
+func (r *sliceRun) next() (MsgData, bool, error) {
+	if len(r.messages) == 0 {
+		return MsgData{}, false, nil
+	}
+
+	message := r.messages[0]
+	r.messages = r.messages[1:]
+
+	return message, true, nil
+}
+
+type fileRun struct {
+	reader *bufio.Reader
+	record [messageRecordSize]byte
+}
+
+func (r *fileRun) next() (MsgData, bool, error) {
+	if _, err := io.ReadFull(r.reader, r.record[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return MsgData{}, false, nil
+		}
+		return MsgData{}, false, err
+	}
+
+	return MsgData{
+		ID:      MsgID(binary.BigEndian.Uint64(r.record[:8])),
+		HasSpam: r.record[8] == 1,
+	}, true, nil
+}
+
+// runHead - текущее сообщение куска, по нему куски упорядочены в куче
+type runHead struct {
+	run     sortedRun
+	current MsgData
+}
+
+type runHeap []*runHead
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return compareMessages(h[i].current, h[j].current) < 0 }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*runHead))
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// push добавляет кусок в кучу, если он не пуст
+func (h *runHeap) push(r sortedRun) error {
+	current, ok, err := r.next()
+	if err != nil || !ok {
+		return err
+	}
+
+	heap.Push(h, &runHead{run: r, current: current})
+	return nil
+}
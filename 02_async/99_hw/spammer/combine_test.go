@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomMessages(n int) []MsgData {
+	r := rand.New(rand.NewSource(42)) //nolint: gosec
+	messages := make([]MsgData, 0, n)
+	for i := 0; i < n; i++ {
+		messages = append(messages, MsgData{ID: MsgID(r.Uint64()), HasSpam: r.Intn(2) == 1})
+	}
+	return messages
+}
+
+func combine(t *testing.T, stage Stage[MsgData, string], messages []MsgData) []string {
+	t.Helper()
+
+	in := make(chan MsgData)
+	go func() {
+		defer close(in)
+		for _, message := range messages {
+			in <- message
+		}
+	}()
+
+	out := make(chan string, len(messages))
+	require.NoError(t, RunStage(context.Background(), stage, in, out))
+
+	var results []string
+	for line := range out {
+		results = append(results, line)
+	}
+	return results
+}
+
+func TestCombinerSameAsCombineResults(t *testing.T) {
+	messages := randomMessages(1000)
+	expected := combine(t, CombineResultsStage, messages)
+
+	for _, maxInMemory := range []int{1, 7, 100, 1000, 5000} {
+		tempDir := t.TempDir()
+		combiner := Combiner{MaxInMemory: maxInMemory, TempDir: tempDir}
+
+		assert.Equal(t, expected, combine(t, combiner.Run, messages), "MaxInMemory: %d", maxInMemory)
+
+		combiner.MaxMergeFanIn = 2
+		assert.Equal(t, expected, combine(t, combiner.Run, messages), "MaxInMemory: %d, MaxMergeFanIn: 2", maxInMemory)
+
+		files, err := os.ReadDir(tempDir)
+		require.NoError(t, err)
+		assert.Empty(t, files, "временные файлы должны быть удалены")
+	}
+}
+
+func TestExternalSorterSpills(t *testing.T) {
+	sorter := newExternalSorter(10, t.TempDir())
+	defer sorter.Close()
+
+	messages := randomMessages(95)
+	for _, message := range messages {
+		require.NoError(t, sorter.Add(message))
+	}
+
+	assert.Len(t, sorter.runs, 9)
+	assert.Len(t, sorter.buffer, 5)
+
+	var merged []MsgData
+	require.NoError(t, sorter.Merge(func(message MsgData) error {
+		merged = append(merged, message)
+		return nil
+	}))
+
+	sortMessages(messages)
+	assert.Equal(t, messages, merged)
+}
+
+// кусков больше, чем сливается за раз: лишние сливаются заранее, файлов открыто не больше fanIn
+func TestExternalSorterMergePasses(t *testing.T) {
+	sorter := newExternalSorter(10, t.TempDir())
+	sorter.fanIn = 3
+	defer sorter.Close()
+
+	messages := randomMessages(95)
+	for _, message := range messages {
+		require.NoError(t, sorter.Add(message))
+	}
+	require.Len(t, sorter.runs, 9)
+
+	var merged []MsgData
+	require.NoError(t, sorter.Merge(func(message MsgData) error {
+		merged = append(merged, message)
+		return nil
+	}))
+
+	sortMessages(messages)
+	assert.Equal(t, messages, merged)
+	assert.Len(t, sorter.runs, 1)
+
+	files, err := os.ReadDir(sorter.dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "слитые куски удаляются")
+}
+
+// в потоковом режиме каждый кусок упорядочен как в CombineResults, куски идут в порядке прихода
+func TestCombinerStream(t *testing.T) {
+	messages := randomMessages(1000)
+
+	for _, maxInMemory := range []int{1, 7, 100, 1000} {
+		expected := []string{}
+		for start := 0; start < len(messages); start += maxInMemory {
+			end := start + maxInMemory
+			if end > len(messages) {
+				end = len(messages)
+			}
+			expected = append(expected, combine(t, CombineResultsStage, messages[start:end])...)
+		}
+
+		combiner := Combiner{MaxInMemory: maxInMemory, Stream: true}
+		assert.Equal(t, expected, combine(t, combiner.Run, messages), "MaxInMemory: %d", maxInMemory)
+	}
+}
+
+// неполный кусок отдаётся через Linger, не дожидаясь конца входа
+func TestCombinerStreamLinger(t *testing.T) {
+	clock := newFakeClock()
+	in := make(chan MsgData)
+	out := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- RunStage(context.Background(), Combiner{MaxInMemory: 3, Stream: true, Linger: time.Second, Clock: clock}.Run, in, out)
+	}()
+
+	in <- MsgData{ID: 2}
+	clock.waitTimer(t)
+	in <- MsgData{ID: 1, HasSpam: true}
+	clock.Advance(time.Second)
+	assert.Equal(t, "true 1", <-out)
+	assert.Equal(t, "false 2", <-out)
+
+	in <- MsgData{ID: 3}
+	clock.waitTimer(t)
+	close(in)
+	assert.Equal(t, "false 3", <-out)
+
+	_, open := <-out
+	assert.False(t, open)
+	assert.NoError(t, <-done)
+}
+
+func TestCombinerCancel(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in := make(chan MsgData)
+	done := make(chan error, 1)
+	go func() {
+		done <- Combiner{MaxInMemory: 2, TempDir: tempDir}.Run(ctx, in, make(chan string))
+	}()
+
+	for _, message := range randomMessages(5) {
+		in <- message
+	}
+	cancel()
+
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCombinerCmd(t *testing.T) {
+	messages := randomMessages(50)
+
+	run := func(combineCmd cmd) []string {
+		results := []string{}
+		RunPipeline(
+			cmd(func(in, out chan interface{}) {
+				for _, message := range messages {
+					out <- message
+				}
+				out <- "не сообщение"
+			}),
+			combineCmd,
+			cmd(newCollectStrings(&results)),
+		)
+		return results
+	}
+
+	results := run(Combiner{MaxInMemory: 8, TempDir: t.TempDir()}.Cmd())
+
+	assert.Len(t, results, 50)
+	assert.Equal(t, run(CombineResults), results)
+}
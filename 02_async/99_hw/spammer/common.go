@@ -14,6 +14,11 @@ type cmd func(in, out chan interface{})
 var GetMessagesMaxUsersBatch = 2
 var HasSpamMaxAsyncRequests = 5
 
+var (
+	errTooManyUsers    = errors.New("to many users")
+	errTooManyRequests = errors.New("too many requests")
+)
+
 func init() {
 	log.SetFlags(log.Default().Flags() | log.Lmicroseconds)
 }
@@ -76,7 +81,7 @@ func GetMessages(users ...User) (res []MsgID, err error) {
 	if len(users) > GetMessagesMaxUsersBatch {
 		atomic.AddUint32(&stat.ErrorGetMessage, 1)
 		log.Printf("to many users in one batch request %v", users)
-		return nil, errors.New("to many users")
+		return nil, errTooManyUsers
 	}
 
 	// это симуляция похода в сервис хранения писем и получения списка писем по юзерам
@@ -119,7 +124,7 @@ func HasSpam(id MsgID) (res bool, err error) {
 	if !ok {
 		atomic.AddUint32(&stat.ErrorHasSpam, 1)
 		log.Printf("got antibrute error from antispam for message %d", id)
-		return true, errors.New("too many requests")
+		return true, errTooManyRequests
 	}
 
 	// это симуляция похода в сервис антиспама и получения факта реального наличия спама в письме
@@ -134,6 +139,12 @@ type Stat struct {
 	RunHasSpam            uint32
 	ErrorGetMessage       uint32
 	ErrorHasSpam          uint32
+
+	// повторы и предохранители вызовов, см. retry.go
+	Retries          uint32
+	RetriesExhausted uint32
+	CircuitOpened    uint32
+	CircuitRejected  uint32
 }
 
 var stat = Stat{}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// HTTP-протокол бэкенда, его говорят HTTPBackend и StubServer:
+//
+//	GET  /users?email=...   -> {"id": 1, "email": "..."}
+//	POST /messages          [{"id": 1, "email": "..."}] -> [1, 2, 3]
+//	GET  /spam?id=...       -> {"has_spam": true}
+//
+// Ошибки приходят как {"error": "..."}: 413 - слишком большой батч пользователей,
+// 429 - антиспам перегружен, 502, 503 и 504 - сервис недоступен; последние два случая можно повторять
+const (
+	usersPath    = "/users"
+	messagesPath = "/messages"
+	spamPath     = "/spam"
+)
+
+var (
+	errBackendStatus = errors.New("бэкенд ответил ошибкой")
+	// errBackendUnavailable - до сервиса не дошли или он временно не отвечает, повтор может пройти
+	errBackendUnavailable = errors.New("бэкенд недоступен")
+)
+
+type wireUser struct {
+	ID    uint64 `json:"id"`
+	Email string `json:"email"`
+}
+
+type wireSpam struct {
+	HasSpam bool `json:"has_spam"`
+}
+
+type wireError struct {
+	Error string `json:"error"`
+}
+
+// HTTPBackend ходит в сервис бэкенда по HTTP; подходит сразу как UserDirectory,
+// MessageStore и AntispamChecker
+type HTTPBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPBackend - клиент сервиса по адресу baseURL, client == nil - http.DefaultClient
+func NewHTTPBackend(baseURL string, client *http.Client) *HTTPBackend {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPBackend{baseURL: baseURL, client: client}
+}
+
+// Backend - все три сервиса в одном
+func (backend *HTTPBackend) Backend() Backend {
+	return Backend{Users: backend, Messages: backend, Antispam: backend}
+}
+
+func (backend *HTTPBackend) GetUser(ctx context.Context, email string) (User, error) {
+	query := url.Values{"email": {email}}
+
+	user := wireUser{}
+	if err := backend.do(ctx, http.MethodGet, usersPath+"?"+query.Encode(), nil, &user); err != nil {
+		return User{}, err
+	}
+
+	return User{ID: user.ID, Email: user.Email}, nil
+}
+
+func (backend *HTTPBackend) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	request := make([]wireUser, 0, len(users))
+	for _, user := range users {
+		request = append(request, wireUser{ID: user.ID, Email: user.Email})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	msgIDs := []MsgID{}
+	if err := backend.do(ctx, http.MethodPost, messagesPath, body, &msgIDs); err != nil {
+		return nil, err
+	}
+
+	return msgIDs, nil
+}
+
+func (backend *HTTPBackend) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	query := url.Values{"id": {strconv.FormatUint(uint64(id), 10)}}
+
+	spam := wireSpam{}
+	if err := backend.do(ctx, http.MethodGet, spamPath+"?"+query.Encode(), nil, &spam); err != nil {
+		return false, err
+	}
+
+	return spam.HasSpam, nil
+}
+
+func (backend *HTTPBackend) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, backend.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := backend.client.Do(req)
+	if err != nil {
+		// отмена или таймаут вызывающего - не беда сервиса
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("%s %s: не удалось разобрать ответ: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// statusError превращает ответ с ошибкой в те же ошибки, что возвращает симуляция,
+// чтобы повторы и предохранители работали одинаково
+func statusError(resp *http.Response) error {
+	message := wireError{}
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil || message.Error == "" {
+		message.Error = resp.Status
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", errTooManyRequests, message.Error)
+	case http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %s", errTooManyUsers, message.Error)
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: %d %s", errBackendUnavailable, resp.StatusCode, message.Error)
+	}
+
+	return fmt.Errorf("%w: %d %s", errBackendStatus, resp.StatusCode, message.Error)
+}
+
+// StubServer - локальный сервис бэкенда поверх любого Backend, например симуляции
+// или заглушки в тестах. Поднимается через httptest.NewServer или http.ListenAndServe
+type StubServer struct {
+	backend Backend
+	mux     *http.ServeMux
+}
+
+func NewStubServer(backend Backend) *StubServer {
+	server := &StubServer{backend: backend, mux: http.NewServeMux()}
+	server.mux.HandleFunc(usersPath, server.handleUsers)
+	server.mux.HandleFunc(messagesPath, server.handleMessages)
+	server.mux.HandleFunc(spamPath, server.handleSpam)
+
+	return server
+}
+
+func (server *StubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+func (server *StubServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, wireError{Error: "нужен GET"})
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: "не указан email"})
+		return
+	}
+
+	user, err := server.backend.Users.GetUser(r.Context(), email)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wireUser{ID: user.ID, Email: user.Email})
+}
+
+func (server *StubServer) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, wireError{Error: "нужен POST"})
+		return
+	}
+
+	request := []wireUser{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: "не удалось разобрать пользователей: " + err.Error()})
+		return
+	}
+
+	users := make([]User, 0, len(request))
+	for _, user := range request {
+		users = append(users, User{ID: user.ID, Email: user.Email})
+	}
+
+	msgIDs, err := server.backend.Messages.GetMessages(r.Context(), users...)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if msgIDs == nil {
+		msgIDs = []MsgID{}
+	}
+
+	writeJSON(w, http.StatusOK, msgIDs)
+}
+
+func (server *StubServer) handleSpam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, wireError{Error: "нужен GET"})
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: "плохой id письма"})
+		return
+	}
+
+	isSpam, err := server.backend.Antispam.HasSpam(r.Context(), MsgID(id))
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wireSpam{HasSpam: isSpam})
+}
+
+func writeBackendError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errTooManyRequests):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, errTooManyUsers):
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	writeJSON(w, status, wireError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body) //nolint:errcheck
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// виды записей журнала
+const (
+	journalUser     = "user"
+	journalMessages = "messages"
+	journalSpam     = "spam"
+)
+
+var errBadJournal = errors.New("журнал повреждён")
+
+// journalRecord - одна строка журнала, заполнены поля своего вида
+type journalRecord struct {
+	Kind string `json:"kind"`
+
+	Email string    `json:"email,omitempty"`
+	User  *wireUser `json:"user,omitempty"`
+
+	Users    []uint64 `json:"users,omitempty"`
+	Messages []MsgID  `json:"messages,omitempty"`
+
+	ID      MsgID `json:"id,omitempty"`
+	HasSpam bool  `json:"has_spam,omitempty"`
+}
+
+// Journal - контрольные точки конвейера в локальном файле: найденные пользователи,
+// письма и вердикты антиспама дописываются туда по мере получения.
+// Повторный запуск с тем же входом берёт готовое из журнала и ходит в бэкенд
+// только за тем, что не успели сделать, а результат получается тот же.
+// Журнал годится только для того же входа: письма хранятся батчами, как их вернул бэкенд
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+
+	users    map[string]User
+	batches  [][]MsgID
+	batchOf  map[uint64]int
+	replayed map[int]bool
+	verdicts map[MsgID]bool
+
+	// сколько вызовов бэкенда заменил журнал
+	hits int
+}
+
+// OpenJournal открывает журнал или создаёт новый. Недописанная последняя строка
+// (запуск прервали посреди записи) отбрасывается
+func OpenJournal(fileName string) (*Journal, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть журнал: %w", err)
+	}
+
+	journal := &Journal{
+		file:     file,
+		users:    map[string]User{},
+		batchOf:  map[uint64]int{},
+		replayed: map[int]bool{},
+		verdicts: map[MsgID]bool{},
+	}
+
+	if err := journal.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return journal, nil
+}
+
+func (journal *Journal) load() error {
+	reader := bufio.NewReader(journal.file)
+
+	var good int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(data)) > 0 {
+				// строка без перевода строки - запись оборвалась, дописываем после последней целой
+				if err := journal.file.Truncate(good); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := journalRecord{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("%w: строка %d: %v", errBadJournal, line, err)
+		}
+		if err := journal.apply(record); err != nil {
+			return fmt.Errorf("%w: строка %d: %v", errBadJournal, line, err)
+		}
+		good += int64(len(data))
+	}
+
+	_, err := journal.file.Seek(good, io.SeekStart)
+	return err
+}
+
+func (journal *Journal) apply(record journalRecord) error {
+	switch record.Kind {
+	case journalUser:
+		if record.User == nil {
+			return errors.New("нет пользователя")
+		}
+		journal.users[record.Email] = User{ID: record.User.ID, Email: record.User.Email}
+
+	case journalMessages:
+		batch := len(journal.batches)
+		journal.batches = append(journal.batches, record.Messages)
+		for _, userID := range record.Users {
+			journal.batchOf[userID] = batch
+		}
+
+	case journalSpam:
+		journal.verdicts[record.ID] = record.HasSpam
+
+	default:
+		return fmt.Errorf("неизвестный вид записи %q", record.Kind)
+	}
+
+	return nil
+}
+
+// write дописывает запись в файл и применяет её; вызывается под mu
+func (journal *Journal) write(record journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := journal.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("не удалось записать в журнал: %w", err)
+	}
+
+	return journal.apply(record)
+}
+
+// Hits - сколько вызовов бэкенда заменил журнал
+func (journal *Journal) Hits() int {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.hits
+}
+
+func (journal *Journal) Close() error {
+	if err := journal.file.Sync(); err != nil {
+		journal.file.Close()
+		return err
+	}
+
+	return journal.file.Close()
+}
+
+// Wrap - backend, который сначала смотрит в журнал и записывает туда всё, что получил
+func (journal *Journal) Wrap(backend Backend) Backend {
+	journaled := &journaledBackend{journal: journal, backend: backend}
+	return Backend{Users: journaled, Messages: journaled, Antispam: journaled}
+}
+
+type journaledBackend struct {
+	journal *Journal
+	backend Backend
+}
+
+func (journaled *journaledBackend) GetUser(ctx context.Context, email string) (User, error) {
+	journal := journaled.journal
+
+	journal.mu.Lock()
+	user, ok := journal.users[email]
+	if ok {
+		journal.hits++
+	}
+	journal.mu.Unlock()
+
+	if ok {
+		return user, nil
+	}
+
+	user, err := journaled.backend.Users.GetUser(ctx, email)
+	if err != nil {
+		return User{}, err
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return user, journal.write(journalRecord{Kind: journalUser, Email: email, User: &wireUser{ID: user.ID, Email: user.Email}})
+}
+
+// GetMessages спрашивает бэкенд только про пользователей, которых нет в журнале.
+// Письма записанного батча отдаются целиком один раз - вместе с первым его пользователем
+func (journaled *journaledBackend) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	journal := journaled.journal
+
+	var fresh []User
+	var batches []int
+
+	journal.mu.Lock()
+	for _, user := range users {
+		batch, ok := journal.batchOf[user.ID]
+		if !ok {
+			fresh = append(fresh, user)
+			continue
+		}
+		batches = append(batches, batch)
+	}
+	journal.mu.Unlock()
+
+	var fetched []MsgID
+	if len(fresh) > 0 {
+		var err error
+		fetched, err = journaled.backend.Messages.GetMessages(ctx, fresh...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	// батч помечается отданным только после успешного вызова, иначе повтор потеряет его письма
+	var msgIDs []MsgID
+	for _, batch := range batches {
+		journal.hits++
+		if journal.replayed[batch] {
+			continue
+		}
+		journal.replayed[batch] = true
+		msgIDs = append(msgIDs, journal.batches[batch]...)
+	}
+
+	if len(fresh) == 0 {
+		return msgIDs, nil
+	}
+
+	record := journalRecord{Kind: journalMessages, Messages: fetched}
+	for _, user := range fresh {
+		record.Users = append(record.Users, user.ID)
+	}
+	journal.replayed[len(journal.batches)] = true
+	if err := journal.write(record); err != nil {
+		return nil, err
+	}
+
+	return append(msgIDs, fetched...), nil
+}
+
+func (journaled *journaledBackend) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	journal := journaled.journal
+
+	journal.mu.Lock()
+	isSpam, ok := journal.verdicts[id]
+	if ok {
+		journal.hits++
+	}
+	journal.mu.Unlock()
+
+	if ok {
+		return isSpam, nil
+	}
+
+	isSpam, err := journaled.backend.Antispam.HasSpam(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return isSpam, journal.write(journalRecord{Kind: journalSpam, ID: id, HasSpam: isSpam})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestBackend = errors.New("бэкенд упал")
+
+// countingBackend считает вызовы и после spamLimit вердиктов начинает падать
+type countingBackend struct {
+	fakeBackend
+	userCalls, messageCalls, spamCalls int32
+	spamLimit                          int32
+}
+
+func (backend *countingBackend) GetUser(ctx context.Context, email string) (User, error) {
+	atomic.AddInt32(&backend.userCalls, 1)
+	return backend.fakeBackend.GetUser(ctx, email)
+}
+
+func (backend *countingBackend) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	atomic.AddInt32(&backend.messageCalls, 1)
+	return backend.fakeBackend.GetMessages(ctx, users...)
+}
+
+func (backend *countingBackend) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	if calls := atomic.AddInt32(&backend.spamCalls, 1); backend.spamLimit > 0 && calls > backend.spamLimit {
+		return false, errTestBackend
+	}
+	return backend.fakeBackend.HasSpam(ctx, id)
+}
+
+func (backend *countingBackend) Backend() Backend {
+	return Backend{Users: backend, Messages: backend, Antispam: backend}
+}
+
+func runJournaled(t *testing.T, fileName string, backend *countingBackend, emails ...string) ([]string, error) {
+	t.Helper()
+
+	journal, err := OpenJournal(fileName)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, journal.Close()) }()
+
+	return runSpam(t, journal.Wrap(backend.Backend()).Pipeline(Resilience{}, CombineResultsStage), emails...)
+}
+
+func TestJournalResume(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+	emails := []string{"a@mail.ru", "bb@mail.ru", "ccc@mail.ru", "dddd@mail.ru", "eeeee@mail.ru"}
+
+	clean := &countingBackend{fakeBackend: fakeBackend{maxBatch: 2}}
+	expected, err := runSpam(t, clean.Backend().Pipeline(Resilience{}, CombineResultsStage), emails...)
+	require.NoError(t, err)
+	require.Len(t, expected, 15)
+
+	// первый запуск обрывается на середине проверок
+	broken := &countingBackend{fakeBackend: fakeBackend{maxBatch: 2}, spamLimit: 7}
+	_, err = runJournaled(t, fileName, broken, emails...)
+	require.ErrorIs(t, err, errTestBackend)
+
+	resumed := &countingBackend{fakeBackend: fakeBackend{maxBatch: 2}}
+	results, err := runJournaled(t, fileName, resumed, emails...)
+	require.NoError(t, err)
+	assert.Equal(t, expected, results)
+
+	assert.Zero(t, resumed.userCalls)
+	assert.Less(t, resumed.spamCalls, int32(15))
+
+	// третий запуск целиком из журнала
+	replayed := &countingBackend{fakeBackend: fakeBackend{maxBatch: 2}}
+	results, err = runJournaled(t, fileName, replayed, emails...)
+	require.NoError(t, err)
+	assert.Equal(t, expected, results)
+	assert.Equal(t, [3]int32{}, [3]int32{replayed.userCalls, replayed.messageCalls, replayed.spamCalls})
+}
+
+func TestJournalTornWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	backend := &countingBackend{fakeBackend: fakeBackend{maxBatch: 2}}
+	expected, err := runJournaled(t, fileName, backend, "a@mail.ru")
+	require.NoError(t, err)
+
+	// запуск убили посреди записи
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"kind":"spam","id":`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	results, err := runJournaled(t, fileName, backend, "a@mail.ru", "bb@mail.ru")
+	require.NoError(t, err)
+	assert.Len(t, results, 6)
+	assert.Subset(t, results, expected)
+
+	// дописанное после обрыва читается
+	journal, err := OpenJournal(fileName)
+	require.NoError(t, err)
+	assert.Len(t, journal.users, 2)
+	require.NoError(t, journal.Close())
+}
+
+func TestJournalCorrupted(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+	require.NoError(t, os.WriteFile(fileName, []byte("{\"kind\":\"spam\",\"id\":1}\nмусор\n{\"kind\":\"spam\",\"id\":2}\n"), 0o600))
+
+	_, err := OpenJournal(fileName)
+	assert.ErrorIs(t, err, errBadJournal)
+	assert.Contains(t, err.Error(), "строка 2")
+
+	require.NoError(t, os.WriteFile(fileName, []byte("{\"kind\":\"user\"}\n"), 0o600))
+	_, err = OpenJournal(fileName)
+	assert.ErrorIs(t, err, errBadJournal)
+}
+
+func TestRunJournal(t *testing.T) {
+	dir := t.TempDir()
+	inFileName := filepath.Join(dir, "users.txt")
+	journalFileName := filepath.Join(dir, "journal.jsonl")
+	require.NoError(t, os.WriteFile(inFileName, []byte("harry.dubois@mail.ru\n"), 0o600))
+
+	outputs := []string{}
+	for i := 0; i < 2; i++ {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run([]string{"-in", inFileName, "-journal", journalFileName}, strings.NewReader(""), stdout, stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		outputs = append(outputs, stdout.String())
+
+		if i == 1 {
+			assert.Contains(t, stderr.String(), "вызовов бэкенда взято из журнала: 7")
+		}
+	}
+
+	assert.Equal(t, outputs[0], outputs[1])
+	assert.Len(t, strings.Split(strings.TrimSpace(outputs[0]), "\n"), 5)
+}
@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket ограничивает частоту вызовов: в среднем rate в секунду,
+// после простоя можно сразу сделать до burst вызовов
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// now и newTimer в тестах подменяются на ручное время
+	now      func() time.Time
+	newTimer func(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+// NewTokenBucket возвращает ErrBadRate, если rate не больше нуля, и ErrBadBurst, если burst меньше единицы:
+// с таким ограничителем Wait либо ждал бы вечно, либо не ограничивал бы ничего
+func NewTokenBucket(rate float64, burst int) (*TokenBucket, error) {
+	if !(rate > 0) {
+		return nil, ErrBadRate
+	}
+	if burst < 1 {
+		return nil, ErrBadBurst
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		newTimer: func(d time.Duration) (<-chan time.Time, func() bool) {
+			timer := time.NewTimer(d)
+			return timer.C, timer.Stop
+		},
+	}, nil
+}
+
+// refill добавляет токены, накопившиеся с прошлого раза
+func (bucket *TokenBucket) refill(now time.Time) {
+	if !bucket.last.IsZero() {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+	}
+	bucket.last = now
+}
+
+// Allow берёт токен, только если он есть прямо сейчас
+func (bucket *TokenBucket) Allow() bool {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill(bucket.now())
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// Wait ждёт токен, пока не отменён ctx.
+// Токен резервируется сразу, так что ожидающие получают токены в порядке вызова Wait
+func (bucket *TokenBucket) Wait(ctx context.Context) error {
+	bucket.mu.Lock()
+	bucket.refill(bucket.now())
+	bucket.tokens--
+	tokens := bucket.tokens
+	bucket.mu.Unlock()
+
+	if tokens >= 0 {
+		return nil
+	}
+
+	wait := time.Duration(-tokens / bucket.rate * float64(time.Second))
+	ready, stop := bucket.newTimer(wait)
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		stop()
+
+		// резерв не использован - возвращаем токен
+		bucket.mu.Lock()
+		bucket.tokens++
+		bucket.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,222 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphore(t *testing.T) {
+	sem := NewSemaphore(2)
+
+	require.NoError(t, sem.Acquire(context.Background()))
+	assert.True(t, sem.TryAcquire())
+	assert.False(t, sem.TryAcquire())
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- sem.Acquire(context.Background())
+	}()
+
+	sem.Release()
+	require.NoError(t, <-acquired)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, sem.Acquire(ctx), context.Canceled)
+
+	assert.Panics(t, func() {
+		NewSemaphore(1).Release()
+	})
+}
+
+// newQueuedWeighted - семафор, у которого тест может дождаться, пока вызов встанет в очередь
+func newQueuedWeighted(size int64) (*Weighted, chan struct{}) {
+	queued := make(chan struct{}, 10)
+	sem := NewWeighted(size)
+	sem.queued = func() { queued <- struct{}{} }
+
+	return sem, queued
+}
+
+// acquireAsync - Acquire в отдельной горутине, результат приходит в канал
+func acquireAsync(ctx context.Context, sem *Weighted, n int64) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- sem.Acquire(ctx, n)
+	}()
+
+	return done
+}
+
+func TestWeighted(t *testing.T) {
+	sem, queued := newQueuedWeighted(5)
+
+	require.NoError(t, sem.Acquire(context.Background(), 3))
+	assert.ErrorIs(t, sem.Acquire(context.Background(), 6), ErrTooHeavy)
+
+	// тяжёлый вызов встал в очередь, лёгкий не может его обогнать
+	heavy := acquireAsync(context.Background(), sem, 4)
+	<-queued
+	assert.False(t, sem.TryAcquire(1))
+
+	light := acquireAsync(context.Background(), sem, 1)
+	<-queued
+
+	sem.Release(3)
+	require.NoError(t, <-heavy)
+	require.NoError(t, <-light)
+
+	sem.Release(5)
+	assert.True(t, sem.TryAcquire(5))
+	sem.Release(5)
+
+	assert.Panics(t, func() {
+		sem.Release(1)
+	})
+}
+
+// места занимаются все сразу: пока свободна только часть, вызов ждёт и ничего не держит
+func TestWeightedPartial(t *testing.T) {
+	sem, queued := newQueuedWeighted(4)
+	require.NoError(t, sem.Acquire(context.Background(), 4))
+
+	done := acquireAsync(context.Background(), sem, 3)
+	<-queued
+
+	// Release будит ожидающих сам, так что после него состояние уже окончательное
+	sem.Release(1)
+	sem.Release(1)
+	sem.mu.Lock()
+	assert.Equal(t, int64(2), sem.used)
+	assert.Equal(t, 1, sem.waiters.Len())
+	sem.mu.Unlock()
+
+	sem.Release(1)
+	require.NoError(t, <-done)
+	assert.False(t, sem.TryAcquire(1))
+}
+
+// отменённый вызов ничего не занимает, а отменённый первый в очереди пропускает тех, кому мест хватает
+func TestWeightedCancel(t *testing.T) {
+	sem, queued := newQueuedWeighted(2)
+	require.NoError(t, sem.Acquire(context.Background(), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	heavy := acquireAsync(ctx, sem, 2)
+	<-queued
+
+	light := acquireAsync(context.Background(), sem, 1)
+	<-queued
+
+	cancel()
+	assert.ErrorIs(t, <-heavy, context.Canceled)
+	require.NoError(t, <-light)
+
+	sem.Release(2)
+	assert.True(t, sem.TryAcquire(2))
+
+	// отменённый заранее контекст не мешает взять свободные места, но прерывает ожидание
+	sem.Release(1)
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	assert.ErrorIs(t, sem.Acquire(canceled, 2), context.Canceled)
+	require.NoError(t, sem.Acquire(canceled, 1))
+}
+
+// fakeTime - ручное время для TokenBucket: каждый таймер приходит в timers, тест сам решает, когда он сработает
+type fakeTime struct {
+	now    time.Time
+	timers chan fakeTimer
+}
+
+type fakeTimer struct {
+	wait time.Duration
+	fire chan time.Time
+}
+
+func newFakeBucket(t *testing.T, rate float64, burst int) (*TokenBucket, *fakeTime) {
+	t.Helper()
+
+	clock := &fakeTime{
+		now:    time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		timers: make(chan fakeTimer, 10),
+	}
+
+	bucket, err := NewTokenBucket(rate, burst)
+	require.NoError(t, err)
+	bucket.now = func() time.Time { return clock.now }
+	bucket.newTimer = func(d time.Duration) (<-chan time.Time, func() bool) {
+		timer := fakeTimer{wait: d, fire: make(chan time.Time, 1)}
+		clock.timers <- timer
+		return timer.fire, func() bool { return true }
+	}
+
+	return bucket, clock
+}
+
+func TestTokenBucketAllow(t *testing.T) {
+	bucket, clock := newFakeBucket(t, 10, 3)
+
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+
+	clock.now = clock.now.Add(100 * time.Millisecond)
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+
+	// после долгого простоя накапливается не больше burst
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, bucket.Allow())
+	}
+	assert.False(t, bucket.Allow())
+
+}
+
+func TestNewTokenBucketErrors(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		_, err := NewTokenBucket(rate, 1)
+		assert.ErrorIs(t, err, ErrBadRate, rate)
+	}
+
+	_, err := NewTokenBucket(1, 0)
+	assert.ErrorIs(t, err, ErrBadBurst)
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	bucket, clock := newFakeBucket(t, 4, 1)
+
+	require.NoError(t, bucket.Wait(context.Background()))
+
+	// токена нет - ждём, пока накопится: 250мс
+	done := make(chan error)
+	go func() {
+		done <- bucket.Wait(context.Background())
+	}()
+	timer := <-clock.timers
+	assert.Equal(t, 250*time.Millisecond, timer.wait)
+	timer.fire <- clock.now
+	require.NoError(t, <-done)
+
+	// первый токен зарезервирован, следующему ждать вдвое дольше
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- bucket.Wait(ctx)
+	}()
+	timer = <-clock.timers
+	assert.Equal(t, 500*time.Millisecond, timer.wait)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// отменённый резерв вернулся: через 500мс токен снова есть
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+}
@@ -0,0 +1,163 @@
+// Package limiter - ограничители для параллельных стадий конвейера:
+// семафор, взвешенный семафор и token bucket. Все ожидания прерываются контекстом
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrTooHeavy = errors.New("limiter: вес больше размера семафора")
+	ErrBadRate  = errors.New("limiter: rate должен быть положительным числом")
+	ErrBadBurst = errors.New("limiter: burst должен быть не меньше единицы")
+)
+
+// Semaphore ограничивает число одновременно выполняемых вызовов
+type Semaphore struct {
+	slots chan struct{}
+}
+
+func NewSemaphore(size int) *Semaphore {
+	return &Semaphore{slots: make(chan struct{}, size)}
+}
+
+// Acquire ждёт свободного места, пока не отменён ctx
+func (sem *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case sem.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAcquire занимает место, только если оно свободно прямо сейчас
+func (sem *Semaphore) TryAcquire() bool {
+	select {
+	case sem.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sem *Semaphore) Release() {
+	select {
+	case <-sem.slots:
+	default:
+		panic("limiter: Release без Acquire")
+	}
+}
+
+// Weighted - семафор, в котором вызов занимает несколько мест сразу, например батч из n пользователей.
+// Ожидающие обслуживаются по очереди: тяжёлый вызов не голодает из-за потока лёгких
+type Weighted struct {
+	size    int64
+	mu      sync.Mutex
+	used    int64
+	waiters list.List
+
+	// queued в тестах сообщает, что вызов встал в очередь
+	queued func()
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func NewWeighted(size int64) *Weighted {
+	return &Weighted{size: size}
+}
+
+// Acquire ждёт n свободных мест, пока не отменён ctx
+func (sem *Weighted) Acquire(ctx context.Context, n int64) error {
+	if n > sem.size {
+		return ErrTooHeavy
+	}
+
+	sem.mu.Lock()
+	if sem.size-sem.used >= n && sem.waiters.Len() == 0 {
+		sem.used += n
+		sem.mu.Unlock()
+		return nil
+	}
+
+	w := waiter{n: n, ready: make(chan struct{})}
+	elem := sem.waiters.PushBack(w)
+	sem.mu.Unlock()
+
+	if sem.queued != nil {
+		sem.queued()
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		sem.mu.Lock()
+		defer sem.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			// места выдали одновременно с отменой - считаем, что успели
+			return nil
+		default:
+		}
+
+		isFront := sem.waiters.Front() == elem
+		sem.waiters.Remove(elem)
+		// первый в очереди мог держать остальных, которым мест уже хватает
+		if isFront {
+			sem.notifyWaiters()
+		}
+		return ctx.Err()
+	}
+}
+
+// TryAcquire занимает n мест, только если они свободны и никто не ждёт
+func (sem *Weighted) TryAcquire(n int64) bool {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+
+	if sem.size-sem.used >= n && sem.waiters.Len() == 0 {
+		sem.used += n
+		return true
+	}
+
+	return false
+}
+
+func (sem *Weighted) Release(n int64) {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+
+	sem.used -= n
+	if sem.used < 0 {
+		panic("limiter: освобождено больше, чем занято")
+	}
+
+	sem.notifyWaiters()
+}
+
+// notifyWaiters будит ожидающих по порядку, пока хватает мест
+func (sem *Weighted) notifyWaiters() {
+	for {
+		front := sem.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(waiter)
+		if sem.size-sem.used < w.n {
+			return
+		}
+
+		sem.used += w.n
+		sem.waiters.Remove(front)
+		close(w.ready)
+	}
+}
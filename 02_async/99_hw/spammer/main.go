@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// коды выхода
+const (
+	exitOK = 0
+	// не удалось прочитать вход или записать результат
+	exitFailure = 1
+	// неправильные флаги
+	exitUsage = 2
+	// результат есть, но часть записей входа или вызовов бэкенда потеряна
+	exitPartial = 3
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run - вся командная строка: читает email'ы, прогоняет их через конвейер и пишет результат.
+// Возвращает код выхода
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("spammer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	inFileName := flags.String("in", "", "файл с email'ами, пустой или - - stdin")
+	inFormat := flags.String("in-format", "", "формат входа: lines, csv, jsonl; по умолчанию по расширению файла")
+	outFileName := flags.String("out", "", "файл для результата, пустой - stdout")
+	outFormat := flags.String("format", formatText, "формат результата: text, json, csv")
+	batch := flags.Int("batch", GetMessagesMaxUsersBatch, "сколько пользователей запрашивать в GetMessages за раз")
+	concurrency := flags.Int("concurrency", HasSpamMaxAsyncRequests, "сколько запросов к антиспаму выполнять одновременно")
+	linger := flags.Duration("linger", GetMessagesBatchLinger, "сколько неполный батч ждёт пользователей перед GetMessages, 0 - до заполнения")
+	combineMode := flags.String("combine", combineMemory, "сборка результата: memory, external - с временными файлами, stream - по ходу, упорядоченно кусками")
+	combineMemoryLimit := flags.Int("combine-memory", defaultMaxInMemory, "для external и stream: сколько сообщений держать в памяти")
+	combineLinger := flags.Duration("combine-linger", time.Second, "для stream: через сколько отдавать неполный кусок, 0 - только полный")
+	verbose := flags.Bool("v", false, "писать в stderr лог вызовов бэкенда")
+	journalFileName := flags.String("journal", "", "журнал для продолжения прерванного запуска; с ним ошибка бэкенда останавливает запуск")
+	metricsAddr := flags.String("metrics", "", "адрес, на котором отдавать метрики стадий для Prometheus (/metrics), пока идёт запуск")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *batch < 1 || *concurrency < 1 {
+		fmt.Fprintln(stderr, "batch и concurrency должны быть больше нуля")
+		return exitUsage
+	}
+	if *linger < 0 {
+		fmt.Fprintln(stderr, "linger не может быть отрицательным")
+		return exitUsage
+	}
+	// больше, чем разрешает бэкенд, просить бесполезно - он ответит ошибкой
+	if *batch > GetMessagesMaxUsersBatch || *concurrency > HasSpamMaxAsyncRequests {
+		fmt.Fprintf(stderr, "бэкенд разрешает batch не больше %d и concurrency не больше %d\n",
+			GetMessagesMaxUsersBatch, HasSpamMaxAsyncRequests)
+		return exitUsage
+	}
+
+	combineCmd, combineStage, err := newCombine(*combineMode, Combiner{MaxInMemory: *combineMemoryLimit, Linger: *combineLinger})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	if *combineMemoryLimit < 1 || *combineLinger < 0 {
+		fmt.Fprintln(stderr, "combine-memory должен быть больше нуля, а combine-linger не может быть отрицательным")
+		return exitUsage
+	}
+
+	if *inFormat == "" {
+		*inFormat = inputFormatOf(*inFileName)
+	}
+	if err := checkFormats(*inFormat, *outFormat); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	defer func(batch, concurrency int, linger time.Duration) {
+		MessagesBatchSize, SpamCheckConcurrency, GetMessagesBatchLinger = batch, concurrency, linger
+	}(MessagesBatchSize, SpamCheckConcurrency, GetMessagesBatchLinger)
+	MessagesBatchSize, SpamCheckConcurrency, GetMessagesBatchLinger = *batch, *concurrency, *linger
+
+	if *verbose {
+		log.SetOutput(stderr)
+	} else {
+		log.SetOutput(io.Discard)
+	}
+	defer log.SetOutput(os.Stderr)
+
+	// без -metrics конвейер идёт без наблюдения
+	var observer Observer
+	if *metricsAddr != "" {
+		names := []string{"источник", "SelectUsers", "SelectMessages", "CheckSpam", "сборка", "запись"}
+		if *journalFileName != "" {
+			names = []string{"источник", "конвейер", "запись"}
+		}
+
+		registry := prometheus.NewRegistry()
+		prometheusObserver, err := NewPrometheusObserver(registry, "spammer", names...)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		addr, stop, err := serveMetrics(*metricsAddr, registry)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось запустить сервер метрик:", err)
+			return exitFailure
+		}
+		defer stop() //nolint:errcheck
+
+		fmt.Fprintf(stderr, "метрики: http://%s/metrics\n", addr)
+		observer = prometheusObserver
+	}
+
+	input := stdin
+	if *inFileName != "" && *inFileName != "-" {
+		file, err := os.Open(*inFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось открыть вход:", err)
+			return exitFailure
+		}
+		defer file.Close()
+		input = file
+	}
+
+	output := stdout
+	var outFile io.Closer
+	if *outFileName != "" {
+		file, err := createOutput(*outFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось создать файл результата:", err)
+			return exitFailure
+		}
+		// на обычном пути файл закрывается ниже с проверкой ошибки, повторный Close ничего не портит
+		defer file.Close()
+		output, outFile = file, file
+	}
+
+	writer, err := newResultWriter(output, *outFormat)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	before := snapshotStat()
+
+	var readErr, writeErr error
+	rejected, results, spam := 0, 0, 0
+
+	source := cmd(func(_, out chan interface{}) {
+		readErr = readEmails(input, *inFormat,
+			func(email string) {
+				out <- email
+			},
+			func(err *RecordError) {
+				rejected++
+				fmt.Fprintln(stderr, "пропущена запись:", err)
+			})
+	})
+	sink := cmd(func(in, _ chan interface{}) {
+		for line := range in {
+			if writeErr != nil {
+				continue
+			}
+
+			message, err := parseMessage(line.(string))
+			if err == nil {
+				err = writer.Write(message)
+			}
+			writeErr = err
+
+			results++
+			if message.HasSpam {
+				spam++
+			}
+		}
+	})
+
+	var journal *Journal
+	if *journalFileName == "" {
+		RunPipelineWithObserver(observer, source, SelectUsers, SelectMessages, CheckSpam, combineCmd, sink)
+	} else {
+		var err error
+		journal, err = OpenJournal(*journalFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		defer journal.Close()
+
+		// прерывание останавливает конвейер, а журнал остаётся целым
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		err = RunPipelineObserved(ctx, observer,
+			withContext(source),
+			Untyped(journal.Wrap(SimulatedBackend()).Pipeline(Resilience{}, combineStage)),
+			withContext(sink),
+		)
+		if err != nil {
+			fmt.Fprintln(stderr, "конвейер остановлен:", err)
+			fmt.Fprintln(stderr, "сделанное сохранено в журнале, запустите ещё раз с тем же -journal")
+			return exitFailure
+		}
+	}
+
+	if writeErr == nil {
+		writeErr = writer.Close()
+	}
+	// ошибка Close - тоже ошибка записи: данные могли не дойти до диска
+	if outFile != nil {
+		if err := outFile.Close(); writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	if readErr != nil {
+		fmt.Fprintln(stderr, "ошибка чтения входа:", readErr)
+		return exitFailure
+	}
+	if writeErr != nil {
+		fmt.Fprintln(stderr, "ошибка записи результата:", writeErr)
+		return exitFailure
+	}
+
+	after := snapshotStat()
+	failedCalls := (after.ErrorGetMessage - before.ErrorGetMessage) + (after.ErrorHasSpam - before.ErrorHasSpam)
+
+	fmt.Fprintf(stderr, "писем: %d, спам: %d, пропущено записей: %d, ошибок бэкенда: %d\n",
+		results, spam, rejected, failedCalls)
+	if journal != nil {
+		fmt.Fprintf(stderr, "вызовов бэкенда взято из журнала: %d\n", journal.Hits())
+	}
+
+	if rejected > 0 || failedCalls > 0 {
+		return exitPartial
+	}
+
+	return exitOK
+}
+
+func snapshotStat() Stat {
+	return Stat{
+		ErrorGetMessage: atomic.LoadUint32(&stat.ErrorGetMessage),
+		ErrorHasSpam:    atomic.LoadUint32(&stat.ErrorHasSpam),
+	}
+}
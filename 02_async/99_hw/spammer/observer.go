@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ObservedQueueSize - буфер очереди между стадиями конвейера под наблюдением.
+// По умолчанию буфера нет: стадия, как и без наблюдения, ждёт, пока следующая заберёт значение
+// (relay держит не больше одного), а узкое место видно по тому, сколько его ждала предыдущая стадия.
+// С буфером видна ещё и глубина очереди, но стадии могут уходить вперёд на ObservedQueueSize значений,
+// то есть наблюдение меняет противодавление и число одновременно обрабатываемых значений
+var ObservedQueueSize = 0
+
+type EventKind int
+
+const (
+	// EventStageStart - стадия запустилась
+	EventStageStart EventKind = iota
+	// EventItemIn - значение попало в очередь стадии; Elapsed - сколько предыдущая стадия
+	// ждала места в очереди, QueueDepth - сколько значений в очереди теперь
+	EventItemIn
+	// EventItemOut - стадия отдала значение; Elapsed - задержка: сколько прошло с того, как стадия
+	// взяла значение, из которого получилось это, см. stageTimer
+	EventItemOut
+	// EventStageDone - стадия завершилась; Elapsed - время работы, Err - её ошибка
+	EventStageDone
+)
+
+// PipelineEvent - событие одной стадии, Stage - её номер в конвейере
+type PipelineEvent struct {
+	Stage int
+	Kind  EventKind
+	// Elapsed - промежуток времени, смысл зависит от Kind
+	Elapsed    time.Duration
+	QueueDepth int
+	Err        error
+	// Goroutines - сколько горутин в процессе на момент события
+	Goroutines int
+}
+
+// Observer получает события стадий конвейера, см. RunPipelineObserved.
+// Observe вызывается из горутин стадий, поэтому должен быть потокобезопасным и быстрым
+type Observer interface {
+	Observe(event PipelineEvent)
+}
+
+// MultiObserver раздаёт события нескольким наблюдателям
+type MultiObserver []Observer
+
+func (observers MultiObserver) Observe(event PipelineEvent) {
+	for _, observer := range observers {
+		observer.Observe(event)
+	}
+}
+
+func newEvent(stage int, kind EventKind) PipelineEvent {
+	return PipelineEvent{Stage: stage, Kind: kind, Goroutines: runtime.NumGoroutine()}
+}
+
+// stageTimer запоминает, когда стадия брала значения, чтобы считать задержку от приёма до выдачи.
+// Значения сопоставляются по порядку: у стадий "одно на входе - одно на выходе" средняя задержка
+// точная, даже если стадия обрабатывает несколько значений сразу и отдаёт их не по порядку.
+// Лишние значения на выходе (письма пользователя из GetMessages) отсчитываются от последнего взятого,
+// а у первой стадии, которая ничего не берёт, - от её предыдущего значения.
+// С буфером очереди значение считается взятым, когда попало в очередь
+type stageTimer struct {
+	mu    sync.Mutex
+	taken []time.Time
+	last  time.Time
+	// fed - стадия брала значения
+	fed bool
+}
+
+func newStageTimer(start time.Time) *stageTimer {
+	return &stageTimer{last: start}
+}
+
+func (timer *stageTimer) take(now time.Time) {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+
+	timer.taken = append(timer.taken, now)
+	timer.last = now
+	timer.fed = true
+}
+
+// produce - задержка значения, которое стадия отдала в now
+func (timer *stageTimer) produce(now time.Time) time.Duration {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+
+	from := timer.last
+	switch {
+	case len(timer.taken) > 0:
+		from = timer.taken[0]
+		timer.taken = timer.taken[1:]
+	case !timer.fed:
+		timer.last = now
+	}
+
+	return now.Sub(from)
+}
+
+// relay перекладывает значения из выхода стадии stage-1 (её таймер producer) в очередь стадии stage (consumer).
+// queue == nil - in это выход последней стадии: его никто не читает, значения только считаются
+func relay(observer Observer, stage int, in <-chan interface{}, queue chan<- interface{}, producer, consumer *stageTimer) {
+	if queue != nil {
+		defer close(queue)
+	}
+
+	for value := range in {
+		now := time.Now()
+
+		event := newEvent(stage-1, EventItemOut)
+		event.Elapsed = producer.produce(now)
+		observer.Observe(event)
+
+		if queue == nil {
+			continue
+		}
+		queue <- value
+		taken := time.Now()
+		consumer.take(taken)
+
+		event = newEvent(stage, EventItemIn)
+		event.Elapsed = taken.Sub(now)
+		event.QueueDepth = len(queue)
+		observer.Observe(event)
+	}
+}
+
+func stageName(names []string, stage int) string {
+	if stage < len(names) && names[stage] != "" {
+		return names[stage]
+	}
+
+	return strconv.Itoa(stage)
+}
+
+// StageSummary - итог одной стадии
+type StageSummary struct {
+	Name     string
+	In       int
+	Out      int
+	Err      error
+	Duration time.Duration
+	// Latency - средняя задержка от приёма значения до выдачи, см. stageTimer
+	Latency time.Duration
+	// QueueWait - сколько всего предыдущая стадия ждала места в очереди этой
+	QueueWait     time.Duration
+	MaxQueueDepth int
+	// AvgQueueDepth - средняя глубина очереди, когда в неё попадало значение
+	AvgQueueDepth float64
+
+	queueDepthSum int
+	latencySum    time.Duration
+}
+
+// Summary собирает события в текстовый отчёт по стадиям
+type Summary struct {
+	mu             sync.Mutex
+	names          []string
+	stages         []StageSummary
+	peakGoroutines int
+}
+
+// NewSummary - names - имена стадий по порядку для отчёта, без имени стадия называется номером
+func NewSummary(names ...string) *Summary {
+	return &Summary{names: names}
+}
+
+func (summary *Summary) Observe(event PipelineEvent) {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	for len(summary.stages) <= event.Stage {
+		summary.stages = append(summary.stages, StageSummary{Name: stageName(summary.names, len(summary.stages))})
+	}
+	stage := &summary.stages[event.Stage]
+
+	switch event.Kind {
+	case EventItemIn:
+		stage.In++
+		stage.QueueWait += event.Elapsed
+		stage.queueDepthSum += event.QueueDepth
+		stage.AvgQueueDepth = float64(stage.queueDepthSum) / float64(stage.In)
+		if event.QueueDepth > stage.MaxQueueDepth {
+			stage.MaxQueueDepth = event.QueueDepth
+		}
+	case EventItemOut:
+		stage.Out++
+		stage.latencySum += event.Elapsed
+		stage.Latency = stage.latencySum / time.Duration(stage.Out)
+	case EventStageDone:
+		stage.Duration = event.Elapsed
+		stage.Err = event.Err
+	}
+
+	if event.Goroutines > summary.peakGoroutines {
+		summary.peakGoroutines = event.Goroutines
+	}
+}
+
+// Stages - копия итогов по стадиям
+func (summary *Summary) Stages() []StageSummary {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	return append([]StageSummary(nil), summary.stages...)
+}
+
+// Bottleneck - номер стадии, которую дольше всех ждала предыдущая, -1 - никто никого не ждал.
+// Работает и без буфера очереди, когда глубина всегда 0
+func (summary *Summary) Bottleneck() int {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	bottleneck := -1
+	var maxWait time.Duration
+	for i, stage := range summary.stages {
+		if stage.QueueWait > maxWait {
+			bottleneck, maxWait = i, stage.QueueWait
+		}
+	}
+
+	return bottleneck
+}
+
+// Report пишет таблицу по стадиям и помечает узкое место
+func (summary *Summary) Report(w io.Writer) error {
+	bottleneck := summary.Bottleneck()
+
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "стадия\tвход\tвыход\tвремя\tзадержка\tждали очередь\tочередь ср/макс\tошибка\t")
+
+	for i, stage := range summary.stages {
+		errText := "-"
+		if stage.Err != nil {
+			errText = stage.Err.Error()
+		}
+
+		mark := ""
+		if i == bottleneck {
+			mark = "узкое место"
+		}
+
+		fmt.Fprintf(table, "%s\t%d\t%d\t%s\t%s\t%s\t%.1f/%d\t%s\t%s\n",
+			stage.Name, stage.In, stage.Out,
+			stage.Duration.Round(time.Millisecond), stage.Latency.Round(time.Millisecond),
+			stage.QueueWait.Round(time.Millisecond), stage.AvgQueueDepth, stage.MaxQueueDepth,
+			errText, mark)
+	}
+
+	fmt.Fprintf(table, "горутин максимум: %d\n", summary.peakGoroutines)
+
+	return table.Flush()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// источник, медленная стадия и сборщик: узким местом должна оказаться медленная
+func slowPipeline(received *int) []cmd {
+	return []cmd{
+		func(_, out chan interface{}) {
+			for i := 0; i < 20; i++ {
+				out <- i
+			}
+		},
+		func(in, out chan interface{}) {
+			for value := range in {
+				time.Sleep(time.Millisecond)
+				out <- value.(int) * 2
+			}
+		},
+		func(in, _ chan interface{}) {
+			for range in {
+				*received++
+			}
+		},
+	}
+}
+
+func TestSummary(t *testing.T) {
+	defer func(size int) { ObservedQueueSize = size }(ObservedQueueSize)
+
+	// без буфера узкое место видно по ожиданию, с буфером - ещё и по глубине очереди
+	for _, size := range []int{0, 4} {
+		ObservedQueueSize = size
+		t.Run(fmt.Sprint("очередь ", size), testSummary)
+	}
+}
+
+func testSummary(t *testing.T) {
+	summary := NewSummary("источник", "удвоение")
+	received := 0
+	RunPipelineWithObserver(summary, slowPipeline(&received)...)
+
+	assert.Equal(t, 20, received)
+
+	stages := summary.Stages()
+	require.Len(t, stages, 3)
+	assert.Equal(t, "источник", stages[0].Name)
+	assert.Equal(t, "2", stages[2].Name)
+
+	assert.Equal(t, 0, stages[0].In)
+	assert.Equal(t, 20, stages[0].Out)
+	assert.Equal(t, 20, stages[1].In)
+	assert.Equal(t, 20, stages[1].Out)
+	assert.Equal(t, 20, stages[2].In)
+	assert.Equal(t, 0, stages[2].Out)
+
+	assert.Equal(t, 1, summary.Bottleneck())
+	assert.Equal(t, ObservedQueueSize, stages[1].MaxQueueDepth)
+	assert.GreaterOrEqual(t, stages[1].Duration, 20*time.Millisecond)
+	// задержка - от приёма значения до выдачи, а не между значениями на выходе
+	assert.GreaterOrEqual(t, stages[1].Latency, time.Millisecond)
+	assert.Less(t, stages[2].QueueWait, stages[1].QueueWait)
+
+	report := &strings.Builder{}
+	require.NoError(t, summary.Report(report))
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.True(t, strings.HasPrefix(lines[0], "стадия"))
+	assert.True(t, strings.HasPrefix(lines[2], "удвоение"))
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(lines[2]), "узкое место"))
+	assert.True(t, strings.HasPrefix(lines[4], "горутин максимум: "))
+}
+
+func TestSummaryError(t *testing.T) {
+	summary := NewSummary()
+	errStage := errors.New("сломалась")
+
+	err := RunPipelineObserved(context.Background(), summary,
+		withContext(func(_, out chan interface{}) { out <- 1 }),
+		func(context.Context, chan interface{}, chan interface{}) error { return errStage },
+	)
+
+	require.ErrorIs(t, err, errStage)
+	assert.Equal(t, errStage, summary.Stages()[1].Err)
+}
+
+func TestPrometheusObserver(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	observer, err := NewPrometheusObserver(registry, "test", "источник", "удвоение", "сборщик")
+	require.NoError(t, err)
+
+	summary := NewSummary()
+	received := 0
+	RunPipelineWithObserver(MultiObserver{observer, summary}, slowPipeline(&received)...)
+
+	assert.Equal(t, 20.0, testutil.ToFloat64(observer.itemsIn.WithLabelValues("test", "удвоение")))
+	assert.Equal(t, 20.0, testutil.ToFloat64(observer.itemsOut.WithLabelValues("test", "удвоение")))
+	assert.Equal(t, 20.0, testutil.ToFloat64(observer.itemsIn.WithLabelValues("test", "сборщик")))
+	assert.Equal(t, 0, testutil.CollectAndCount(observer.errors))
+	assert.Equal(t, 3, testutil.CollectAndCount(observer.duration))
+	assert.Len(t, summary.Stages(), 3)
+
+	// второй конвейер на том же registry пишет в те же метрики со своей меткой
+	other, err := NewPrometheusObserver(registry, "other", "источник", "удвоение", "сборщик")
+	require.NoError(t, err)
+	assert.Same(t, observer.itemsIn, other.itemsIn)
+	RunPipelineWithObserver(other, slowPipeline(&received)...)
+	assert.Equal(t, 20.0, testutil.ToFloat64(observer.itemsIn.WithLabelValues("other", "удвоение")))
+	assert.Equal(t, 20.0, testutil.ToFloat64(observer.itemsIn.WithLabelValues("test", "удвоение")))
+
+	// метрика с тем же именем, но другим описанием - ошибка
+	conflicting := prometheus.NewRegistry()
+	require.NoError(t, conflicting.Register(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spammer_stage_errors_total",
+		Help: "Something else",
+	})))
+	_, err = NewPrometheusObserver(conflicting, "test")
+	assert.Error(t, err)
+}
+
+func TestServeMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	observer, err := NewPrometheusObserver(registry, "test", "источник", "удвоение", "сборщик")
+	require.NoError(t, err)
+
+	addr, stop, err := serveMetrics("127.0.0.1:0", registry)
+	require.NoError(t, err)
+	defer stop() //nolint:errcheck
+
+	received := 0
+	RunPipelineWithObserver(observer, slowPipeline(&received)...)
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `spammer_stage_items_in_total{pipeline="test",stage="удвоение"} 20`)
+	assert.Contains(t, string(body), "spammer_stage_latency_seconds_bucket")
+}
+
+// выход последней стадии никто не читает, но под наблюдением он считается
+func TestSummaryLastStageOutput(t *testing.T) {
+	summary := NewSummary()
+	received := 0
+	RunPipelineWithObserver(summary, slowPipeline(&received)[:2]...)
+
+	stages := summary.Stages()
+	require.Len(t, stages, 2)
+	assert.Equal(t, 20, stages[1].In)
+	assert.Equal(t, 20, stages[1].Out)
+}
+
+func TestStageTimer(t *testing.T) {
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// стадия взяла два значения сразу и отдала их через 10 мс: задержка обоих 10 мс, а не 10 и 0
+	timer := newStageTimer(start)
+	timer.take(at(0))
+	timer.take(at(0))
+	assert.Equal(t, 10*time.Millisecond, timer.produce(at(10)))
+	assert.Equal(t, 10*time.Millisecond, timer.produce(at(10)))
+	// лишнее значение отсчитывается от последнего взятого
+	assert.Equal(t, 15*time.Millisecond, timer.produce(at(15)))
+
+	// первая стадия ничего не берёт - от её предыдущего значения
+	source := newStageTimer(start)
+	assert.Equal(t, 5*time.Millisecond, source.produce(at(5)))
+	assert.Equal(t, 3*time.Millisecond, source.produce(at(8)))
+}
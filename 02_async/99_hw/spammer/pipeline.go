@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var errUnexpectedType = errors.New("в канал пришли данные неожиданного типа")
+
+// ctxCmd - стадия конвейера, которая следит за контекстом
+// и может остановить весь конвейер, вернув ошибку
+type ctxCmd func(ctx context.Context, in, out chan interface{}) error
+
+// RunPipelineContext запускает стадии конвейера как RunPipeline,
+// но первая ошибка любой стадии отменяет контекст остальных.
+// Возвращает ошибки стадий (первая - та, из-за которой конвейер остановился)
+// или ошибку родительского контекста, если конвейер отменили снаружи
+func RunPipelineContext(ctx context.Context, cmds ...ctxCmd) error {
+	return RunPipelineObserved(ctx, nil, cmds...)
+}
+
+// RunPipelineObserved - RunPipelineContext, который сообщает observer'у о каждой стадии,
+// см. Observer. nil observer - конвейер без наблюдения
+func RunPipelineObserved(ctx context.Context, observer Observer, cmds ...ctxCmd) error {
+	pipelineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mu := &sync.Mutex{}
+	var errs []error
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(cmds))
+
+	timers := make([]*stageTimer, len(cmds))
+	for i := range timers {
+		timers[i] = newStageTimer(time.Now())
+	}
+
+	var in chan interface{}
+	for i, command := range cmds {
+		out := make(chan interface{})
+
+		// между стадиями под наблюдением стоит очередь, в которой считаются значения
+		if observer != nil && in != nil {
+			queue := make(chan interface{}, ObservedQueueSize)
+
+			wg.Add(1)
+			go func(stage int, in, queue chan interface{}) {
+				defer wg.Done()
+				relay(observer, stage, in, queue, timers[stage-1], timers[stage])
+			}(i, in, queue)
+
+			in = queue
+		}
+
+		go func(stage int, in, out chan interface{}, c ctxCmd) {
+			defer wg.Done()
+
+			start := time.Now()
+			if observer != nil {
+				observer.Observe(newEvent(stage, EventStageStart))
+			}
+
+			err := c(pipelineCtx, in, out)
+			close(out)
+
+			if observer != nil {
+				event := newEvent(stage, EventStageDone)
+				event.Elapsed = time.Since(start)
+				event.Err = err
+				observer.Observe(event)
+			}
+
+			// ошибки отмены - следствие чужой ошибки или отмены снаружи, их не возвращаем
+			if err != nil && (pipelineCtx.Err() == nil || !errors.Is(err, pipelineCtx.Err())) {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("стадия %d: %w", stage, err))
+				mu.Unlock()
+
+				cancel()
+			}
+
+			// стадия могла выйти, не дочитав вход - дочитываем,
+			// чтобы предыдущие стадии не зависли на записи в канал
+			if in != nil {
+				for range in {
+				}
+			}
+		}(i, in, out, command)
+
+		in = out
+	}
+
+	if observer != nil && in != nil {
+		wg.Add(1)
+		go func(stage int, in chan interface{}) {
+			defer wg.Done()
+			relay(observer, stage, in, nil, timers[stage-1], nil)
+		}(len(cmds), in)
+	}
+
+	wg.Wait()
+
+	switch len(errs) {
+	case 0:
+		return ctx.Err()
+	case 1:
+		return errs[0]
+	}
+
+	return errors.Join(errs...)
+}
+
+// withContext превращает обычную стадию в стадию с контекстом.
+// Такая стадия не узнает об отмене, но конвейер дочитает её выход и не зависнет
+func withContext(c cmd) ctxCmd {
+	return func(_ context.Context, in, out chan interface{}) error {
+		c(in, out)
+		return nil
+	}
+}
+
+// receive читает из in, пока канал не закрыт и конвейер не остановлен
+func receive[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case value, ok := <-in:
+		return value, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+// send отправляет значение дальше, если конвейер ещё не остановлен
+func send[T any](ctx context.Context, out chan<- T, value T) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// group запускает горутины одной стадии: первая ошибка отменяет контекст остальных
+type group struct {
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	once   sync.Once
+	err    error
+}
+
+func newGroup(ctx context.Context) (*group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &group{cancel: cancel}, ctx
+}
+
+func (g *group) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.Fail(err)
+		}
+	}()
+}
+
+func (g *group) Fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
+
+// Wait дожидается всех горутин и возвращает первую ошибку
+func (g *group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestStage = errors.New("стадия сломалась")
+
+// бесконечный источник: остановить его может только отмена контекста
+func countForever(ctx context.Context, _, out chan interface{}) error {
+	for i := 0; ; i++ {
+		if err := send[interface{}](ctx, out, i); err != nil {
+			return err
+		}
+	}
+}
+
+func discard(_ context.Context, in, _ chan interface{}) error {
+	for range in {
+	}
+	return nil
+}
+
+// все горутины конвейера должны завершиться
+func assertNoLeaks(t *testing.T, before int) {
+	t.Helper()
+	// assert.Eventually сам запускает горутины, поэтому проверяем в цикле
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if runtime.NumGoroutine() <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("после конвейера остались горутины: было %d, стало %d", before, runtime.NumGoroutine())
+}
+
+func TestPipelineContextError(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var received uint32
+	err := RunPipelineContext(context.Background(),
+		countForever,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				if atomic.AddUint32(&received, 1) == 3 {
+					return errTestStage
+				}
+				if err := send(ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		discard,
+	)
+
+	require.ErrorIs(t, err, errTestStage)
+	assert.Equal(t, "стадия 1: стадия сломалась", err.Error())
+	assertNoLeaks(t, before)
+}
+
+func TestPipelineContextJoinErrors(t *testing.T) {
+	errSecond := errors.New("вторая ошибка")
+
+	err := RunPipelineContext(context.Background(),
+		func(context.Context, chan interface{}, chan interface{}) error { return errTestStage },
+		func(context.Context, chan interface{}, chan interface{}) error { return errSecond },
+	)
+
+	assert.ErrorIs(t, err, errTestStage)
+	assert.ErrorIs(t, err, errSecond)
+}
+
+func TestPipelineContextCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := RunPipelineContext(ctx, countForever, discard)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assertNoLeaks(t, before)
+}
+
+// обычная стадия читает только одно значение, конвейер всё равно должен завершиться
+func TestPipelineContextPlainStages(t *testing.T) {
+	var received uint32
+
+	err := RunPipelineContext(context.Background(),
+		withContext(func(_, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- i
+			}
+		}),
+		withContext(func(in, _ chan interface{}) {
+			<-in
+			atomic.AddUint32(&received, 1)
+		}),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&received))
+}
+
+func TestSpamPipeline(t *testing.T) {
+	stat = Stat{}
+
+	in := make(chan string)
+	out := make(chan string)
+	go func() {
+		defer close(in)
+		for _, email := range []string{"harry.dubois@mail.ru", "k.kitsuragi@mail.ru", "batman@mail.ru", "bruce.wayne@mail.ru"} {
+			in <- email
+		}
+	}()
+
+	var results []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for line := range out {
+			results = append(results, line)
+		}
+	}()
+
+	err := RunStage(context.Background(), SpamPipeline, in, out)
+	<-done
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"true 8065084208075053255",
+		"true 9323185346293974544",
+		"true 12026159364158506481",
+		"true 12386730660396758454",
+		"true 12556782602004681106",
+		"true 12728377754914798838",
+		"true 14107154567229229487",
+		"true 17087986564527251681",
+		"false 59892029605752939",
+		"false 10523043777071802347",
+		"false 12792092352287413255",
+		"false 12975933273041759035",
+		"false 14498495926778052146",
+		"false 15262116397886015961",
+		"false 15728889559763622673",
+	}, results)
+}
+
+func TestThen(t *testing.T) {
+	double := Stage[int, int](func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for value := range in {
+			if err := send(ctx, out, value*2); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	format := Stage[int, string](func(ctx context.Context, in <-chan int, out chan<- string) error {
+		for value := range in {
+			if value > 10 {
+				return errTestStage
+			}
+			if err := send(ctx, out, strconv.Itoa(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	run := func(values ...int) ([]string, error) {
+		in := make(chan int, len(values))
+		for _, value := range values {
+			in <- value
+		}
+		close(in)
+
+		out := make(chan string, len(values))
+		err := RunStage(context.Background(), Then(Then(double, double), format), in, out)
+
+		var results []string
+		for line := range out {
+			results = append(results, line)
+		}
+		return results, err
+	}
+
+	results, err := run(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"4", "8"}, results)
+
+	_, err = run(1, 2, 3)
+	assert.ErrorIs(t, err, errTestStage)
+}
+
+func TestUntypedWrongType(t *testing.T) {
+	err := RunPipelineContext(context.Background(),
+		withContext(func(_, out chan interface{}) {
+			out <- "harry.dubois@mail.ru"
+		}),
+		Untyped(SelectMessagesStage),
+		discard,
+	)
+
+	require.ErrorIs(t, err, errUnexpectedType)
+	assert.Equal(t, "стадия 1: в канал пришли данные неожиданного типа: string вместо main.User", err.Error())
+}
+
+// ошибка антиспама останавливает конвейер, неполный результат не отдаётся
+func TestSpamStagesContextError(t *testing.T) {
+	stat = Stat{}
+
+	defer func(start func() bool) { antispamRequestStart = start }(antispamRequestStart)
+	antispamRequestStart = func() bool { return false }
+
+	var results []string
+	err := RunPipelineContext(context.Background(),
+		withContext(func(_, out chan interface{}) {
+			out <- "harry.dubois@mail.ru"
+		}),
+		Untyped(SelectUsersStage),
+		Untyped(SelectMessagesStage),
+		Untyped(CheckSpamStage),
+		Untyped(CombineResultsStage),
+		withContext(func(in, _ chan interface{}) {
+			for line := range in {
+				results = append(results, line.(string))
+			}
+		}),
+	)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "стадия 3: HasSpam(")
+	assert.Empty(t, results)
+}
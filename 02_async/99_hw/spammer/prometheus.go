@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PrometheusObserver выгружает события стадий в метрики Prometheus.
+// Метрики помечены именем конвейера и стадии, так что один экспортёр подходит для нескольких конвейеров:
+// наблюдатели, созданные на одном registerer, пишут в общие метрики, а не регистрируют их заново
+type PrometheusObserver struct {
+	pipeline string
+	names    []string
+
+	itemsIn    *prometheus.CounterVec
+	itemsOut   *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	queueWait  *prometheus.HistogramVec
+	queueDepth *prometheus.GaugeVec
+	duration   *prometheus.GaugeVec
+	goroutines prometheus.Gauge
+}
+
+// NewPrometheusObserver регистрирует метрики в registerer, если их там ещё нет; names - имена стадий, как в NewSummary.
+// Ошибка - в registerer уже есть другие метрики с теми же именами
+func NewPrometheusObserver(registerer prometheus.Registerer, pipeline string, names ...string) (*PrometheusObserver, error) {
+	labels := []string{"pipeline", "stage"}
+
+	observer := &PrometheusObserver{
+		pipeline: pipeline,
+		names:    names,
+
+		itemsIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spammer_stage_items_in_total",
+			Help: "Values put into the stage queue",
+		}, labels),
+		itemsOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spammer_stage_items_out_total",
+			Help: "Values produced by the stage",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spammer_stage_errors_total",
+			Help: "Stage runs finished with an error",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "spammer_stage_latency_seconds",
+			Help: "Time from the stage taking a value to producing the result",
+		}, labels),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "spammer_stage_queue_wait_seconds",
+			Help: "Time the previous stage waited for room in the stage queue",
+		}, labels),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "spammer_stage_queue_depth",
+			Help: "Values waiting in the stage queue when the last value was put into it",
+		}, labels),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "spammer_stage_duration_seconds",
+			Help: "Duration of the last stage run",
+		}, labels),
+		goroutines: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "spammer_pipeline_goroutines",
+			Help:        "Goroutines in the process at the last pipeline event",
+			ConstLabels: prometheus.Labels{"pipeline": pipeline},
+		}),
+	}
+
+	var errs []error
+	observer.itemsIn = registerOnce(registerer, observer.itemsIn, &errs)
+	observer.itemsOut = registerOnce(registerer, observer.itemsOut, &errs)
+	observer.errors = registerOnce(registerer, observer.errors, &errs)
+	observer.latency = registerOnce(registerer, observer.latency, &errs)
+	observer.queueWait = registerOnce(registerer, observer.queueWait, &errs)
+	observer.queueDepth = registerOnce(registerer, observer.queueDepth, &errs)
+	observer.duration = registerOnce(registerer, observer.duration, &errs)
+	observer.goroutines = registerOnce(registerer, observer.goroutines, &errs)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return observer, nil
+}
+
+// registerOnce регистрирует collector, а если такой уже зарегистрирован - возвращает существующий
+func registerOnce[C prometheus.Collector](registerer prometheus.Registerer, collector C, errs *[]error) C {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector
+	}
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+
+	*errs = append(*errs, err)
+	return collector
+}
+
+func (observer *PrometheusObserver) Observe(event PipelineEvent) {
+	stage := stageName(observer.names, event.Stage)
+
+	switch event.Kind {
+	case EventItemIn:
+		observer.itemsIn.WithLabelValues(observer.pipeline, stage).Inc()
+		observer.queueWait.WithLabelValues(observer.pipeline, stage).Observe(event.Elapsed.Seconds())
+		observer.queueDepth.WithLabelValues(observer.pipeline, stage).Set(float64(event.QueueDepth))
+	case EventItemOut:
+		observer.itemsOut.WithLabelValues(observer.pipeline, stage).Inc()
+		observer.latency.WithLabelValues(observer.pipeline, stage).Observe(event.Elapsed.Seconds())
+	case EventStageDone:
+		observer.duration.WithLabelValues(observer.pipeline, stage).Set(event.Elapsed.Seconds())
+		if event.Err != nil {
+			observer.errors.WithLabelValues(observer.pipeline, stage).Inc()
+		}
+	}
+
+	observer.goroutines.Set(float64(event.Goroutines))
+}
+
+// serveMetrics отдаёт метрики gatherer'а на /metrics по адресу addr, пока не вызвана stop.
+// Возвращает адрес, который слушает сервер: с портом 0 его выбирает система
+func serveMetrics(addr string, gatherer prometheus.Gatherer) (net.Addr, func() error, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+
+	go server.Serve(listener) //nolint:errcheck // после Shutdown всегда ErrServerClosed
+
+	stop := func() error {
+		return server.Shutdown(context.Background())
+	}
+	return listener.Addr(), stop, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errCircuitOpen = errors.New("предохранитель разомкнут, вызов не выполнялся")
+
+// isRetryable - ошибки, которые могут пройти при повторе и говорят о беде с бэкендом: антибрут антиспама,
+// ответ 5xx, недоступный сервис, сетевые ошибки и таймаут самого вызова. Истёкший контекст вызывающего сюда тоже
+// попадает, но Guard его отличает: повторять и считать отказом его не будет.
+// Слишком большой батч, отмена или разомкнутый предохранитель повтор не исправит
+func isRetryable(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, errTooManyRequests), errors.Is(err, errBackendUnavailable), errors.Is(err, errBackendStatus),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return errors.As(err, &netErr)
+}
+
+// RetryPolicy повторяет вызов с экспоненциально растущей задержкой и случайным разбросом.
+// Нулевое значение - один вызов без повторов
+type RetryPolicy struct {
+	// MaxAttempts - сколько всего попыток, включая первую
+	MaxAttempts int
+	// BaseDelay - задержка перед первым повтором, дальше она удваивается
+	BaseDelay time.Duration
+	// MaxDelay ограничивает задержку, 0 - без ограничения
+	MaxDelay time.Duration
+	// Jitter - доля задержки, на которую её можно случайно уменьшить:
+	// при 0.5 задержка будет от половины до полной, чтобы повторы не шли одновременно
+	Jitter float64
+	// Retryable решает, стоит ли повторять ошибку, nil - isRetryable
+	Retryable func(error) bool
+
+	// random возвращает число в [0, 1), в тестах подменяется
+	random func() float64
+}
+
+// Do вызывает call, пока он не выполнится, не вернёт неповторяемую ошибку
+// или не кончатся попытки. Между попытками ждёт, пока не отменён ctx
+func (policy RetryPolicy) Do(ctx context.Context, call func() error) error {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = isRetryable
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			atomic.AddUint32(&stat.Retries, 1)
+
+			timer := time.NewTimer(policy.delay(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+
+		err = call()
+		if err == nil || !retryable(err) {
+			return err
+		}
+	}
+
+	if attempts == 1 {
+		return err
+	}
+
+	atomic.AddUint32(&stat.RetriesExhausted, 1)
+	return fmt.Errorf("не удалось за %d попыток: %w", attempts, err)
+}
+
+// delay - задержка перед повтором с номером retry, начиная с 1
+func (policy RetryPolicy) delay(retry int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < retry; i++ {
+		if policy.MaxDelay > 0 && delay >= policy.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if policy.Jitter > 0 {
+		random := policy.random
+		if random == nil {
+			random = rand.Float64 //nolint: gosec
+		}
+		delay -= time.Duration(float64(delay) * policy.Jitter * random())
+	}
+
+	return delay
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker размыкается после FailureThreshold отказов бэкенда подряд и OpenTimeout не пускает вызовы.
+// Потом пропускает один пробный вызов: успех замыкает цепь, отказ снова размыкает.
+// Отказ - ошибка, которую стоит повторять (isRetryable). Прочие - отмена вызова, слишком большой батч -
+// о здоровье бэкенда ничего не говорят и не считаются
+type CircuitBreaker struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	// IsFailure решает, считать ли ошибку отказом бэкенда, nil - isRetryable
+	IsFailure func(error) bool
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+
+	// now - текущее время, в тестах подменяется
+	now func() time.Time
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
+
+// Do выполняет call, если цепь замкнута, иначе сразу возвращает errCircuitOpen
+func (breaker *CircuitBreaker) Do(call func() error) error {
+	return breaker.DoContext(context.Background(), call)
+}
+
+// DoContext - Do для вызова под ctx: ошибка при отменённом или истёкшем ctx - решение вызывающего,
+// а не отказ бэкенда, и не считается
+func (breaker *CircuitBreaker) DoContext(ctx context.Context, call func() error) error {
+	if !breaker.allow() {
+		atomic.AddUint32(&stat.CircuitRejected, 1)
+		return errCircuitOpen
+	}
+
+	err := call()
+	breaker.record(err, err != nil && ctx.Err() == nil && breaker.isFailure(err))
+
+	return err
+}
+
+func (breaker *CircuitBreaker) isFailure(err error) bool {
+	if breaker.IsFailure == nil {
+		return isRetryable(err)
+	}
+	return breaker.IsFailure(err)
+}
+
+func (breaker *CircuitBreaker) allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case circuitOpen:
+		if breaker.now().Sub(breaker.openedAt) < breaker.OpenTimeout {
+			return false
+		}
+		breaker.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// пробный вызов уже идёт
+		return false
+	}
+
+	return true
+}
+
+func (breaker *CircuitBreaker) record(err error, failure bool) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch {
+	case err == nil:
+		breaker.state = circuitClosed
+		breaker.failures = 0
+		return
+	case !failure:
+		// пробный вызов ничего не показал - следующий вызов снова будет пробным
+		if breaker.state == circuitHalfOpen {
+			breaker.state = circuitOpen
+		}
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == circuitHalfOpen || breaker.failures >= breaker.FailureThreshold {
+		breaker.state = circuitOpen
+		breaker.openedAt = breaker.now()
+		breaker.failures = 0
+		atomic.AddUint32(&stat.CircuitOpened, 1)
+	}
+}
+
+// Limiter ограничивает частоту вызовов, например limiter.TokenBucket
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Guard - повторы, предохранитель и ограничение частоты вокруг одного вызова бэкенда, всё необязательно.
+// Каждая попытка идёт через предохранитель, так что разомкнутая цепь не тратит время на вызовы,
+// и ждёт Limiter, так что повторы тоже укладываются в лимит
+type Guard struct {
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+	Limiter Limiter
+}
+
+func (guard Guard) Do(ctx context.Context, call func() error) error {
+	return guard.Retry.Do(ctx, func() error {
+		if guard.Limiter != nil {
+			if err := guard.Limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		if guard.Breaker == nil {
+			return call()
+		}
+		return guard.Breaker.DoContext(ctx, call)
+	})
+}
+
+// Resilience - защита вызовов бэкенда, которые делают стадии конвейера
+type Resilience struct {
+	GetUser     Guard
+	GetMessages Guard
+	HasSpam     Guard
+}
+
+// wrap защищает вызовы backend повторами, предохранителями и ограничителями
+func (resilience Resilience) wrap(backend Backend) backendCalls {
+	return backendCalls{
+		getUser: func(ctx context.Context, email string) (user User, err error) {
+			err = resilience.GetUser.Do(ctx, func() error {
+				user, err = backend.Users.GetUser(ctx, email)
+				return err
+			})
+			return user, err
+		},
+		getMessages: func(ctx context.Context, users []User) (msgIDs []MsgID, err error) {
+			err = resilience.GetMessages.Do(ctx, func() error {
+				msgIDs, err = backend.Messages.GetMessages(ctx, users...)
+				return err
+			})
+			return msgIDs, err
+		},
+		hasSpam: func(ctx context.Context, id MsgID) (isSpam bool, err error) {
+			err = resilience.HasSpam.Do(ctx, func() error {
+				isSpam, err = backend.Antispam.HasSpam(ctx, id)
+				return err
+			})
+			return isSpam, err
+		},
+	}
+}
+
+// Pipeline - весь конвейер поверх симуляции, вызовы бэкенда в котором защищены повторами и предохранителями
+func (resilience Resilience) Pipeline() Stage[string, string] {
+	return SimulatedBackend().Pipeline(resilience, CombineResultsStage)
+}
+
+// PipelineWith - такой же конвейер, но результаты собирает combine, например Combiner.Run
+func (resilience Resilience) PipelineWith(combine Stage[MsgData, string]) Stage[string, string] {
+	return SimulatedBackend().Pipeline(resilience, combine)
+}
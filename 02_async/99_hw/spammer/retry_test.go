@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay: 10 * time.Millisecond,
+		MaxDelay:  50 * time.Millisecond,
+	}
+
+	assert.Equal(t, 10*time.Millisecond, policy.delay(1))
+	assert.Equal(t, 20*time.Millisecond, policy.delay(2))
+	assert.Equal(t, 40*time.Millisecond, policy.delay(3))
+	assert.Equal(t, 50*time.Millisecond, policy.delay(4))
+	assert.Equal(t, 50*time.Millisecond, policy.delay(100))
+
+	policy.Jitter = 0.5
+	policy.random = func() float64 { return 0.5 }
+	assert.Equal(t, 15*time.Millisecond, policy.delay(2))
+}
+
+func TestRetryDo(t *testing.T) {
+	stat = Stat{}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errTooManyRequests
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// неповторяемая ошибка возвращается сразу
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return errTooManyUsers
+	})
+	assert.Equal(t, errTooManyUsers, err)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return errTooManyRequests
+	})
+	assert.ErrorIs(t, err, errTooManyRequests)
+	assert.Equal(t, "не удалось за 3 попыток: too many requests", err.Error())
+	assert.Equal(t, 3, calls)
+
+	assert.Equal(t, Stat{Retries: 4, RetriesExhausted: 1}, stat)
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		errTooManyRequests:                                   true,
+		fmt.Errorf("%w: 500", errBackendStatus):              true,
+		fmt.Errorf("%w: 503", errBackendUnavailable):         true,
+		context.DeadlineExceeded:                             true,
+		&net.OpError{Op: "dial", Err: errors.New("refused")}: true,
+		errTooManyUsers:                                      false,
+		errCircuitOpen:                                       false,
+		context.Canceled:                                     false,
+		&net.OpError{Op: "read", Err: context.Canceled}:      false,
+	}
+	for err, expected := range cases {
+		assert.Equal(t, expected, isRetryable(err), err.Error())
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	err := policy.Do(ctx, func() error {
+		cancel()
+		return errTooManyRequests
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	stat = Stat{}
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	errBackend := fmt.Errorf("бэкенд недоступен: %w", errTooManyRequests)
+	fail := func() error { return errBackend }
+	calls := 0
+	succeed := func() error {
+		calls++
+		return nil
+	}
+
+	assert.Equal(t, errBackend, breaker.Do(fail))
+	assert.Equal(t, errBackend, breaker.Do(fail))
+
+	// цепь разомкнута, вызовы не выполняются
+	assert.Equal(t, errCircuitOpen, breaker.Do(succeed))
+	assert.Equal(t, 0, calls)
+
+	// после таймаута пробный вызов неудачен - цепь снова разомкнута
+	now = now.Add(time.Minute)
+	assert.Equal(t, errBackend, breaker.Do(fail))
+	assert.Equal(t, errCircuitOpen, breaker.Do(succeed))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.Do(succeed))
+	assert.NoError(t, breaker.Do(succeed))
+	assert.Equal(t, 2, calls)
+
+	assert.Equal(t, Stat{CircuitOpened: 2, CircuitRejected: 2}, stat)
+}
+
+// отмена вызова и неповторяемые ошибки не размыкают цепь
+func TestCircuitBreakerFailures(t *testing.T) {
+	stat = Stat{}
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	for _, err := range []error{context.Canceled, errTooManyUsers, errors.New("другая ошибка")} {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, err, breaker.Do(func() error { return err }))
+		}
+	}
+	assert.Equal(t, Stat{}, stat)
+
+	// отмена между отказами не сбрасывает счёт
+	assert.Equal(t, errTooManyRequests, breaker.Do(func() error { return errTooManyRequests }))
+	assert.Equal(t, context.Canceled, breaker.Do(func() error { return context.Canceled }))
+	assert.Equal(t, errTooManyRequests, breaker.Do(func() error { return errTooManyRequests }))
+	assert.Equal(t, errCircuitOpen, breaker.Do(func() error { return nil }))
+
+	// отменённый пробный вызов не оставляет цепь полуоткрытой навсегда
+	now = now.Add(time.Minute)
+	assert.Equal(t, context.Canceled, breaker.Do(func() error { return context.Canceled }))
+	assert.NoError(t, breaker.Do(func() error { return nil }))
+
+	// таймаут вызова, сетевая ошибка и 5xx - отказы бэкенда
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, err := range []error{context.DeadlineExceeded, timeout, fmt.Errorf("%w: 500", errBackendStatus)} {
+		breaker = NewCircuitBreaker(2, time.Minute)
+		breaker.now = func() time.Time { return now }
+		assert.Equal(t, err, breaker.Do(func() error { return err }))
+		assert.Equal(t, err, breaker.Do(func() error { return err }))
+		assert.Equal(t, errCircuitOpen, breaker.Do(func() error { return nil }), err)
+	}
+
+	// истёк контекст вызывающего, а не бэкенд
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	breaker = NewCircuitBreaker(1, time.Minute)
+	assert.Equal(t, context.DeadlineExceeded, breaker.DoContext(ctx, func() error { return context.DeadlineExceeded }))
+	assert.NoError(t, breaker.DoContext(ctx, func() error { return nil }))
+
+	// свой критерий отказа
+	breaker = NewCircuitBreaker(1, time.Minute)
+	breaker.IsFailure = func(err error) bool { return errors.Is(err, errTooManyUsers) }
+	assert.Equal(t, errTooManyRequests, breaker.Do(func() error { return errTooManyRequests }))
+	assert.Equal(t, errTooManyUsers, breaker.Do(func() error { return errTooManyUsers }))
+	assert.Equal(t, errCircuitOpen, breaker.Do(func() error { return nil }))
+
+	assert.Equal(t, Stat{CircuitOpened: 5, CircuitRejected: 5}, stat)
+}
+
+// антиспам отказывает в каждом третьем запросе, повторы спасают весь результат
+func TestResiliencePipeline(t *testing.T) {
+	stat = Stat{}
+
+	defer func(start func() bool) { antispamRequestStart = start }(antispamRequestStart)
+	var requests int32
+	antispamRequestStart = func() bool {
+		return atomic.AddInt32(&requests, 1)%3 != 0
+	}
+
+	resilience := Resilience{
+		HasSpam: Guard{
+			Retry:   RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, Jitter: 0.5},
+			Breaker: NewCircuitBreaker(100, time.Second),
+		},
+	}
+
+	in := make(chan string, 1)
+	in <- "harry.dubois@mail.ru"
+	close(in)
+
+	out := make(chan string, 100)
+	err := RunStage(context.Background(), resilience.Pipeline(), in, out)
+	require.NoError(t, err)
+
+	var results []string
+	for line := range out {
+		results = append(results, line)
+	}
+
+	assert.Len(t, results, int(stat.RunHasSpam-stat.ErrorHasSpam))
+	assert.NotZero(t, stat.Retries)
+	assert.Equal(t, stat.ErrorHasSpam, stat.Retries)
+	assert.Zero(t, stat.RetriesExhausted)
+}
+
+type countingLimiter struct {
+	waits int
+	err   error
+}
+
+func (limiter *countingLimiter) Wait(context.Context) error {
+	limiter.waits++
+	return limiter.err
+}
+
+// каждая попытка, включая повторы, ждёт ограничитель
+func TestGuardLimiter(t *testing.T) {
+	rate := &countingLimiter{}
+	guard := Guard{
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Limiter: rate,
+	}
+
+	calls := 0
+	err := guard.Do(context.Background(), func() error {
+		calls++
+		return errTooManyRequests
+	})
+	assert.ErrorIs(t, err, errTooManyRequests)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 3, rate.waits)
+
+	rate.err = context.DeadlineExceeded
+	err = guard.Do(context.Background(), func() error {
+		calls++
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 3, calls)
+}
@@ -1,32 +1,53 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"log"
 	"slices"
 	"sync"
+
+	"gitlab.vk-golang.ru/vk-golang/lectures/02_async/99_hw/spammer/limiter"
 )
 
-func RunPipeline(cmds ...cmd) {
-	var in chan interface{}
-	var out chan interface{}
+// Настройки клиента - стадий конвейера: сколько пользователей просить в GetMessages за раз
+// и сколько запросов к антиспаму делать одновременно. Ограничения самого бэкенда
+// (GetMessagesMaxUsersBatch, HasSpamMaxAsyncRequests) они не меняют: если просить больше,
+// бэкенд ответит ошибкой. 0 - ровно столько, сколько разрешает бэкенд
+var (
+	MessagesBatchSize    int
+	SpamCheckConcurrency int
+)
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(cmds))
+func messagesBatchSize() int {
+	if MessagesBatchSize > 0 {
+		return MessagesBatchSize
+	}
+	return GetMessagesMaxUsersBatch
+}
 
-	for _, command := range cmds {
-		out = make(chan interface{})
+func spamCheckConcurrency() int {
+	if SpamCheckConcurrency > 0 {
+		return SpamCheckConcurrency
+	}
+	return HasSpamMaxAsyncRequests
+}
 
-		go func(in, out chan interface{}, c cmd) {
-			defer wg.Done()
-			defer close(out)
-			c(in, out)
-		}(in, out, command)
+// RunPipeline запускает нетипизированные стадии, обёртка над RunPipelineContext
+func RunPipeline(cmds ...cmd) {
+	RunPipelineWithObserver(nil, cmds...)
+}
 
-		in = out
+// RunPipelineWithObserver - RunPipeline, который сообщает observer'у о каждой стадии
+func RunPipelineWithObserver(observer Observer, cmds ...cmd) {
+	ctxCmds := make([]ctxCmd, 0, len(cmds))
+	for _, command := range cmds {
+		ctxCmds = append(ctxCmds, withContext(command))
 	}
 
-	wg.Wait()
+	// обычные стадии не возвращают ошибок, а контекст никто не отменяет
+	RunPipelineObserved(context.Background(), observer, ctxCmds...) //nolint:errcheck
 }
 
 func SelectUsers(in, out chan interface{}) {
@@ -73,7 +94,23 @@ func SelectUsers(in, out chan interface{}) {
 func SelectMessages(in, out chan interface{}) {
 	// 	in - User
 	// 	out - MsgID
-	users := make([]User, 0, GetMessagesMaxUsersBatch)
+	users := make(chan User)
+	batches := make(chan []User)
+
+	go func() {
+		defer close(users)
+		for user := range in {
+			userFrame, ok := user.(User)
+			if !ok {
+				log.Println("В канал пришли данные, которые нельзя преобразовать к структуре пользователя")
+				continue
+			}
+			users <- userFrame
+		}
+	}()
+
+	batcher := Batcher[User]{Size: messagesBatchSize(), Linger: GetMessagesBatchLinger}
+	go RunStage(context.Background(), batcher.Run, users, batches) //nolint:errcheck
 
 	wg := &sync.WaitGroup{}
 
@@ -90,28 +127,9 @@ func SelectMessages(in, out chan interface{}) {
 		}
 	}
 
-	for user := range in {
-		userFrame, ok := user.(User)
-		if !ok {
-			log.Println("В канал пришли данные, которые нельзя преобразовать к структуре пользователя")
-			continue
-		}
-
-		users = append(users, userFrame)
-
-		if len(users) < GetMessagesMaxUsersBatch {
-			continue
-		}
-
+	for batch := range batches {
 		wg.Add(1)
-		go getMessagesWorker(users...)
-
-		users = []User{}
-	}
-
-	if len(users) > 0 {
-		wg.Add(1)
-		go getMessagesWorker(users...)
+		go getMessagesWorker(batch...)
 	}
 
 	wg.Wait()
@@ -121,7 +139,7 @@ func CheckSpam(in, out chan interface{}) {
 	// in - MsgID
 	// out - MsgData
 	wg := &sync.WaitGroup{}
-	sem := make(chan struct{}, HasSpamMaxAsyncRequests)
+	sem := limiter.NewSemaphore(spamCheckConcurrency())
 
 	for inputData := range in {
 		msgID, ok := inputData.(MsgID)
@@ -134,9 +152,11 @@ func CheckSpam(in, out chan interface{}) {
 
 		go func(msgID MsgID) {
 			defer wg.Done()
-			defer func() { <-sem }()
 
-			sem <- struct{}{}
+			// фоновый контекст не отменяется, Acquire просто ждёт места
+			sem.Acquire(context.Background()) //nolint:errcheck
+			defer sem.Release()
+
 			isSpam, err := HasSpam(msgID)
 			if err != nil {
 				log.Println("Ошибка при проверке сообщения на спам")
@@ -164,21 +184,174 @@ func CombineResults(in, out chan interface{}) {
 		messages = append(messages, message)
 	}
 
-	slices.SortFunc(messages, func(a, b MsgData) int {
-		if a.HasSpam == b.HasSpam {
-			if a.ID < b.ID {
-				return -1
-			} else {
-				return 1
-			}
-		}
+	sortMessages(messages)
+
+	for _, message := range messages {
+		out <- formatMessage(message)
+	}
+}
+
+// сначала спам, внутри групп - по возрастанию ID
+func sortMessages(messages []MsgData) {
+	slices.SortFunc(messages, compareMessages)
+}
+
+func compareMessages(a, b MsgData) int {
+	if a.HasSpam != b.HasSpam {
 		if a.HasSpam {
 			return -1
 		}
 		return 1
-	})
+	}
+
+	return cmp.Compare(a.ID, b.ID)
+}
+
+func formatMessage(message MsgData) string {
+	return fmt.Sprintf("%t %d", message.HasSpam, message.ID)
+}
+
+// Типизированные стадии: соединяются через Then, типы проверяются при компиляции.
+// Вместо записи в лог возвращают ошибку, которая останавливает весь конвейер,
+// и сами останавливаются при отмене контекста
+
+// стадии поверх симуляции, без повторов и предохранителей
+var (
+	SelectUsersStage    = Stage[string, User](Resilience{}.wrap(SimulatedBackend()).selectUsers)
+	SelectMessagesStage = Stage[User, MsgID](Resilience{}.wrap(SimulatedBackend()).selectMessages)
+	CheckSpamStage      = Stage[MsgID, MsgData](Resilience{}.wrap(SimulatedBackend()).checkSpam)
+)
+
+// SpamPipeline - весь конвейер: email'ы на входе, строки результата на выходе
+var SpamPipeline = Resilience{}.Pipeline()
+
+// backendCalls - вызовы бэкенда, которые делают стадии
+type backendCalls struct {
+	getUser     func(ctx context.Context, email string) (User, error)
+	getMessages func(ctx context.Context, users []User) ([]MsgID, error)
+	hasSpam     func(ctx context.Context, id MsgID) (bool, error)
+}
+
+func (calls backendCalls) pipeline(combine Stage[MsgData, string]) Stage[string, string] {
+	return Then(Then(Then(calls.selectUsers, calls.selectMessages), calls.checkSpam), combine)
+}
+
+func (calls backendCalls) selectUsers(ctx context.Context, in <-chan string, out chan<- User) error {
+	checkAlias := map[uint64]struct{}{}
+	mu := &sync.Mutex{}
+
+	g, ctx := newGroup(ctx)
+
+	for {
+		email, ok := receive(ctx, in)
+		if !ok {
+			break
+		}
+
+		g.Go(func() error {
+			user, err := calls.getUser(ctx, email)
+			if err != nil {
+				return fmt.Errorf("GetUser(%s): %w", email, err)
+			}
+
+			mu.Lock()
+			_, exist := checkAlias[user.ID]
+			checkAlias[user.ID] = struct{}{}
+			mu.Unlock()
+
+			if exist {
+				return nil
+			}
+			return send(ctx, out, user)
+		})
+	}
+
+	return g.Wait()
+}
+
+func (calls backendCalls) selectMessages(ctx context.Context, in <-chan User, out chan<- MsgID) error {
+	batcher := Batcher[User]{Size: messagesBatchSize(), Linger: GetMessagesBatchLinger}
+	return Then(batcher.Run, calls.fetchMessages)(ctx, in, out)
+}
+
+func (calls backendCalls) fetchMessages(ctx context.Context, in <-chan []User, out chan<- MsgID) error {
+	g, ctx := newGroup(ctx)
+
+	for {
+		batch, ok := receive(ctx, in)
+		if !ok {
+			break
+		}
+
+		g.Go(func() error {
+			msgIDs, err := calls.getMessages(ctx, batch)
+			if err != nil {
+				return fmt.Errorf("GetMessages: %w", err)
+			}
+			for _, msgID := range msgIDs {
+				if err := send(ctx, out, msgID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+func (calls backendCalls) checkSpam(ctx context.Context, in <-chan MsgID, out chan<- MsgData) error {
+	sem := limiter.NewSemaphore(spamCheckConcurrency())
+
+	g, ctx := newGroup(ctx)
+
+	for {
+		msgID, ok := receive(ctx, in)
+		if !ok {
+			break
+		}
+
+		g.Go(func() error {
+			if err := sem.Acquire(ctx); err != nil {
+				return err
+			}
+			defer sem.Release()
+
+			isSpam, err := calls.hasSpam(ctx, msgID)
+			if err != nil {
+				return fmt.Errorf("HasSpam(%d): %w", msgID, err)
+			}
+			return send(ctx, out, MsgData{ID: msgID, HasSpam: isSpam})
+		})
+	}
+
+	return g.Wait()
+}
+
+func CombineResultsStage(ctx context.Context, in <-chan MsgData, out chan<- string) error {
+	messages := []MsgData{}
+
+	for {
+		message, ok := receive(ctx, in)
+		if !ok {
+			break
+		}
+
+		messages = append(messages, message)
+	}
+
+	// вход мог закончиться из-за отмены - неполный результат не отдаём
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sortMessages(messages)
 
 	for _, message := range messages {
-		out <- fmt.Sprintf("%t %d", message.HasSpam, message.ID)
+		if err := send(ctx, out, formatMessage(message)); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
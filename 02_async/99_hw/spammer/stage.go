@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stage - типизированная стадия конвейера: читает In, пишет Out.
+// Закрывать out стадия не должна, это делает тот, кто её запустил
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+// RunStage запускает стадию, закрывает out после её завершения
+// и дочитывает in, чтобы предыдущая стадия не зависла на записи
+func RunStage[In, Out any](ctx context.Context, stage Stage[In, Out], in <-chan In, out chan<- Out) error {
+	err := stage(ctx, in, out)
+	close(out)
+
+	for range in {
+	}
+
+	return err
+}
+
+// Then соединяет две стадии в одну: выход first идёт на вход second.
+// Типы проверяются при компиляции, ошибка одной стадии отменяет другую
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		g, ctx := newGroup(ctx)
+		mid := make(chan B)
+
+		g.Go(func() error {
+			return RunStage(ctx, first, in, mid)
+		})
+		g.Go(func() error {
+			err := second(ctx, mid, out)
+			for range mid {
+			}
+			return err
+		})
+
+		return g.Wait()
+	}
+}
+
+// Untyped превращает типизированную стадию в нетипизированную для RunPipelineContext.
+// Значение неожиданного типа на входе останавливает конвейер с errUnexpectedType
+func Untyped[In, Out any](stage Stage[In, Out]) ctxCmd {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		g, ctx := newGroup(ctx)
+		typedIn := make(chan In)
+		typedOut := make(chan Out)
+
+		g.Go(func() error {
+			defer close(typedIn)
+			for {
+				value, ok := receive(ctx, in)
+				if !ok {
+					return nil
+				}
+
+				typed, ok := value.(In)
+				if !ok {
+					return fmt.Errorf("%w: %T вместо %T", errUnexpectedType, value, typed)
+				}
+				if err := send(ctx, typedIn, typed); err != nil {
+					return err
+				}
+			}
+		})
+
+		g.Go(func() error {
+			return RunStage(ctx, stage, typedIn, typedOut)
+		})
+
+		g.Go(func() error {
+			var err error
+			for value := range typedOut {
+				if err == nil {
+					err = send[interface{}](ctx, out, value)
+				}
+			}
+			return err
+		})
+
+		return g.Wait()
+	}
+}
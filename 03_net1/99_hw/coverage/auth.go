@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Авторизация SearchServer. Authenticator по AccessToken решает, пускать ли запрос,
+// и отдаёт Grant - что этому токену разрешено. Токены бывают:
+//   - статические, из файла (LoadTokenFile): строка "имя токен [права...]", # - комментарий;
+//   - подписанные (HMACAuth): имя, срок действия и права лежат в самом токене,
+//     выпускаются командой issue (см. main.go).
+//
+// Права: fields:Id,Name - в ответ попадут только эти поля, и фильтровать и сортировать можно только
+// по ним; limit:10 - не больше 10 пользователей за запрос, больший limit урезается. Не прошедший проверку
+// запрос получает 401, клиент отдаёт на него "bad AccessToken"; запрос к скрытым полям - 400
+
+var (
+	errUnknownToken = errors.New("неизвестный токен")
+	errBadToken     = errors.New("токен повреждён или подписан другим ключом")
+	errTokenExpired = errors.New("срок действия токена истёк")
+)
+
+type Authenticator interface {
+	Authenticate(token string) (*Grant, error)
+}
+
+// SearchAuth - чем SearchServer проверяет токены; по умолчанию пускает с любым непустым
+var SearchAuth Authenticator = AnyToken{}
+
+// Grant - владелец токена и его права
+type Grant struct {
+	Subject  string
+	Fields   []string // поля ответа, как в JSON; пусто - все
+	MaxLimit int      // 0 - без ограничений
+}
+
+// userFields - поля ответа, которые можно разрешить; имена без учёта регистра
+var userFields = map[string]string{
+	"id":     ID,
+	"name":   Name,
+	"age":    Age,
+	"about":  About,
+	"gender": Gender,
+}
+
+// ParseScopes разбирает права вида fields:Id,Name и limit:10
+func ParseScopes(subject string, scopes []string) (*Grant, error) {
+	grant := &Grant{Subject: subject}
+
+	for _, scope := range scopes {
+		kind, value, _ := strings.Cut(scope, ":")
+		switch kind {
+		case "fields":
+			for _, name := range strings.Split(value, ",") {
+				field, ok := userFields[strings.ToLower(name)]
+				if !ok {
+					return nil, fmt.Errorf("право %q: нет поля %q", scope, name)
+				}
+				grant.Fields = append(grant.Fields, field)
+			}
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return nil, fmt.Errorf("право %q: limit должен быть целым больше нуля", scope)
+			}
+			grant.MaxLimit = limit
+		default:
+			return nil, fmt.Errorf("неизвестное право %q", scope)
+		}
+	}
+
+	return grant, nil
+}
+
+// check проверяет запрос на права токена. Limit больше разрешённого или без limit урезается до MaxLimit:
+// клиент не знает прав токена и просит страницу по умолчанию. Лишний пользователь, которого клиент
+// в режиме offset просит, чтобы узнать о следующей странице, тоже не отдаётся - обходить всё
+// с таким токеном надо по курсору. query и сортировку берём из state - у продолжения
+// по курсору они свои, и выпустить курсор мог другой токен
+func (grant *Grant) check(request *SearchRequest, state cursorState) error {
+	if grant.MaxLimit != 0 && (request.Limit == LimitNotSet || request.Limit > grant.MaxLimit) {
+		request.Limit = grant.MaxLimit
+	}
+
+	if len(grant.Fields) == 0 {
+		return nil
+	}
+	if err := grant.checkQuery(state.Query); err != nil {
+		return err
+	}
+	return grant.checkOrder(state.OrderField, state.OrderBy)
+}
+
+// checkQuery не даёт фильтровать по скрытым полям: иначе их значения можно подобрать запросами
+func (grant *Grant) checkQuery(query string) error {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		switch {
+		case token.field != "":
+			field := strings.ToLower(token.field)
+			if _, known := queryFields[field]; known && !contains(grant.Fields, userFields[field]) {
+				return &QueryError{Position: token.pos, Field: token.field, Message: "поле недоступно токену"}
+			}
+		case token.kind == tokenWord || token.kind == tokenPhrase:
+			if !contains(grant.Fields, Name) || !contains(grant.Fields, About) {
+				return &QueryError{Position: token.pos, Message: "текст ищется в name и about, а токену доступны не оба поля"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkOrder не даёт сортировать по скрытым полям; FirstName и LastName - части Name
+func (grant *Grant) checkOrder(orderField string, orderBy int) error {
+	order, err := ParseOrder(orderField, orderBy)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range order {
+		field := key.Field
+		if field == FirstName || field == LastName {
+			field = Name
+		}
+		if !contains(grant.Fields, field) {
+			return &OrderError{Field: key.Field, Message: "поле недоступно токену"}
+		}
+	}
+
+	return nil
+}
+
+// project оставляет в ответе только разрешённые поля
+func (grant *Grant) project(users []UserXMLData) any {
+	if len(grant.Fields) == 0 {
+		return users
+	}
+
+	result := make([]grantedUser, len(users))
+	for i := range users {
+		result[i] = grantedUser{user: &users[i], fields: grant.Fields}
+	}
+	return result
+}
+
+type grantedUser struct {
+	user   *UserXMLData
+	fields []string
+}
+
+func (granted grantedUser) MarshalJSON() ([]byte, error) {
+	serializeMap := granted.user.serializeMap()
+	for field := range serializeMap {
+		if !contains(granted.fields, field) {
+			delete(serializeMap, field)
+		}
+	}
+
+	return json.Marshal(serializeMap)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyToken пускает с любым непустым токеном, как SearchServer работал раньше
+type AnyToken struct{}
+
+func (AnyToken) Authenticate(token string) (*Grant, error) {
+	if token == "" {
+		return nil, errUnknownToken
+	}
+	return &Grant{}, nil
+}
+
+// StaticTokens - токены из файла; храним их хеши, а не сами токены
+type StaticTokens map[[sha256.Size]byte]*Grant
+
+func LoadTokenFile(fileName string) (StaticTokens, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := StaticTokens{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) < 2 {
+			return nil, fmt.Errorf("%s: строка %d: ожидается имя и токен", fileName, lineNumber)
+		}
+
+		grant, err := ParseScopes(words[0], words[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: строка %d: %w", fileName, lineNumber, err)
+		}
+
+		hash := sha256.Sum256([]byte(words[1]))
+		if _, ok := tokens[hash]; ok {
+			return nil, fmt.Errorf("%s: строка %d: токен уже встречался", fileName, lineNumber)
+		}
+		tokens[hash] = grant
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (tokens StaticTokens) Authenticate(token string) (*Grant, error) {
+	grant, ok := tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errUnknownToken
+	}
+	return grant, nil
+}
+
+// HMACAuth выпускает и проверяет подписанные токены
+type HMACAuth struct {
+	secret []byte
+	now    func() time.Time
+}
+
+type tokenClaims struct {
+	Subject string   `json:"sub"`
+	Expires int64    `json:"exp"`
+	Scopes  []string `json:"scp,omitempty"`
+}
+
+func NewHMACAuth(secret []byte) (*HMACAuth, error) {
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("ключ подписи короче 16 байт")
+	}
+	return &HMACAuth{secret: secret, now: time.Now}, nil
+}
+
+// LoadHMACAuth читает ключ подписи из файла, пробелы по краям не считаются
+func LoadHMACAuth(fileName string) (*HMACAuth, error) {
+	secret, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewHMACAuth([]byte(strings.TrimSpace(string(secret))))
+}
+
+// Issue выпускает токен для subject, действующий ttl
+func (auth *HMACAuth) Issue(subject string, ttl time.Duration, scopes ...string) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("срок действия должен быть больше нуля")
+	}
+	if _, err := ParseScopes(subject, scopes); err != nil {
+		return "", err
+	}
+
+	return signJSON(auth.secret, tokenClaims{
+		Subject: subject,
+		Expires: auth.now().Add(ttl).Unix(),
+		Scopes:  scopes,
+	})
+}
+
+func (auth *HMACAuth) Authenticate(token string) (*Grant, error) {
+	claims := tokenClaims{}
+	if err := verifyJSON(auth.secret, token, &claims); err != nil {
+		return nil, errBadToken
+	}
+	if auth.now().Unix() >= claims.Expires {
+		return nil, errTokenExpired
+	}
+
+	return ParseScopes(claims.Subject, claims.Scopes)
+}
+
+// Authenticators проверяет токен по очереди; следующий пробуется, только если предыдущий токена не знает.
+// HMACAuth чужой токен считает повреждённым, поэтому его ставят последним
+type Authenticators []Authenticator
+
+func (list Authenticators) Authenticate(token string) (*Grant, error) {
+	err := errUnknownToken
+	for _, auth := range list {
+		var grant *Grant
+		grant, err = auth.Authenticate(token)
+		if !errors.Is(err, errUnknownToken) {
+			return grant, err
+		}
+	}
+	return nil, err
+}
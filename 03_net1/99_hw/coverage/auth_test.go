@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACAuth(t *testing.T) {
+	_, err := NewHMACAuth([]byte("short"))
+	assert.Error(t, err)
+
+	auth, err := NewHMACAuth([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return now }
+
+	token, err := auth.Issue("reports", time.Hour, "fields:id,NAME", "limit:10")
+	require.NoError(t, err)
+
+	grant, err := auth.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, &Grant{Subject: "reports", Fields: []string{ID, Name}, MaxLimit: 10}, grant)
+
+	now = now.Add(time.Hour)
+	_, err = auth.Authenticate(token)
+	assert.ErrorIs(t, err, errTokenExpired)
+	now = now.Add(-time.Minute)
+
+	other, err := NewHMACAuth([]byte("fedcba9876543210"))
+	require.NoError(t, err)
+	_, err = other.Authenticate(token)
+	assert.ErrorIs(t, err, errBadToken)
+
+	payload, signature, _ := strings.Cut(token, ".")
+	forged, err := signJSON([]byte("fedcba9876543210"), tokenClaims{Subject: "admin", Expires: now.Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for _, bad := range []string{"", "garbage", payload, forgedPayload + "." + signature} {
+		_, err = auth.Authenticate(bad)
+		assert.ErrorIs(t, err, errBadToken, bad)
+	}
+
+	for _, scope := range []string{"fields:Salary", "fields:", "limit:0", "limit:x", "admin"} {
+		_, err = auth.Issue("reports", time.Hour, scope)
+		assert.Error(t, err, scope)
+	}
+	_, err = auth.Issue("reports", 0)
+	assert.Error(t, err)
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		fileName := filepath.Join(dir, "tokens.txt")
+		require.NoError(t, os.WriteFile(fileName, []byte(content), 0o600))
+		return fileName
+	}
+
+	tokens, err := LoadTokenFile(write("# имя токен права\nadmin s3cret\n\nreports r3ports fields:Id,Name limit:5 # отчёты\n"))
+	require.NoError(t, err)
+
+	grant, err := tokens.Authenticate("s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, &Grant{Subject: "admin"}, grant)
+
+	grant, err = tokens.Authenticate("r3ports")
+	require.NoError(t, err)
+	assert.Equal(t, &Grant{Subject: "reports", Fields: []string{ID, Name}, MaxLimit: 5}, grant)
+
+	_, err = tokens.Authenticate("admin")
+	assert.ErrorIs(t, err, errUnknownToken)
+
+	errorCases := map[string]string{
+		"admin\n":                 "строка 1: ожидается имя и токен",
+		"a t1\nb t2 limit:-1\n":   `строка 2: право "limit:-1": limit должен быть целым больше нуля`,
+		"a t1\n# b t1\nc t1\n":    "строка 3: токен уже встречался",
+		"a t1 fields:Id,Salary\n": `строка 1: право "fields:Id,Salary": нет поля "Salary"`,
+	}
+	for content, expected := range errorCases {
+		_, err := LoadTokenFile(write(content))
+		require.Error(t, err, content)
+		assert.Contains(t, err.Error(), expected, content)
+	}
+}
+
+func TestSearchServerAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokensFileName := filepath.Join(dir, "tokens.txt")
+	require.NoError(t, os.WriteFile(tokensFileName, []byte("admin s3cret\nreports r3ports fields:Id,Name limit:2\n"), 0o600))
+
+	tokens, err := LoadTokenFile(tokensFileName)
+	require.NoError(t, err)
+	hmacAuth, err := NewHMACAuth([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+
+	saved := SearchAuth
+	SearchAuth = Authenticators{tokens, hmacAuth}
+	t.Cleanup(func() { SearchAuth = saved })
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	find := func(token string, request SearchRequest) (*SearchResponse, error) {
+		client := SearchClient{AccessToken: token, URL: ts.URL}
+		return client.FindUsers(request)
+	}
+	search := func(token string) (*SearchResponse, error) {
+		return find(token, SearchRequest{Limit: 10})
+	}
+
+	result, err := search("s3cret")
+	require.NoError(t, err)
+	assert.Len(t, result.Users, 10)
+	assert.NotEmpty(t, result.Users[0].About)
+
+	// limit больше разрешённого урезается, лишнего пользователя для NextPage токен тоже не получит
+	result, err = search("r3ports")
+	require.NoError(t, err)
+	assert.Len(t, result.Users, 2)
+
+	// лишних полей в ответе нет
+	result, err = find("r3ports", SearchRequest{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, result.Users, 2)
+	assert.False(t, result.NextPage)
+	assert.Equal(t, User{ID: result.Users[0].ID, Name: result.Users[0].Name}, result.Users[0])
+	assert.NotEmpty(t, result.Users[0].Name)
+
+	rawSearch := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("AccessToken", "r3ports")
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+		return w
+	}
+
+	w := rawSearch("/?limit=2&order_field=Id")
+	raw := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	assert.Equal(t, []map[string]interface{}{{ID: 0.0, Name: "Boyd Wolf"}, {ID: 1.0, Name: "Hilda Mayer"}}, raw)
+
+	w = rawSearch("/?order_field=Id")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	assert.Len(t, raw, 2)
+
+	for _, target := range []string{"/?limit=4", "/?limit=3&cursor=first", "/?limit=3&offset=1"} {
+		w = rawSearch(target)
+		require.Equal(t, http.StatusOK, w.Code, target)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+		assert.Len(t, raw, 2, target)
+	}
+
+	token, err := hmacAuth.Issue("reports", time.Hour, "limit:3")
+	require.NoError(t, err)
+	result, err = search(token)
+	require.NoError(t, err)
+	assert.Len(t, result.Users, 3)
+	result, err = find(token, SearchRequest{Limit: 3})
+	require.NoError(t, err)
+	assert.Len(t, result.Users, 3)
+
+	// итератор просит страницы по 25, а получает по 3 - и всё равно обходит всех
+	admin := SearchClient{AccessToken: "s3cret", URL: ts.URL}
+	var expected []int
+	for users := admin.FindUsersIter(SearchRequest{Query: "gender:male"}); users.Next(); {
+		expected = append(expected, users.User().ID)
+	}
+	scoped := SearchClient{AccessToken: token, URL: ts.URL}
+	var ids []int
+	users := scoped.FindUsersIter(SearchRequest{Query: "gender:male"})
+	for users.Next() {
+		ids = append(ids, users.User().ID)
+	}
+	require.NoError(t, users.Err())
+	assert.Greater(t, len(expected), 3)
+	assert.Equal(t, expected, ids)
+
+	// по скрытым полям нельзя ни фильтровать, ни сортировать
+	for query, expected := range map[string]*QueryError{
+		"age:>30":                {Position: 1, Field: "age", Message: "поле недоступно токену"},
+		"name:hilda OR email:x*": {Position: 15, Field: "email", Message: "поле недоступно токену"},
+		"hilda":                  {Position: 1, Message: "текст ищется в name и about, а токену доступны не оба поля"},
+		`NOT "sit amet"`:         {Position: 5, Message: "текст ищется в name и about, а токену доступны не оба поля"},
+	} {
+		_, err = find("r3ports", SearchRequest{Limit: 2, Query: query})
+		assert.Equal(t, expected, err, query)
+	}
+	result, err = find("r3ports", SearchRequest{Limit: 2, Query: "name:hilda id:<10"})
+	require.NoError(t, err)
+	assert.Len(t, result.Users, 1)
+
+	for _, orderField := range []string{"Age", "Id, Company desc", "About"} {
+		_, err = find("r3ports", SearchRequest{Limit: 2, OrderField: orderField, OrderBy: OrderByAsc})
+		assert.Error(t, err, orderField)
+	}
+	_, err = find("r3ports", SearchRequest{Limit: 2, OrderField: "LastName desc, Id"})
+	assert.NoError(t, err)
+
+	// курсор, выпущенный другим токеном, скрытых полей тоже не откроет
+	result, err = find("s3cret", SearchRequest{Limit: 1, Query: "age:>30", Cursor: FirstPageCursor})
+	require.NoError(t, err)
+	require.NotEmpty(t, result.NextCursor)
+	_, err = find("r3ports", SearchRequest{Limit: 1, Cursor: result.NextCursor})
+	assert.Equal(t, &QueryError{Position: 1, Field: "age", Message: "поле недоступно токену"}, err)
+
+	hmacAuth.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	defer func() { hmacAuth.now = time.Now }()
+
+	for _, bad := range []string{token, "unknown", "admin"} {
+		_, err = search(bad)
+		require.Error(t, err, bad)
+		assert.Equal(t, "bad AccessToken", err.Error(), bad)
+	}
+}
+
+func TestRunIssue(t *testing.T) {
+	secretFileName := filepath.Join(t.TempDir(), "secret.key")
+	require.NoError(t, os.WriteFile(secretFileName, []byte("0123456789abcdef\n"), 0o600))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"issue", "-secret", secretFileName, "-subject", "reports", "-ttl", "1h", "-scope", "fields:Id", "-scope", "limit:3"}, stdout, stderr)
+	require.Equal(t, exitOK, code, stderr.String())
+
+	auth, err := LoadHMACAuth(secretFileName)
+	require.NoError(t, err)
+	grant, err := auth.Authenticate(strings.TrimSpace(stdout.String()))
+	require.NoError(t, err)
+	assert.Equal(t, &Grant{Subject: "reports", Fields: []string{ID}, MaxLimit: 3}, grant)
+
+	usageCases := [][]string{
+		{},
+		{"revoke"},
+		{"issue", "-subject", "reports"},
+		{"issue", "-secret", secretFileName, "-subject", "reports", "-scope", "admin"},
+		{"issue", "-secret", secretFileName, "-subject", "reports", "-ttl", "-1h"},
+		{"serve"},
+	}
+	for _, args := range usageCases {
+		stderr.Reset()
+		assert.Equal(t, exitUsage, run(args, stdout, stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+
+	assert.Equal(t, exitFailure, run([]string{"issue", "-secret", secretFileName + ".missing", "-subject", "reports"}, stdout, stderr))
+	assert.Equal(t, exitFailure, run([]string{"serve", "-secret", secretFileName, "-cursor-secret", secretFileName + ".missing"}, stdout, stderr))
+}
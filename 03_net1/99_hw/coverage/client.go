@@ -35,10 +35,16 @@ type User struct {
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// курсор следующей страницы, только для запросов с Cursor; пустой - страница последняя
+	NextCursor string
 }
 
 type SearchErrorResponse struct {
 	Error string
+	// подробности для ErrorBadQuery и ErrorBadOrderField
+	Message  string `json:",omitempty"`
+	Field    string `json:",omitempty"`
+	Position int    `json:",omitempty"`
 }
 
 const (
@@ -47,15 +53,22 @@ const (
 	OrderByDesc = -1
 
 	ErrorBadOrderField = `OrderField invalid`
+	ErrorBadQuery      = `Query invalid`
+	ErrorBadCursor     = `Cursor invalid`
 )
 
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
-	OrderField string
+	Query      string // условие поиска, синтаксис - в query.go
+	OrderField string // поля через запятую, у каждого можно указать asc или desc: "Age desc, Name"
 	//  1 по возрастанию, 0 как встретилось, -1 по убыванию
 	OrderBy int
+	// условие, собранное в коде (см. querybuilder.go); объединяется с Query через AND
+	Where Query
+	// FirstPageCursor или NextCursor прошлого ответа; с курсором Offset не учитывается,
+	// а Query, OrderField и OrderBy берутся из курсора (см. cursor.go)
+	Cursor string
 }
 
 type SearchClient struct {
@@ -80,58 +93,166 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
 
+	if req.Cursor != "" {
+		return srv.findPage(req)
+	}
+
 	// нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
 	req.Limit++
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
-	searcherParams.Add("query", req.Query)
+	searcherParams.Add("query", req.queryString())
+	searcherParams.Add("order_field", req.OrderField)
+	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+
+	data, _, err := srv.do(req, searcherParams)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SearchResponse{}
+	if len(data) == req.Limit {
+		result.NextPage = true
+		result.Users = data[0 : len(data)-1]
+	} else {
+		result.Users = data[0:]
+	}
+
+	return &result, err
+}
+
+// findPage - одна страница по курсору, следующую сервер отдаёт в заголовке
+func (srv *SearchClient) findPage(req SearchRequest) (*SearchResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = 25
+	}
+
+	searcherParams := url.Values{}
+	searcherParams.Add("limit", strconv.Itoa(req.Limit))
+	searcherParams.Add("query", req.queryString())
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	searcherParams.Add("cursor", req.Cursor)
+
+	data, header, err := srv.do(req, searcherParams)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SearchResponse{Users: data, NextCursor: header.Get(NextCursorHeader)}
+	result.NextPage = result.NextCursor != ""
 
+	return &result, nil
+}
+
+// do отправляет запрос и разбирает ответ и ошибки сервера
+func (srv *SearchClient) do(req SearchRequest, searcherParams url.Values) ([]User, http.Header, error) {
 	searcherReq, _ := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil) //nolint:errcheck
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
 
 	resp, err := client.Do(searcherReq)
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+			return nil, nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, nil, fmt.Errorf("unknown error %s", err)
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
 
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("bad AccessToken")
+		return nil, nil, fmt.Errorf("bad AccessToken")
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, nil, fmt.Errorf("SearchServer fatal error")
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, nil, fmt.Errorf("cant unpack error json: %s", err)
 		}
 		if errResp.Error == ErrorBadOrderField {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+			field := req.OrderField
+			if errResp.Field != "" {
+				field = errResp.Field
+			}
+			return nil, nil, fmt.Errorf("OrderFeld %s invalid", field)
+		}
+		if errResp.Error == ErrorBadQuery {
+			return nil, nil, &QueryError{Position: errResp.Position, Field: errResp.Field, Message: errResp.Message}
+		}
+		if errResp.Error == ErrorBadCursor {
+			return nil, nil, fmt.Errorf("cursor invalid: %s", errResp.Message)
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
 	data := []User{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
-		result.NextPage = true
-		result.Users = data[0 : len(data)-1]
-	} else {
-		result.Users = data[0:]
+	return data, resp.Header, nil
+}
+
+// UserIterator обходит все страницы запроса по курсору:
+//
+//	users := srv.FindUsersIter(SearchRequest{Query: "gender:female", OrderField: "Age desc"})
+//	for users.Next() {
+//		user := users.User()
+//	}
+//	if err := users.Err(); err != nil {
+type UserIterator struct {
+	srv     *SearchClient
+	req     SearchRequest
+	page    []User
+	current User
+	last    bool
+	err     error
+}
+
+// FindUsersIter - итератор по всем найденным пользователям; Limit - размер страницы,
+// Cursor - откуда продолжить, по умолчанию с начала
+func (srv *SearchClient) FindUsersIter(req SearchRequest) *UserIterator {
+	iter := &UserIterator{srv: srv, req: req}
+	if req.Offset != 0 {
+		iter.err = fmt.Errorf("offset is not supported with cursor")
 	}
+	if iter.req.Cursor == "" {
+		iter.req.Cursor = FirstPageCursor
+	}
+	return iter
+}
 
-	return &result, err
+// Next переходит к следующему пользователю, запрашивая страницы по мере надобности
+func (iter *UserIterator) Next() bool {
+	for len(iter.page) == 0 {
+		if iter.last || iter.err != nil {
+			return false
+		}
+
+		resp, err := iter.srv.FindUsers(iter.req)
+		if err != nil {
+			iter.err = err
+			return false
+		}
+
+		iter.page = resp.Users
+		iter.last = resp.NextCursor == ""
+		iter.req.Cursor = resp.NextCursor
+	}
+
+	iter.current, iter.page = iter.page[0], iter.page[1:]
+	return true
+}
+
+func (iter *UserIterator) User() User {
+	return iter.current
+}
+
+// Err - ошибка, на которой остановился обход
+func (iter *UserIterator) Err() error {
+	return iter.err
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Постраничный обход по курсору. Первую страницу просят с cursor=first, следующую -
+// с курсором из заголовка X-Next-Cursor прошлого ответа; заголовка нет - страниц больше нет.
+// Курсор помнит query, сортировку и ключи сортировки последнего отданного пользователя,
+// поэтому следующая страница начинается сразу после него, даже если между запросами
+// пользователей добавили или удалили. Чтобы порядок был полным, к сортировке всегда
+// добавляется Id, а "как встретилось" заменяется сортировкой по Id.
+// Курсор зашифрован CursorSecret: подделать, поправить или прочитать его нельзя - внутри
+// ключи сортировки последнего пользователя, в том числе полей, которых в ответе нет (email, company)
+
+const (
+	FirstPageCursor  = "first"
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// CursorSecret - ключ шифрования курсоров; по умолчанию свой у каждого запуска сервера,
+// тогда курсоры не переживают перезапуск и не подходят к другим экземплярам (см. LoadCursorSecret)
+var CursorSecret = newCursorSecret()
+
+var (
+	errBadCursor   = errors.New("курсор повреждён или выдан не этим сервером")
+	errCursorLimit = errors.New("с курсором limit должен быть больше нуля")
+)
+
+func newCursorSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// LoadCursorSecret читает ключ курсоров из файла, пробелы по краям не считаются, как в LoadHMACAuth
+func LoadCursorSecret(fileName string) ([]byte, error) {
+	secret, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("ключ курсоров короче 16 байт")
+	}
+	return secret, nil
+}
+
+// cursorState - содержимое курсора
+type cursorState struct {
+	Query      string      `json:"q,omitempty"`
+	OrderField string      `json:"f,omitempty"`
+	OrderBy    int         `json:"b,omitempty"`
+	Last       *cursorUser `json:"l,omitempty"`
+}
+
+// cursorUser - последний отданный пользователь, только поля, по которым идёт сортировка
+type cursorUser struct {
+	ID         int    `json:"id"`
+	FirstName  string `json:"fn,omitempty"`
+	LastName   string `json:"ln,omitempty"`
+	Age        int    `json:"a,omitempty"`
+	About      string `json:"ab,omitempty"`
+	Gender     string `json:"g,omitempty"`
+	Company    string `json:"c,omitempty"`
+	Email      string `json:"e,omitempty"`
+	IsActive   bool   `json:"ia,omitempty"`
+	Registered string `json:"r,omitempty"`
+}
+
+func rememberUser(user UserXMLData, order []OrderKey) *cursorUser {
+	last := &cursorUser{ID: user.ID}
+	for _, key := range order {
+		switch key.Field {
+		case Name:
+			last.FirstName, last.LastName = user.FirstName, user.LastName
+		case FirstName:
+			last.FirstName = user.FirstName
+		case LastName:
+			last.LastName = user.LastName
+		case Age:
+			last.Age = user.Age
+		case About:
+			last.About = user.About
+		case Gender:
+			last.Gender = user.Gender
+		case Company:
+			last.Company = user.Company
+		case Email:
+			last.Email = user.Email
+		case IsActive:
+			last.IsActive = user.IsActive
+		case Registered:
+			last.Registered = user.Registered
+		}
+	}
+	return last
+}
+
+func (last *cursorUser) user() UserXMLData {
+	return UserXMLData{
+		ID:         last.ID,
+		FirstName:  last.FirstName,
+		LastName:   last.LastName,
+		Age:        last.Age,
+		About:      last.About,
+		Gender:     last.Gender,
+		Company:    last.Company,
+		Email:      last.Email,
+		IsActive:   last.IsActive,
+		Registered: last.Registered,
+	}
+}
+
+func encodeCursor(state cursorState) (string, error) {
+	return sealJSON(CursorSecret, state)
+}
+
+func decodeCursor(cursor string) (cursorState, error) {
+	state := cursorState{}
+	if err := openJSON(CursorSecret, cursor, &state); err != nil {
+		return cursorState{}, errBadCursor
+	}
+	return state, nil
+}
+
+// requestState - query и сортировка, по которым пойдёт поиск: у настоящего курсора они из него.
+// Страница из нуля пользователей по курсору - пустая страница без следующей, обход бы на ней оборвался
+func requestState(request *SearchRequest) (cursorState, error) {
+	if request.Cursor != "" && request.Limit == 0 {
+		return cursorState{}, errCursorLimit
+	}
+	if request.Cursor == "" || request.Cursor == FirstPageCursor {
+		return cursorState{Query: request.Query, OrderField: request.OrderField, OrderBy: request.OrderBy}, nil
+	}
+	return decodeCursor(request.Cursor)
+}
+
+// ApplyCursorToUsers отдаёт страницу по курсору request.Cursor и курсор следующей страницы,
+// пустой, если страница последняя. С настоящим курсором query и сортировка берутся из него
+func ApplyCursorToUsers(request *SearchRequest, index *UserIndex) ([]UserXMLData, string, error) {
+	state, err := requestState(request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	queryUsers, err := index.Search(state.Query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	order, err := ParseOrder(state.OrderField, state.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+	if !hasOrderField(order, ID) {
+		order = append(order, OrderKey{Field: ID, Direction: OrderByAsc})
+	}
+	OrderUsers(&queryUsers, order)
+
+	start := 0
+	if state.Last != nil {
+		compare := compareUsers(order)
+		last := state.Last.user()
+		start = sort.Search(len(queryUsers), func(i int) bool {
+			return compare(&queryUsers[i], &last) > 0
+		})
+	}
+
+	end := len(queryUsers)
+	if request.Limit != LimitNotSet && start+request.Limit < end {
+		end = start + request.Limit
+	}
+	page := queryUsers[start:end]
+
+	if end == len(queryUsers) || len(page) == 0 {
+		return page, "", nil
+	}
+
+	state.Last = rememberUser(page[len(page)-1], order)
+	next, err := encodeCursor(state)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, next, nil
+}
+
+func hasOrderField(order []OrderKey, field string) bool {
+	for _, key := range order {
+		if key.Field == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUsersIter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "validToken", URL: ts.URL}
+
+	// все пользователи страницами по 4, порядок - как у одного большого запроса
+	all, err := client.FindUsers(SearchRequest{Limit: 25, OrderField: "Age desc, Id"})
+	require.NoError(t, err)
+	rest, err := client.FindUsers(SearchRequest{Limit: 25, Offset: 25, OrderField: "Age desc, Id"})
+	require.NoError(t, err)
+	expected := append(all.Users, rest.Users...)
+
+	users := client.FindUsersIter(SearchRequest{Limit: 4, OrderField: "Age desc"})
+	walked := []User{}
+	for users.Next() {
+		walked = append(walked, users.User())
+	}
+	require.NoError(t, users.Err())
+	assert.Len(t, walked, 35)
+	assert.Equal(t, expected, walked)
+
+	users = client.FindUsersIter(SearchRequest{Query: "gender:female", Where: Field(Age).Gt(30)})
+	count := 0
+	for users.Next() {
+		assert.Equal(t, "female", users.User().Gender)
+		assert.Greater(t, users.User().Age, 30)
+		count++
+	}
+	require.NoError(t, users.Err())
+	assert.NotZero(t, count)
+
+	users = client.FindUsersIter(SearchRequest{Offset: 5})
+	assert.False(t, users.Next())
+	assert.Error(t, users.Err())
+
+	// старый постраничный режим не тронут
+	page, err := client.FindUsers(SearchRequest{Limit: 4, Offset: 32})
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 3)
+	assert.False(t, page.NextPage)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestCursorSurvivesChanges(t *testing.T) {
+	users := generateUsers(10)
+	page := func(index *UserIndex, cursor string) ([]int, string) {
+		request := &SearchRequest{Limit: 3, OrderField: "Id", OrderBy: OrderByDesc, Cursor: cursor}
+		result, next, err := ApplyCursorToUsers(request, index)
+		require.NoError(t, err)
+
+		ids := []int{}
+		for _, user := range result {
+			ids = append(ids, user.ID)
+		}
+		return ids, next
+	}
+
+	ids, next := page(NewUserIndex(users), FirstPageCursor)
+	assert.Equal(t, []int{9, 8, 7}, ids)
+
+	// между страницами удалили последнего отданного и добавили пользователей в начало и в конец
+	changed := append([]UserXMLData{{ID: 100}, {ID: -1}}, users[:7]...)
+	ids, next = page(NewUserIndex(changed), next)
+	assert.Equal(t, []int{6, 5, 4}, ids)
+
+	ids, next = page(NewUserIndex(changed), next)
+	assert.Equal(t, []int{3, 2, 1}, ids)
+
+	ids, next = page(NewUserIndex(changed), next)
+	assert.Equal(t, []int{0, -1}, ids)
+	assert.Empty(t, next)
+}
+
+func TestSearchServerBadCursor(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "validToken", URL: ts.URL}
+
+	first, err := client.FindUsers(SearchRequest{Limit: 2, Cursor: FirstPageCursor})
+	require.NoError(t, err)
+	require.True(t, first.NextPage)
+	require.NotEmpty(t, first.NextCursor)
+
+	sealed, err := base64.RawURLEncoding.DecodeString(first.NextCursor)
+	require.NoError(t, err)
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)/2] ^= 1
+
+	forged, err := sealJSON([]byte("другой ключ"), cursorState{Query: "gender:male"})
+	require.NoError(t, err)
+
+	for _, cursor := range []string{
+		"garbage",
+		first.NextCursor[:len(first.NextCursor)-4],
+		base64.RawURLEncoding.EncodeToString(flipped),
+		forged,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?cursor="+url.QueryEscape(cursor), nil)
+		req.Header.Set("AccessToken", "validToken")
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, cursor)
+		assert.Contains(t, w.Body.String(), ErrorBadCursor, cursor)
+		assert.Empty(t, w.Header().Get(NextCursorHeader), cursor)
+	}
+
+	_, err = client.FindUsers(SearchRequest{Limit: 2, Cursor: "garbage"})
+	require.Error(t, err)
+	assert.Equal(t, "cursor invalid: "+errBadCursor.Error(), err.Error())
+
+	second, err := client.FindUsers(SearchRequest{Limit: 2, Cursor: first.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, second.Users, 2)
+	assert.NotEqual(t, first.Users, second.Users)
+}
+
+// курсор помнит ключи сортировки, в том числе скрытых полей, но прочитать их из него нельзя
+func TestCursorHidesSortKeys(t *testing.T) {
+	index, err := LoadUserIndex("dataset.xml")
+	require.NoError(t, err)
+
+	request := &SearchRequest{Limit: 2, OrderField: "Company, Email, IsActive desc, Registered", Cursor: FirstPageCursor}
+	page, next, err := ApplyCursorToUsers(request, index)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.NotEmpty(t, next)
+
+	last := page[1]
+	state, err := decodeCursor(next)
+	require.NoError(t, err)
+	require.NotNil(t, state.Last)
+	assert.Equal(t, last.Email, state.Last.Email)
+
+	sealed, err := base64.RawURLEncoding.DecodeString(next)
+	require.NoError(t, err)
+	for _, hidden := range []string{last.Email, last.Company, last.Registered, `"e"`, `"ia"`, "Company"} {
+		assert.NotContains(t, string(sealed), hidden)
+		assert.NotContains(t, next, hidden)
+	}
+}
+
+func TestSearchServerNegativeLimit(t *testing.T) {
+	// limit=0 без курсора - пустая страница, с курсором - ошибка: обход бы на ней оборвался
+	for _, params := range []string{"limit=-5", "limit=-5&cursor=first", "offset=-3", "offset=-3&limit=-1", "limit=0&cursor=first"} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+params, nil)
+		req.Header.Set("AccessToken", "validToken")
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, params)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?limit=-1&cursor=first", nil)
+	req.Header.Set("AccessToken", "validToken")
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// с общим ключом курсор, выданный одним сервером, подходит другому
+func TestLoadCursorSecret(t *testing.T) {
+	defer func(secret []byte) { CursorSecret = secret }(CursorSecret)
+
+	secretFileName := filepath.Join(t.TempDir(), "cursor.key")
+	require.NoError(t, os.WriteFile(secretFileName, []byte("0123456789abcdef\n"), 0o600))
+
+	secret, err := LoadCursorSecret(secretFileName)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef"), secret)
+
+	CursorSecret = secret
+	cursor, err := encodeCursor(cursorState{Query: "gender:male"})
+	require.NoError(t, err)
+
+	CursorSecret = newCursorSecret()
+	_, err = decodeCursor(cursor)
+	assert.ErrorIs(t, err, errBadCursor)
+
+	CursorSecret, err = LoadCursorSecret(secretFileName)
+	require.NoError(t, err)
+	state, err := decodeCursor(cursor)
+	require.NoError(t, err)
+	assert.Equal(t, "gender:male", state.Query)
+
+	require.NoError(t, os.WriteFile(secretFileName, []byte("short\n"), 0o600))
+	_, err = LoadCursorSecret(secretFileName)
+	assert.Error(t, err)
+	_, err = LoadCursorSecret(secretFileName + ".missing")
+	assert.Error(t, err)
+}
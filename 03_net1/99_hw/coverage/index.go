@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// UserIndex - пользователи из файла и обратный индекс по словам имени и about.
+// Поиск без учёта регистра, в том числе не латиницы: запрос ищется подстрокой,
+// как раньше, но кандидатов дают слова индекса, а не перебор всех пользователей
+type UserIndex struct {
+	users []UserXMLData
+
+	// имя и about в свёрнутом регистре, по ним проверяется совпадение
+	names  []string
+	abouts []string
+	// registered, разобранное заранее; нулевое, если разобрать не удалось
+	registered []time.Time
+
+	// слово -> номера пользователей по возрастанию
+	postings map[string][]int
+	words    []string
+}
+
+func NewUserIndex(users []UserXMLData) *UserIndex {
+	index := &UserIndex{
+		users:      users,
+		names:      make([]string, len(users)),
+		abouts:     make([]string, len(users)),
+		registered: make([]time.Time, len(users)),
+		postings:   map[string][]int{},
+	}
+
+	for i, user := range users {
+		index.names[i] = foldCase(user.FirstName + " " + user.LastName)
+		index.abouts[i] = foldCase(user.About)
+		if registered, err := time.Parse(registeredLayout, user.Registered); err == nil {
+			index.registered[i] = registered
+		}
+
+		for _, text := range []string{index.names[i], index.abouts[i]} {
+			for _, word := range splitWords(text) {
+				postings := index.postings[word]
+				if len(postings) > 0 && postings[len(postings)-1] == i {
+					continue
+				}
+				index.postings[word] = append(postings, i)
+			}
+		}
+	}
+
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+
+	return index
+}
+
+// LoadUserIndex читает пользователей из XML-файла и строит по ним индекс
+func LoadUserIndex(fileName string) (*UserIndex, error) {
+	xmlData, err := GetFileData(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	users := new(Users)
+	err = xml.Unmarshal(xmlData, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUserIndex(users.UserList), nil
+}
+
+// Search возвращает пользователей, подходящих под query (синтаксис - в query.go),
+// в порядке файла. Результат - новый слайс, его можно сортировать
+func (index *UserIndex) Search(query string) ([]UserXMLData, error) {
+	node, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return append([]UserXMLData{}, index.users...), nil
+	}
+
+	result := []UserXMLData{}
+	for i, ok := range node.eval(index) {
+		if ok {
+			result = append(result, index.users[i])
+		}
+	}
+
+	return result, nil
+}
+
+// candidates - номера пользователей по возрастанию, у которых может найтись подстрока query.
+// Самое длинное слово запроса целиком лежит внутри одного слова текста,
+// поэтому достаточно взять слова индекса, в которых оно встречается
+func (index *UserIndex) candidates(query string) []int {
+	longest := ""
+	for _, word := range splitWords(query) {
+		if len(word) > len(longest) {
+			longest = word
+		}
+	}
+
+	// в запросе одни пробелы и знаки - проверяем всех
+	if longest == "" {
+		all := make([]int, len(index.users))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	marked := make([]bool, len(index.users))
+	for _, word := range index.words {
+		if !strings.Contains(word, longest) {
+			continue
+		}
+		for _, i := range index.postings[word] {
+			marked[i] = true
+		}
+	}
+
+	result := []int{}
+	for i, ok := range marked {
+		if ok {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+// foldCase приводит регистр к одному виду для любых алфавитов:
+// каждая буква заменяется наименьшей из своих вариантов (например, Σ, σ и ς)
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for fold := unicode.SimpleFold(r); fold != r; fold = unicode.SimpleFold(fold) {
+			if fold < smallest {
+				smallest = fold
+			}
+		}
+		return smallest
+	}, s)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// indexCache - индекс последнего прочитанного файла. Перед каждым запросом
+// сверяет время изменения и размер файла и перечитывает его, если файл поменялся.
+// Если перечитать не удалось (файл, например, дописывается), ошибка пишется в лог,
+// а запросы получают прежний индекс, пока файл не поменяется снова
+type indexCache struct {
+	mu       sync.RWMutex
+	fileName string
+	modTime  time.Time
+	size     int64
+	index    *UserIndex
+}
+
+var searchIndex = &indexCache{}
+
+func (cache *indexCache) get(fileName string) (*UserIndex, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return cache.fallback(fileName, err)
+	}
+
+	fresh := func() bool {
+		return cache.index != nil && cache.fileName == fileName &&
+			cache.modTime.Equal(info.ModTime()) && cache.size == info.Size()
+	}
+
+	cache.mu.RLock()
+	if fresh() {
+		index := cache.index
+		cache.mu.RUnlock()
+		return index, nil
+	}
+	cache.mu.RUnlock()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// пока ждали блокировку, файл мог перечитать другой запрос
+	if fresh() {
+		return cache.index, nil
+	}
+
+	index, err := LoadUserIndex(fileName)
+	if err != nil {
+		if cache.index == nil || cache.fileName != fileName {
+			return nil, err
+		}
+
+		// сломанный файл не перечитывается на каждый запрос, только после следующего изменения
+		log.Printf("не удалось перечитать %s, используется прежний индекс: %v", fileName, err)
+		cache.modTime = info.ModTime()
+		cache.size = info.Size()
+		return cache.index, nil
+	}
+
+	cache.fileName = fileName
+	cache.modTime = info.ModTime()
+	cache.size = info.Size()
+	cache.index = index
+
+	return index, nil
+}
+
+// fallback - прежний индекс того же файла, если он есть, когда файл не удалось даже открыть
+func (cache *indexCache) fallback(fileName string, err error) (*UserIndex, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	if cache.index == nil || cache.fileName != fileName {
+		return nil, err
+	}
+
+	log.Printf("не удалось перечитать %s, используется прежний индекс: %v", fileName, err)
+	return cache.index, nil
+}
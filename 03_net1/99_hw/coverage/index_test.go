@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserIndexSearch(t *testing.T) {
+	index := NewUserIndex([]UserXMLData{
+		{ID: 0, FirstName: "Boyd", LastName: "Wolf", About: "Nulla cillum enim"},
+		{ID: 1, FirstName: "Андрей", LastName: "Смирнов", About: "Пишет на Go"},
+		{ID: 2, FirstName: "Οδυσσέας", LastName: "Ελύτης", About: "ΠΟΙΗΤΗΣ"},
+		{ID: 3, FirstName: "Hilda", LastName: "Mayer", About: "Non nulla, go!"},
+	})
+
+	ids := func(users []UserXMLData) []int {
+		result := []int{}
+		for _, user := range users {
+			result = append(result, user.ID)
+		}
+		return result
+	}
+
+	cases := map[string][]int{
+		"":            {0, 1, 2, 3},
+		"NULLA":       {0, 3},
+		"ull":         {0, 3},
+		"boyd wolf":   {0},
+		"d W":         {0},
+		"андрей":      {1},
+		"НА GO":       {1},
+		"go":          {1, 3},
+		"ποιητης":     {2},
+		"Οδυσσεας":    {},
+		"ΟΔΥΣΣΈΑΣ":    {2},
+		"la, go":      {3},
+		", ":          {3},
+		"нет такого":  {},
+		"Wolf Андрей": {},
+	}
+
+	for query, expected := range cases {
+		users, err := index.Search(query)
+		require.NoError(t, err, query)
+		assert.Equal(t, expected, ids(users), query)
+	}
+}
+
+// на настоящих данных индекс находит то же, что перебор всех пользователей
+func TestUserIndexSameAsScan(t *testing.T) {
+	index, err := LoadUserIndex("dataset.xml")
+	require.NoError(t, err)
+
+	for _, query := range []string{"on", "On", "ON", "Lorem", "et e", "minim.", "Hilda", "a", " ", "zzz"} {
+		expected := []UserXMLData{}
+		for _, user := range index.users {
+			name := strings.ToLower(user.FirstName + " " + user.LastName)
+			if strings.Contains(name, strings.ToLower(query)) || strings.Contains(strings.ToLower(user.About), strings.ToLower(query)) {
+				expected = append(expected, user)
+			}
+		}
+
+		users, err := index.Search(query)
+		require.NoError(t, err, query)
+		assert.Equal(t, expected, users, query)
+	}
+}
+
+func writeDataset(t testing.TB, fileName string, users []UserXMLData) {
+	t.Helper()
+
+	data, err := xml.Marshal(Users{UserList: users})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, data, 0o600))
+}
+
+func TestSearchIndexHotReload(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dataset.xml")
+	writeDataset(t, fileName, []UserXMLData{{ID: 1, FirstName: "Boyd", LastName: "Wolf"}})
+
+	cache := &indexCache{}
+	index, err := cache.get(fileName)
+	require.NoError(t, err)
+	users, err := index.Search("wolf")
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	again, err := cache.get(fileName)
+	require.NoError(t, err)
+	assert.Same(t, index, again)
+
+	writeDataset(t, fileName, []UserXMLData{
+		{ID: 1, FirstName: "Boyd", LastName: "Wolf"},
+		{ID: 2, FirstName: "Hilda", LastName: "Wolfe"},
+	})
+	// время изменения могло не сдвинуться, но размер другой
+	reloaded, err := cache.get(fileName)
+	require.NoError(t, err)
+	users, err = reloaded.Search("wolf")
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	// сломанный или пропавший файл - прежний индекс, а не ошибка
+	require.NoError(t, os.WriteFile(fileName, []byte("\n"), 0o600))
+	broken, err := cache.get(fileName)
+	require.NoError(t, err)
+	assert.Same(t, reloaded, broken)
+
+	require.NoError(t, os.Remove(fileName))
+	missing, err := cache.get(fileName)
+	require.NoError(t, err)
+	assert.Same(t, reloaded, missing)
+
+	// после исправления файл перечитывается
+	writeDataset(t, fileName, []UserXMLData{{ID: 3, FirstName: "Wolf"}})
+	fixed, err := cache.get(fileName)
+	require.NoError(t, err)
+	users, err = fixed.Search("wolf")
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	// прежнего индекса нет - ошибка
+	require.NoError(t, os.WriteFile(fileName, []byte("\n"), 0o600))
+	_, err = (&indexCache{}).get(fileName)
+	assert.Error(t, err)
+}
+
+func generateUsers(n int) []UserXMLData {
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipisicing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+	random := rand.New(rand.NewSource(1))
+
+	users := make([]UserXMLData, n)
+	for i := range users {
+		about := make([]string, 30)
+		for j := range about {
+			about[j] = words[random.Intn(len(words))]
+		}
+
+		users[i] = UserXMLData{
+			ID:        i,
+			FirstName: fmt.Sprintf("Name%d", i),
+			LastName:  fmt.Sprintf("Surname%d", random.Intn(n)),
+			Age:       18 + random.Intn(50),
+			About:     strings.Join(about, " "),
+			Gender:    "female",
+		}
+	}
+
+	return users
+}
+
+// searchByReparse - поиск, как он был до индекса: файл читается и разбирается на каждый запрос
+func searchByReparse(fileName, query string) ([]UserXMLData, error) {
+	xmlData, err := GetFileData(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	users := new(Users)
+	if err := xml.Unmarshal(xmlData, &users); err != nil {
+		return nil, err
+	}
+
+	result := []UserXMLData{}
+	for _, user := range users.UserList {
+		if strings.Contains(user.FirstName+" "+user.LastName, query) || strings.Contains(user.About, query) {
+			result = append(result, user)
+		}
+	}
+
+	return result, nil
+}
+
+// сравниваются только поиски, без HTTP: перебор с разбором файла и индекс из кеша,
+// который на каждый запрос сверяет файл
+// go test -bench Search -benchmem
+func BenchmarkSearch(b *testing.B) {
+	fileName := filepath.Join(b.TempDir(), "dataset.xml")
+	writeDataset(b, fileName, generateUsers(10000))
+
+	b.Run("reparse", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := searchByReparse(fileName, "Surname42"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		cache := &indexCache{}
+
+		start := time.Now()
+		if _, err := cache.get(fileName); err != nil {
+			b.Fatal(err)
+		}
+		b.Logf("построение индекса: %s", time.Since(start))
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			index, err := cache.get(fileName)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := index.Search("Surname42"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
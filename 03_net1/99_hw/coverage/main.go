@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Команды:
+//
+//	go run . issue -secret secret.key -subject reports -ttl 24h -scope fields:Id,Name -scope limit:10
+//	go run . serve -addr :8080 -tokens tokens.txt -secret secret.key -cursor-secret cursor.key
+
+// коды выхода
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "ожидается команда: issue или serve")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "issue":
+		return runIssue(args[1:], stdout, stderr)
+	case "serve":
+		return runServe(args[1:], stderr)
+	}
+
+	fmt.Fprintf(stderr, "неизвестная команда %q, ожидается issue или serve\n", args[0])
+	return exitUsage
+}
+
+// scopeList - флаг, который можно указать несколько раз
+type scopeList []string
+
+func (scopes *scopeList) String() string {
+	return strings.Join(*scopes, " ")
+}
+
+func (scopes *scopeList) Set(scope string) error {
+	*scopes = append(*scopes, scope)
+	return nil
+}
+
+// runIssue выпускает подписанный токен и пишет его в stdout
+func runIssue(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("issue", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	secretFileName := flags.String("secret", "", "файл с ключом подписи")
+	subject := flags.String("subject", "", "кому выдаётся токен")
+	ttl := flags.Duration("ttl", 24*time.Hour, "срок действия")
+	scopes := scopeList{}
+	flags.Var(&scopes, "scope", "право: fields:Id,Name или limit:10; можно указать несколько раз")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *secretFileName == "" || *subject == "" {
+		fmt.Fprintln(stderr, "нужно указать secret и subject")
+		return exitUsage
+	}
+
+	auth, err := LoadHMACAuth(*secretFileName)
+	if err != nil {
+		fmt.Fprintln(stderr, "не удалось прочитать ключ:", err)
+		return exitFailure
+	}
+
+	token, err := auth.Issue(*subject, *ttl, scopes...)
+	if err != nil {
+		fmt.Fprintln(stderr, "не удалось выпустить токен:", err)
+		return exitUsage
+	}
+
+	fmt.Fprintln(stdout, token)
+	return exitOK
+}
+
+// runServe поднимает SearchServer с токенами из файла и/или подписанными
+func runServe(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	addr := flags.String("addr", ":8080", "адрес сервера")
+	tokensFileName := flags.String("tokens", "", "файл со статическими токенами")
+	secretFileName := flags.String("secret", "", "файл с ключом подписи токенов")
+	cursorSecretFileName := flags.String("cursor-secret", "", "файл с ключом курсоров, общий для всех экземпляров; без него ключ случайный и курсоры не переживают перезапуск")
+	flags.StringVar(&DataFileName, "data", DataFileName, "файл с пользователями")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *tokensFileName == "" && *secretFileName == "" {
+		fmt.Fprintln(stderr, "нужно указать tokens или secret")
+		return exitUsage
+	}
+
+	auth := Authenticators{}
+	if *tokensFileName != "" {
+		tokens, err := LoadTokenFile(*tokensFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось прочитать токены:", err)
+			return exitFailure
+		}
+		auth = append(auth, tokens)
+	}
+	if *secretFileName != "" {
+		hmacAuth, err := LoadHMACAuth(*secretFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось прочитать ключ:", err)
+			return exitFailure
+		}
+		auth = append(auth, hmacAuth)
+	}
+	if *cursorSecretFileName != "" {
+		secret, err := LoadCursorSecret(*cursorSecretFileName)
+		if err != nil {
+			fmt.Fprintln(stderr, "не удалось прочитать ключ курсоров:", err)
+			return exitFailure
+		}
+		CursorSecret = secret
+	}
+	SearchAuth = auth
+
+	if err := http.ListenAndServe(*addr, http.HandlerFunc(SearchServer)); err != nil {
+		fmt.Fprintln(stderr, "сервер остановился:", err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Сортировка задаётся в order_field списком полей через запятую, у каждого может быть
+// своё направление: "Age desc, Name asc". Поле без направления сортируется по order_by,
+// а если и он 0 - по возрастанию. Без направлений и с order_by 0 порядок остаётся как есть
+
+const (
+	FirstName = "FirstName"
+	LastName  = "LastName"
+)
+
+// OrderKey - поле сортировки и направление, OrderByAsc или OrderByDesc
+type OrderKey struct {
+	Field     string
+	Direction int
+}
+
+// OrderError - ошибка в order_field, Field - поле, с которым что-то не так
+type OrderError struct {
+	Field   string
+	Message string
+}
+
+func (orderErr *OrderError) Error() string {
+	return fmt.Sprintf("order_field: поле %q: %s", orderErr.Field, orderErr.Message)
+}
+
+// orderFields - поля, по которым можно сортировать; имена без учёта регистра
+var orderFields = map[string]struct {
+	name    string
+	compare func(a, b *UserXMLData) int
+}{
+	"id":         {ID, func(a, b *UserXMLData) int { return cmp.Compare(a.ID, b.ID) }},
+	"name":       {Name, compareName},
+	"firstname":  {FirstName, func(a, b *UserXMLData) int { return strings.Compare(a.FirstName, b.FirstName) }},
+	"lastname":   {LastName, func(a, b *UserXMLData) int { return strings.Compare(a.LastName, b.LastName) }},
+	"age":        {Age, func(a, b *UserXMLData) int { return cmp.Compare(a.Age, b.Age) }},
+	"about":      {About, func(a, b *UserXMLData) int { return strings.Compare(a.About, b.About) }},
+	"gender":     {Gender, func(a, b *UserXMLData) int { return strings.Compare(a.Gender, b.Gender) }},
+	"company":    {Company, func(a, b *UserXMLData) int { return strings.Compare(a.Company, b.Company) }},
+	"email":      {Email, func(a, b *UserXMLData) int { return strings.Compare(a.Email, b.Email) }},
+	"isactive":   {IsActive, compareActive},
+	"registered": {Registered, func(a, b *UserXMLData) int { return registeredTime(a).Compare(registeredTime(b)) }},
+}
+
+func compareName(a, b *UserXMLData) int {
+	return strings.Compare(a.FirstName+" "+a.LastName, b.FirstName+" "+b.LastName)
+}
+
+func compareActive(a, b *UserXMLData) int {
+	switch {
+	case a.IsActive == b.IsActive:
+		return 0
+	case b.IsActive:
+		return -1
+	}
+	return 1
+}
+
+// registeredTime - момент регистрации; неразобранные идут раньше всех
+func registeredTime(user *UserXMLData) time.Time {
+	registered, err := time.Parse(registeredLayout, user.Registered)
+	if err != nil {
+		return time.Time{}
+	}
+	return registered
+}
+
+// ParseOrder разбирает order_field; orderBy - направление для полей без своего
+func ParseOrder(orderField string, orderBy int) ([]OrderKey, error) {
+	keys := []OrderKey{}
+	explicit := false
+	seen := map[string]bool{}
+
+	for _, item := range strings.Split(orderField, ",") {
+		words := strings.Fields(item)
+		if len(words) == 0 {
+			return nil, &OrderError{Field: item, Message: "пустое поле в списке"}
+		}
+
+		field, ok := orderFields[strings.ToLower(words[0])]
+		if !ok {
+			return nil, &OrderError{Field: words[0], Message: "по этому полю нельзя сортировать"}
+		}
+		if seen[field.name] {
+			return nil, &OrderError{Field: words[0], Message: "поле указано дважды"}
+		}
+		seen[field.name] = true
+
+		key := OrderKey{Field: field.name, Direction: orderBy}
+		if key.Direction == OrderByAsIs {
+			key.Direction = OrderByAsc
+		}
+
+		switch {
+		case len(words) == 1:
+		case len(words) == 2 && strings.EqualFold(words[1], "asc"):
+			key.Direction = OrderByAsc
+			explicit = true
+		case len(words) == 2 && strings.EqualFold(words[1], "desc"):
+			key.Direction = OrderByDesc
+			explicit = true
+		default:
+			return nil, &OrderError{Field: words[0], Message: fmt.Sprintf("направление должно быть asc или desc, а не %q", strings.Join(words[1:], " "))}
+		}
+
+		keys = append(keys, key)
+	}
+
+	if orderBy == OrderByAsIs && !explicit {
+		return nil, nil
+	}
+
+	return keys, nil
+}
+
+// OrderUsers сортирует по ключам по очереди: следующий ключ решает, только если предыдущие равны
+func OrderUsers(users *[]UserXMLData, order []OrderKey) {
+	if len(order) == 0 {
+		return
+	}
+
+	compare := compareUsers(order)
+	slices.SortFunc(*users, func(a, b UserXMLData) int {
+		return compare(&a, &b)
+	})
+}
+
+// compareUsers - сравнение пользователей в порядке order
+func compareUsers(order []OrderKey) func(a, b *UserXMLData) int {
+	compares := make([]func(a, b *UserXMLData) int, len(order))
+	for i, key := range order {
+		compares[i] = orderFields[strings.ToLower(key.Field)].compare
+	}
+
+	return func(a, b *UserXMLData) int {
+		for i, key := range order {
+			result := compares[i](a, b)
+			if key.Direction == OrderByDesc {
+				result = -result
+			}
+			if result != 0 {
+				return result
+			}
+		}
+		return 0
+	}
+}
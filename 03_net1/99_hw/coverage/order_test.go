@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrder(t *testing.T) {
+	cases := []struct {
+		orderField string
+		orderBy    int
+		keys       []OrderKey
+	}{
+		{"Name", OrderByAsIs, nil},
+		{"Age", OrderByDesc, []OrderKey{{Age, OrderByDesc}}},
+		{"Age desc, Name asc", OrderByAsIs, []OrderKey{{Age, OrderByDesc}, {Name, OrderByAsc}}},
+		{"age DESC,isactive", OrderByAsIs, []OrderKey{{Age, OrderByDesc}, {IsActive, OrderByAsc}}},
+		{"registered asc, Id", OrderByDesc, []OrderKey{{Registered, OrderByAsc}, {ID, OrderByDesc}}},
+	}
+
+	for _, c := range cases {
+		keys, err := ParseOrder(c.orderField, c.orderBy)
+		require.NoError(t, err, c.orderField)
+		assert.Equal(t, c.keys, keys, c.orderField)
+	}
+
+	errorCases := map[string]OrderError{
+		"Salary":        {Field: "Salary", Message: "по этому полю нельзя сортировать"},
+		"Age, Salary":   {Field: "Salary", Message: "по этому полю нельзя сортировать"},
+		"Age, age desc": {Field: "age", Message: "поле указано дважды"},
+		"Age up":        {Field: "Age", Message: `направление должно быть asc или desc, а не "up"`},
+		"Age desc asc":  {Field: "Age", Message: `направление должно быть asc или desc, а не "desc asc"`},
+		"Age,,Name":     {Field: "", Message: "пустое поле в списке"},
+	}
+
+	for orderField, expected := range errorCases {
+		_, err := ParseOrder(orderField, OrderByAsc)
+
+		var orderErr *OrderError
+		require.True(t, errors.As(err, &orderErr), "%s: %v", orderField, err)
+		assert.Equal(t, expected, *orderErr, orderField)
+	}
+}
+
+func TestOrderUsers(t *testing.T) {
+	users, err := queryTestIndex().Search("")
+	require.NoError(t, err)
+
+	ids := func(order string) []int {
+		keys, err := ParseOrder(order, OrderByAsIs)
+		require.NoError(t, err)
+
+		OrderUsers(&users, keys)
+
+		result := []int{}
+		for _, user := range users {
+			result = append(result, user.ID)
+		}
+		return result
+	}
+
+	assert.Equal(t, []int{1, 0, 2, 3}, ids("Gender asc, Id"))
+	assert.Equal(t, []int{3, 2, 0, 1}, ids("Gender desc, Id desc"))
+	assert.Equal(t, []int{3, 0, 1, 2}, ids("IsActive, Company desc"))
+	assert.Equal(t, []int{3, 1, 2, 0}, ids("Registered asc"))
+	assert.Equal(t, []int{2, 3, 1, 0}, ids("LastName asc"))
+	assert.Equal(t, []int{1, 3, 2, 0}, ids("Email desc"))
+}
+
+func TestSearchServerMultiOrder(t *testing.T) {
+	search := func(orderField string) *httptest.ResponseRecorder {
+		params := url.Values{}
+		params.Add("limit", "3")
+		params.Add("order_field", orderField)
+
+		req := httptest.NewRequest(http.MethodGet, "/?"+params.Encode(), nil)
+		req.Header.Set("AccessToken", "validToken")
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+		return w
+	}
+
+	w := search("Age desc, Name asc")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	users := []User{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	require.Len(t, users, 3)
+	assert.Equal(t, []int{32, 13, 6}, []int{users[0].ID, users[1].ID, users[2].ID})
+
+	w = search("Age desc, Id asc")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	assert.Equal(t, []int{13, 32, 6}, []int{users[0].ID, users[1].ID, users[2].ID})
+
+	w = search("Age desc, Salary")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	errResp := SearchErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, SearchErrorResponse{Error: ErrorBadOrderField, Field: "Salary", Message: "по этому полю нельзя сортировать"}, errResp)
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "validToken", URL: ts.URL}
+	_, err := client.FindUsers(SearchRequest{Limit: 5, OrderField: "Age desc, Salary"})
+	require.Error(t, err)
+	assert.Equal(t, "OrderFeld Salary invalid", err.Error())
+}
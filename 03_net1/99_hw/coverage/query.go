@@ -0,0 +1,635 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Синтаксис query:
+//
+//	слово              подстрока в имени или about, без учёта регистра
+//	"фраза с пробелом" то же для фразы целиком
+//	лор*               слово имени или about, начинающееся с "лор"
+//	"big co"*          то же для фразы: с начала слова идёт "big co", например "big company"
+//	поле:значение      фильтр по полю, см. queryFields; значение можно взять в кавычки
+//	age:>30            сравнения >, >=, <, <=, = для id, age и registered
+//	a AND b, a OR b, NOT a, скобки; пробел между условиями - то же, что AND
+//
+// NOT сильнее AND, AND сильнее OR. Внутри кавычек \" и \\ - кавычка и обратный слэш.
+//
+// Запрос из одних слов, без кавычек, скобок, полей, звёздочек и AND/OR/NOT, ищется целиком
+// как одна подстрока, как до появления языка запросов: Boyd Wolf - это "Boyd Wolf", а не Boyd AND Wolf
+
+const (
+	registeredLayout = "2006-01-02T15:04:05 -07:00"
+	dateLayout       = "2006-01-02"
+)
+
+// QueryError - ошибка в query: где она и к какому полю относится
+type QueryError struct {
+	// номер символа, с 1
+	Position int
+	Field    string
+	Message  string
+}
+
+func (queryErr *QueryError) Error() string {
+	if queryErr.Field != "" {
+		return fmt.Sprintf("query: позиция %d, поле %s: %s", queryErr.Position, queryErr.Field, queryErr.Message)
+	}
+	return fmt.Sprintf("query: позиция %d: %s", queryErr.Position, queryErr.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenLParen
+	tokenRParen
+	tokenWord
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type queryToken struct {
+	kind  tokenKind
+	pos   int
+	field string
+	text  string
+	// фраза в кавычках со звёздочкой сразу после: "big co"*
+	prefix bool
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	tokens := []queryToken{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenLParen, pos: i + 1})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenRParen, pos: i + 1})
+			i++
+
+		case r == '"':
+			token, next, err := lexPhrase(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			i = next
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()"`, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokenAnd, pos: start + 1})
+				continue
+			case "OR":
+				tokens = append(tokens, queryToken{kind: tokenOr, pos: start + 1})
+				continue
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokenNot, pos: start + 1})
+				continue
+			}
+
+			field, value, isField := strings.Cut(word, ":")
+			if !isField {
+				tokens = append(tokens, queryToken{kind: tokenWord, pos: start + 1, text: word})
+				continue
+			}
+			if field == "" {
+				return nil, &QueryError{Position: start + 1, Message: "не указано поле перед :"}
+			}
+
+			// поле:"значение в кавычках"
+			if value == "" && i < len(runes) && runes[i] == '"' {
+				token, next, err := lexPhrase(runes, i)
+				if err != nil {
+					return nil, err
+				}
+				token.pos = start + 1
+				token.field = field
+				tokens = append(tokens, token)
+				i = next
+				continue
+			}
+			if value == "" {
+				return nil, &QueryError{Position: start + 1, Field: field, Message: "пустое значение"}
+			}
+
+			tokens = append(tokens, queryToken{kind: tokenWord, pos: start + 1, field: field, text: value})
+		}
+	}
+
+	return append(tokens, queryToken{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+// lexPhrase читает фразу в кавычках, начиная с кавычки runes[start]
+func lexPhrase(runes []rune, start int) (queryToken, int, error) {
+	text := strings.Builder{}
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			text.WriteRune(runes[i])
+
+		case '"':
+			token := queryToken{kind: tokenPhrase, pos: start + 1, text: text.String()}
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				token.prefix = true
+				i++
+			}
+			return token, i + 1, nil
+
+		default:
+			text.WriteRune(runes[i])
+		}
+	}
+
+	return queryToken{}, 0, &QueryError{Position: start + 1, Message: "не закрыта кавычка"}
+}
+
+// ParseQuery разбирает query; для пустого запроса возвращает nil - подходят все
+func ParseQuery(query string) (queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &queryParser{tokens: tokens}
+	if parser.peek().kind == tokenEOF {
+		return nil, nil
+	}
+	if isPlainQuery(query) {
+		return textNode{text: foldCase(query)}, nil
+	}
+
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != tokenEOF {
+		return nil, &QueryError{Position: token.pos, Message: "лишняя закрывающая скобка"}
+	}
+
+	return node, nil
+}
+
+// isPlainQuery - в запросе нет ничего, кроме слов: ни кавычек, ни скобок, ни полей, ни звёздочек, ни операторов
+func isPlainQuery(query string) bool {
+	if strings.ContainsAny(query, `()":*`) {
+		return false
+	}
+
+	for _, word := range strings.Fields(query) {
+		switch word {
+		case "AND", "OR", "NOT":
+			return false
+		}
+	}
+
+	return true
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (parser *queryParser) peek() queryToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *queryParser) next() queryToken {
+	token := parser.tokens[parser.pos]
+	if token.kind != tokenEOF {
+		parser.pos++
+	}
+	return token
+}
+
+func (parser *queryParser) parseOr() (queryNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().kind == tokenOr {
+		parser.next()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (parser *queryParser) parseAnd() (queryNode, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch parser.peek().kind {
+		case tokenAnd:
+			parser.next()
+		case tokenWord, tokenPhrase, tokenNot, tokenLParen:
+		default:
+			return left, nil
+		}
+
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (parser *queryParser) parseNot() (queryNode, error) {
+	if parser.peek().kind != tokenNot {
+		return parser.parsePrimary()
+	}
+
+	parser.next()
+	node, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	return notNode{node}, nil
+}
+
+func (parser *queryParser) parsePrimary() (queryNode, error) {
+	token := parser.next()
+
+	switch token.kind {
+	case tokenLParen:
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next().kind != tokenRParen {
+			return nil, &QueryError{Position: token.pos, Message: "не закрыта скобка"}
+		}
+		return node, nil
+
+	case tokenWord, tokenPhrase:
+		return compileTerm(token)
+
+	case tokenRParen:
+		return nil, &QueryError{Position: token.pos, Message: "лишняя закрывающая скобка"}
+
+	case tokenAnd:
+		return nil, &QueryError{Position: token.pos, Message: "нет условия перед AND"}
+
+	case tokenOr:
+		return nil, &QueryError{Position: token.pos, Message: "нет условия перед OR"}
+	}
+
+	return nil, &QueryError{Position: token.pos, Message: "запрос оборвался, ожидается условие"}
+}
+
+// compileTerm превращает слово, фразу или фильтр по полю в условие
+func compileTerm(token queryToken) (queryNode, error) {
+	if token.field == "" {
+		if token.kind == tokenPhrase {
+			if token.prefix {
+				return prefixNode{prefix: foldCase(token.text)}, nil
+			}
+			return textNode{text: foldCase(token.text)}, nil
+		}
+
+		prefix, isPrefix, err := wildcard(token)
+		if err != nil {
+			return nil, err
+		}
+		if isPrefix {
+			return prefixNode{prefix: foldCase(prefix)}, nil
+		}
+		return textNode{text: foldCase(token.text)}, nil
+	}
+
+	field, ok := queryFields[strings.ToLower(token.field)]
+	if !ok {
+		return nil, &QueryError{Position: token.pos, Field: token.field, Message: "неизвестное поле"}
+	}
+
+	node, err := field(token)
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &QueryError{Position: token.pos, Field: token.field, Message: err.Error()}
+	}
+
+	return node, nil
+}
+
+// wildcard отрезает звёздочку в конце слова; в других местах она запрещена
+func wildcard(token queryToken) (string, bool, error) {
+	if token.kind == tokenPhrase {
+		return token.text, token.prefix, nil
+	}
+
+	star := strings.IndexRune(token.text, '*')
+	switch {
+	case star == -1:
+		return token.text, false, nil
+	case star != len(token.text)-1:
+		return "", false, &QueryError{Position: token.pos, Field: token.field, Message: "* можно ставить только в конце слова"}
+	case star == 0:
+		return "", false, &QueryError{Position: token.pos, Field: token.field, Message: "перед * нужна хотя бы одна буква"}
+	}
+
+	return token.text[:star], true, nil
+}
+
+// queryFields - поля, по которым можно фильтровать; имена без учёта регистра
+var queryFields = map[string]func(token queryToken) (queryNode, error){
+	"id":         numberField(func(user *UserXMLData) int { return user.ID }),
+	"age":        numberField(func(user *UserXMLData) int { return user.Age }),
+	"name":       textField(func(index *UserIndex, i int) string { return index.names[i] }),
+	"about":      textField(func(index *UserIndex, i int) string { return index.abouts[i] }),
+	"gender":     exactField(func(user *UserXMLData) string { return user.Gender }),
+	"company":    exactField(func(user *UserXMLData) string { return user.Company }),
+	"email":      exactField(func(user *UserXMLData) string { return user.Email }),
+	"isactive":   boolField(func(user *UserXMLData) bool { return user.IsActive }),
+	"registered": dateField,
+}
+
+// comparison отделяет оператор сравнения от значения; в кавычках операторов нет
+func comparison(token queryToken) (string, string) {
+	if token.kind == tokenPhrase {
+		return "=", token.text
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if value := strings.TrimPrefix(token.text, op); value != token.text {
+			return op, value
+		}
+	}
+
+	return "=", token.text
+}
+
+func compare(op string, result int) bool {
+	switch op {
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	}
+
+	return result == 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func numberField(get func(user *UserXMLData) int) func(token queryToken) (queryNode, error) {
+	return func(token queryToken) (queryNode, error) {
+		op, value := comparison(token)
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("ожидается число, а не %q", value)
+		}
+
+		return matchNode(func(index *UserIndex, i int) bool {
+			return compare(op, compareInts(get(&index.users[i]), number))
+		}), nil
+	}
+}
+
+// textField - подстрока или, со звёздочкой, начало слова в свёрнутом тексте поля
+func textField(get func(index *UserIndex, i int) string) func(token queryToken) (queryNode, error) {
+	return func(token queryToken) (queryNode, error) {
+		value, isPrefix, err := wildcard(token)
+		if err != nil {
+			return nil, err
+		}
+		value = foldCase(value)
+
+		if isPrefix {
+			return matchNode(func(index *UserIndex, i int) bool {
+				return hasWordPrefix(get(index, i), value)
+			}), nil
+		}
+		return matchNode(func(index *UserIndex, i int) bool {
+			return strings.Contains(get(index, i), value)
+		}), nil
+	}
+}
+
+// exactField - значение целиком или, со звёздочкой, его начало, без учёта регистра
+func exactField(get func(user *UserXMLData) string) func(token queryToken) (queryNode, error) {
+	return func(token queryToken) (queryNode, error) {
+		value, isPrefix, err := wildcard(token)
+		if err != nil {
+			return nil, err
+		}
+		value = foldCase(value)
+
+		return matchNode(func(index *UserIndex, i int) bool {
+			field := foldCase(get(&index.users[i]))
+			if isPrefix {
+				return strings.HasPrefix(field, value)
+			}
+			return field == value
+		}), nil
+	}
+}
+
+func boolField(get func(user *UserXMLData) bool) func(token queryToken) (queryNode, error) {
+	return func(token queryToken) (queryNode, error) {
+		value, err := strconv.ParseBool(token.text)
+		if err != nil {
+			return nil, fmt.Errorf("ожидается true или false, а не %q", token.text)
+		}
+
+		return matchNode(func(index *UserIndex, i int) bool {
+			return get(&index.users[i]) == value
+		}), nil
+	}
+}
+
+// dateField сравнивает registered: с датой - по дате в поясе пользователя, со временем - как моменты
+func dateField(token queryToken) (queryNode, error) {
+	op, value := comparison(token)
+
+	if date, err := time.Parse(dateLayout, value); err == nil {
+		day := date.Format(dateLayout)
+		return matchNode(func(index *UserIndex, i int) bool {
+			registered := index.registered[i]
+			return !registered.IsZero() && compare(op, strings.Compare(registered.Format(dateLayout), day))
+		}), nil
+	}
+
+	moment, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("ожидается дата %s или время в RFC 3339, а не %q", dateLayout, value)
+	}
+
+	return matchNode(func(index *UserIndex, i int) bool {
+		registered := index.registered[i]
+		if registered.IsZero() {
+			return false
+		}
+
+		result := 0
+		switch {
+		case registered.Before(moment):
+			result = -1
+		case registered.After(moment):
+			result = 1
+		}
+		return compare(op, result)
+	}), nil
+}
+
+// hasWordPrefix - prefix встречается в text с начала слова; prefix может быть из нескольких слов
+func hasWordPrefix(text, prefix string) bool {
+	for start := 0; start <= len(text); {
+		found := strings.Index(text[start:], prefix)
+		if found < 0 {
+			return false
+		}
+		found += start
+
+		before, _ := utf8.DecodeLastRuneInString(text[:found])
+		if found == 0 || !isWordRune(before) {
+			return true
+		}
+
+		_, size := utf8.DecodeRuneInString(text[found:])
+		start = found + size
+	}
+	return false
+}
+
+// queryNode - условие запроса; eval отмечает подходящих пользователей индекса
+type queryNode interface {
+	eval(index *UserIndex) []bool
+}
+
+type andNode struct {
+	left, right queryNode
+}
+
+func (node andNode) eval(index *UserIndex) []bool {
+	left, right := node.left.eval(index), node.right.eval(index)
+	for i := range left {
+		left[i] = left[i] && right[i]
+	}
+	return left
+}
+
+type orNode struct {
+	left, right queryNode
+}
+
+func (node orNode) eval(index *UserIndex) []bool {
+	left, right := node.left.eval(index), node.right.eval(index)
+	for i := range left {
+		left[i] = left[i] || right[i]
+	}
+	return left
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (node notNode) eval(index *UserIndex) []bool {
+	marked := node.node.eval(index)
+	for i := range marked {
+		marked[i] = !marked[i]
+	}
+	return marked
+}
+
+// textNode - подстрока в имени или about, кандидатов даёт индекс
+type textNode struct {
+	text string
+}
+
+func (node textNode) eval(index *UserIndex) []bool {
+	marked := make([]bool, len(index.users))
+	for _, i := range index.candidates(node.text) {
+		marked[i] = strings.Contains(index.names[i], node.text) || strings.Contains(index.abouts[i], node.text)
+	}
+	return marked
+}
+
+// prefixNode - слово имени или about с таким началом; слова индекса отсортированы,
+// поэтому подходящие идут подряд. Префикс из нескольких слов проверяется по тексту целиком
+type prefixNode struct {
+	prefix string
+}
+
+func (node prefixNode) eval(index *UserIndex) []bool {
+	marked := make([]bool, len(index.users))
+	if words := splitWords(node.prefix); len(words) != 1 || words[0] != node.prefix {
+		for _, i := range index.candidates(node.prefix) {
+			marked[i] = hasWordPrefix(index.names[i], node.prefix) || hasWordPrefix(index.abouts[i], node.prefix)
+		}
+		return marked
+	}
+
+	for i := sort.SearchStrings(index.words, node.prefix); i < len(index.words) && strings.HasPrefix(index.words[i], node.prefix); i++ {
+		for _, user := range index.postings[index.words[i]] {
+			marked[user] = true
+		}
+	}
+	return marked
+}
+
+// matchNode - фильтр, который проверяется у каждого пользователя
+type matchNode func(index *UserIndex, i int) bool
+
+func (node matchNode) eval(index *UserIndex) []bool {
+	marked := make([]bool, len(index.users))
+	for i := range marked {
+		marked[i] = node(index, i)
+	}
+	return marked
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryTestIndex() *UserIndex {
+	return NewUserIndex([]UserXMLData{
+		{ID: 0, FirstName: "Boyd", LastName: "Wolf", Age: 22, Gender: "male", About: "Nulla cillum enim", Company: "HOPELI", Email: "boydwolf@hopeli.com", Registered: "2017-02-05T06:23:27 -03:00"},
+		{ID: 1, FirstName: "Hilda", LastName: "Mayer", Age: 21, Gender: "female", About: "Sit commodo consectetur minim", Company: "QUINTITY", Email: "hildamayer@quintity.com", IsActive: true, Registered: "2016-11-20T04:40:07 -03:00"},
+		{ID: 2, FirstName: "Brooks", LastName: "Aguilar", Age: 25, Gender: "male", About: "Velit ullamco est aliqua", Company: "Big Co", Email: "brooks@big.co", IsActive: true, Registered: "2016-11-20T23:59:59 -03:00"},
+		{ID: 3, FirstName: "Everett", LastName: "Dillard", Age: 27, Gender: "male", About: `Says "hi" to commodo`, Company: "XYLAR", Email: "everett@xylar.com", Registered: "не дата"},
+	})
+}
+
+func TestQuerySearch(t *testing.T) {
+	index := queryTestIndex()
+
+	cases := map[string][]int{
+		"gender:female":                          {1},
+		"Gender:MALE":                            {0, 2, 3},
+		"age:>22":                                {2, 3},
+		"age:>=22 age:<=25":                      {0, 2},
+		"age:21 OR age:27":                       {1, 3},
+		"id:=2":                                  {2},
+		"company:HOPELI":                         {0},
+		`company:"big co"`:                       {2},
+		"company:Q*":                             {1},
+		"isActive:true":                          {1, 2},
+		"isactive:0":                             {0, 3},
+		"registered:2016-11-20":                  {1, 2},
+		"registered:<2017-01-01":                 {1, 2},
+		"registered:>2016-11-21T00:00:00Z":       {0, 2},
+		"commodo":                                {1, 3},
+		"commodo AND NOT gender:female":          {3},
+		"NOT commodo":                            {0, 2},
+		"NOT NOT commodo":                        {1, 3},
+		"com*":                                   {1, 3},
+		"con*":                                   {1},
+		`"cillum enim"`:                          {0},
+		`"cillum  enim"`:                         {},
+		`"sit com"*`:                             {1},
+		`"it com"*`:                              {},
+		`name:"boyd w"*`:                         {0},
+		"Boyd Wolf":                              {0},
+		"Wolf Boyd":                              {},
+		"Boyd AND Wolf":                          {0},
+		`"\"hi\""`:                               {3},
+		"name:wolf":                              {0},
+		"name:co*":                               {},
+		"about:co*":                              {1, 3},
+		"(gender:female OR age:>26) AND commodo": {1, 3},
+		"gender:female OR age:>26 commodo":       {1, 3},
+		"gender:male age:<25 OR isActive:true":   {0, 1, 2},
+	}
+
+	for query, expected := range cases {
+		users, err := index.Search(query)
+		require.NoError(t, err, query)
+
+		ids := []int{}
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		assert.Equal(t, expected, ids, query)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	cases := []struct {
+		query string
+		err   QueryError
+	}{
+		{`"не закрыта`, QueryError{Position: 1, Message: "не закрыта кавычка"}},
+		{"a (b OR c", QueryError{Position: 3, Message: "не закрыта скобка"}},
+		{"a b)", QueryError{Position: 4, Message: "лишняя закрывающая скобка"}},
+		{"OR a", QueryError{Position: 1, Message: "нет условия перед OR"}},
+		{"a AND", QueryError{Position: 6, Message: "запрос оборвался, ожидается условие"}},
+		{"a NOT", QueryError{Position: 6, Message: "запрос оборвался, ожидается условие"}},
+		{"a phone:123", QueryError{Position: 3, Field: "phone", Message: "неизвестное поле"}},
+		{"age:>old", QueryError{Position: 1, Field: "age", Message: `ожидается число, а не "old"`}},
+		{"gender:", QueryError{Position: 1, Field: "gender", Message: "пустое значение"}},
+		{":female", QueryError{Position: 1, Message: "не указано поле перед :"}},
+		{"a*b", QueryError{Position: 1, Message: "* можно ставить только в конце слова"}},
+		{"x *", QueryError{Position: 3, Message: "перед * нужна хотя бы одна буква"}},
+		{"company:*x", QueryError{Position: 1, Field: "company", Message: "* можно ставить только в конце слова"}},
+		{"isActive:yes", QueryError{Position: 1, Field: "isActive", Message: `ожидается true или false, а не "yes"`}},
+		{"Ёж registered:вчера", QueryError{Position: 4, Field: "registered", Message: `ожидается дата 2006-01-02 или время в RFC 3339, а не "вчера"`}},
+	}
+
+	for _, c := range cases {
+		_, err := ParseQuery(c.query)
+
+		var queryErr *QueryError
+		require.True(t, errors.As(err, &queryErr), "%s: %v", c.query, err)
+		assert.Equal(t, c.err, *queryErr, c.query)
+	}
+}
+
+func TestSearchServerBadQuery(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?query="+url.QueryEscape("gender:female age:>x"), nil)
+	req.Header.Set("AccessToken", "validToken")
+	w := httptest.NewRecorder()
+
+	SearchServer(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	errResp := SearchErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, SearchErrorResponse{Error: ErrorBadQuery, Message: `ожидается число, а не "x"`, Field: "age", Position: 15}, errResp)
+}
+
+func TestQueryBuilder(t *testing.T) {
+	registered := time.Date(2016, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]Query{
+		"commodo":                          Text("commodo"),
+		`"cillum enim"`:                    Text("cillum enim"),
+		`"OR"`:                             Text("OR"),
+		`"say \"hi\" \\o/"`:                Text(`say "hi" \o/`),
+		"com*":                             Prefix("com"),
+		`"big co"*`:                        Prefix("big co"),
+		"Gender:female":                    Field(Gender).Is("female"),
+		`Company:"big co"`:                 Field(Company).Is("big co"),
+		`Company:">x"`:                     Field(Company).Is(">x"),
+		"Email:boyd*":                      Field(Email).Prefix("boyd"),
+		"Age:>30":                          Field(Age).Gt(30),
+		"Id:<=5":                           Field(ID).Le(5),
+		"IsActive:true":                    Field(IsActive).Bool(true),
+		"Registered:=2016-11-20":           Field(Registered).On(registered),
+		"Registered:>2016-11-20T00:00:00Z": Field(Registered).After(registered),
+		"(a AND b)":                        And(Text("a"), Text("b")),
+		"a":                                And(Text("a"), nil, And()),
+		"(a OR NOT (b AND c))":             Or(Text("a"), Not(And(Text("b"), Text("c")))),
+	}
+
+	for expected, query := range cases {
+		assert.Equal(t, expected, query.String())
+
+		_, err := ParseQuery(query.String())
+		assert.NoError(t, err, expected)
+	}
+
+	req := SearchRequest{Query: "a OR b", Where: Field(Age).Gt(30)}
+	assert.Equal(t, "(a OR b) Age:>30", req.queryString())
+	assert.Equal(t, "a OR b", SearchRequest{Query: "a OR b"}.queryString())
+	assert.Equal(t, "Age:>30", SearchRequest{Where: Field(Age).Gt(30)}.queryString())
+}
+
+func TestFindUsersWhere(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "validToken", URL: ts.URL}
+
+	result, err := client.FindUsers(SearchRequest{
+		Limit: 25,
+		Where: And(Field(Company).Is("hopeli"), Field(Gender).Is("male")),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Users, 1)
+	assert.Equal(t, "Boyd Wolf", result.Users[0].Name)
+
+	// простой запрос и с Where ищется как подстрока целиком
+	req := SearchRequest{Limit: 25, Query: "yd Wo", Where: Field(Gender).Is("male")}
+	assert.Equal(t, `"yd Wo" Gender:male`, req.queryString())
+	result, err = client.FindUsers(req)
+	require.NoError(t, err)
+	require.Len(t, result.Users, 1)
+	assert.Equal(t, "Boyd Wolf", result.Users[0].Name)
+
+	_, err = client.FindUsers(SearchRequest{Limit: 25, Query: "age:>=x"})
+	var queryErr *QueryError
+	require.True(t, errors.As(err, &queryErr), "%v", err)
+	assert.Equal(t, QueryError{Position: 1, Field: "age", Message: `ожидается число, а не "x"`}, *queryErr)
+}
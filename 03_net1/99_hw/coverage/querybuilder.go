@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query - условие поиска, собранное в коде. String отдаёт его в синтаксисе query,
+// значения при этом экранируются:
+//
+//	req.Where = And(Field(Gender).Is("female"), Field(Age).Gt(30), Or(Text("New York"), Prefix("lor")))
+type Query interface {
+	String() string
+}
+
+type rawQuery string
+
+func (query rawQuery) String() string {
+	return string(query)
+}
+
+// Text - подстрока в имени или about
+func Text(text string) Query {
+	return rawQuery(quote(text))
+}
+
+// Prefix - имя или about, в котором с начала какого-то слова идёт prefix; prefix может быть из нескольких слов
+func Prefix(prefix string) Query {
+	return rawQuery(quote(prefix) + "*")
+}
+
+func And(queries ...Query) Query {
+	return join(queries, " AND ")
+}
+
+func Or(queries ...Query) Query {
+	return join(queries, " OR ")
+}
+
+func Not(query Query) Query {
+	return rawQuery("NOT " + query.String())
+}
+
+func join(queries []Query, operator string) Query {
+	parts := []string{}
+	for _, query := range queries {
+		if query != nil && query.String() != "" {
+			parts = append(parts, query.String())
+		}
+	}
+
+	if len(parts) < 2 {
+		return rawQuery(strings.Join(parts, ""))
+	}
+	return rawQuery("(" + strings.Join(parts, operator) + ")")
+}
+
+// FieldQuery - фильтры по одному полю, имя - как в константах ID, Age, Gender и т.д.
+type FieldQuery struct {
+	name string
+}
+
+func Field(name string) FieldQuery {
+	return FieldQuery{name: name}
+}
+
+// Is - значение поля целиком (gender, company, email) или подстрока (name, about)
+func (field FieldQuery) Is(value string) Query {
+	return rawQuery(field.name + ":" + quote(value))
+}
+
+// Prefix - значение поля начинается с prefix; для name и about - с начала любого слова, как Prefix
+func (field FieldQuery) Prefix(prefix string) Query {
+	return rawQuery(field.name + ":" + quote(prefix) + "*")
+}
+
+func (field FieldQuery) Bool(value bool) Query {
+	return field.compare("", strconv.FormatBool(value))
+}
+
+func (field FieldQuery) Eq(value int) Query { return field.compare("=", strconv.Itoa(value)) }
+func (field FieldQuery) Gt(value int) Query { return field.compare(">", strconv.Itoa(value)) }
+func (field FieldQuery) Ge(value int) Query { return field.compare(">=", strconv.Itoa(value)) }
+func (field FieldQuery) Lt(value int) Query { return field.compare("<", strconv.Itoa(value)) }
+func (field FieldQuery) Le(value int) Query { return field.compare("<=", strconv.Itoa(value)) }
+
+// After и Before сравнивают моменты, On - дату в поясе пользователя
+func (field FieldQuery) After(moment time.Time) Query {
+	return field.compare(">", moment.Format(time.RFC3339))
+}
+
+func (field FieldQuery) Before(moment time.Time) Query {
+	return field.compare("<", moment.Format(time.RFC3339))
+}
+
+func (field FieldQuery) On(date time.Time) Query {
+	return field.compare("=", date.Format(dateLayout))
+}
+
+func (field FieldQuery) compare(operator, value string) Query {
+	return rawQuery(field.name + ":" + operator + value)
+}
+
+// quote берёт значение в кавычки, если без них оно прочиталось бы иначе
+func quote(value string) string {
+	special := value == "" || value == "AND" || value == "OR" || value == "NOT" ||
+		strings.ContainsAny(value, ` ()":*\<>=`) || strings.IndexFunc(value, unicode.IsSpace) != -1
+	if !special {
+		return value
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// queryString - Query и Where вместе, как их получит сервер.
+// Простой запрос ищется целиком как подстрока, поэтому рядом с Where он становится фразой в кавычках,
+// а не скобками, в которых его слова искались бы по отдельности
+func (req SearchRequest) queryString() string {
+	if req.Where == nil {
+		return req.Query
+	}
+	if req.Query == "" {
+		return req.Where.String()
+	}
+	if isPlainQuery(req.Query) {
+		return quote(req.Query) + " " + req.Where.String()
+	}
+
+	return "(" + req.Query + ") " + req.Where.String()
+}
@@ -1,15 +1,11 @@
 package main
 
 import (
-	"cmp"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"net/http"
 	"os"
-	"slices"
 	"strconv"
-	"strings"
 )
 
 const (
@@ -20,6 +16,12 @@ const (
 	Age    = "Age"
 	About  = "About"
 	Gender = "Gender"
+
+	// поля, по которым можно только фильтровать в query
+	Company    = "Company"
+	Email      = "Email"
+	IsActive   = "IsActive"
+	Registered = "Registered"
 )
 
 var (
@@ -41,18 +43,25 @@ type UserXMLData struct {
 	Age       int    `xml:"age"`
 	About     string `xml:"about"`
 	Gender    string `xml:"gender"`
+	// поля ниже в ответ не попадают, по ним только фильтрует query
+	Company    string `xml:"company"`
+	Email      string `xml:"email"`
+	IsActive   bool   `xml:"isActive"`
+	Registered string `xml:"registered"`
 }
 
 func (user *UserXMLData) MarshalJSON() ([]byte, error) {
-	serializeMap := map[string]interface{}{
+	return json.Marshal(user.serializeMap())
+}
+
+func (user *UserXMLData) serializeMap() map[string]interface{} {
+	return map[string]interface{}{
 		ID:     user.ID,
 		Name:   user.FirstName + " " + user.LastName,
 		Age:    user.Age,
 		About:  user.About,
 		Gender: user.Gender,
 	}
-
-	return json.Marshal(serializeMap)
 }
 
 type Users struct {
@@ -69,62 +78,40 @@ func GetFileData(fileName string) ([]byte, error) {
 	return fContent, nil
 }
 
-func OrderUsers(users *[]UserXMLData, orderField string, orderBy int) {
-	if orderBy == OrderByAsIs {
-		return
-	}
-
-	slices.SortFunc(*users, func(a, b UserXMLData) int {
-		var result int
-		switch orderField {
-		case ID:
-			result = cmp.Compare(a.ID, b.ID)
-		case Age:
-			result = cmp.Compare(a.Age, b.Age)
-		default:
-			result = strings.Compare(a.FirstName+" "+a.LastName, b.FirstName+" "+b.LastName)
-		}
-
-		if orderBy == OrderByDesc {
-			return -result
-		}
-		return result
-	})
-}
-
-func ApplyQueryToUsers(request *SearchRequest, users *Users) []UserXMLData {
-	queryUsers := []UserXMLData{}
-
-	if request.Query != "" {
-		for _, user := range users.UserList {
-			if strings.Contains(user.FirstName+" "+user.LastName, request.Query) || strings.Contains(user.About, request.Query) {
-				queryUsers = append(queryUsers, user)
-			}
-		}
-	} else {
-		queryUsers = users.UserList
+func ApplyQueryToUsers(request *SearchRequest, index *UserIndex) ([]UserXMLData, error) {
+	queryUsers, err := index.Search(request.Query)
+	if err != nil {
+		return nil, err
 	}
 
 	if request.Limit == LimitNotSet {
 		request.Limit = len(queryUsers)
 	}
 
-	OrderUsers(&queryUsers, request.OrderField, request.OrderBy)
+	order, err := ParseOrder(request.OrderField, request.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	OrderUsers(&queryUsers, order)
 
 	if request.Offset < len(queryUsers) && request.Offset+request.Limit <= len(queryUsers) {
-		return queryUsers[request.Offset:(request.Offset + request.Limit)]
+		return queryUsers[request.Offset:(request.Offset + request.Limit)], nil
 	}
 
 	if request.Offset < len(queryUsers) {
-		return queryUsers[request.Offset:]
+		return queryUsers[request.Offset:], nil
 	}
 
-	return []UserXMLData{}
+	return []UserXMLData{}, nil
 }
 
 func SendResultToClient(w http.ResponseWriter, usersData any, test bool) {
-	_, ok := usersData.([]UserXMLData)
-	if !ok && !test {
+	switch usersData.(type) {
+	case []UserXMLData, []grantedUser:
+	default:
+		if test {
+			break
+		}
 		http.Error(w, errInvalidUsersStruct.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -143,8 +130,9 @@ func ProccesQueryParams(w http.ResponseWriter, r *http.Request) (*SearchRequest,
 	if orderField == "" {
 		orderField = Name
 	}
-	if orderField != Name && orderField != ID && orderField != Age {
-		checkErr := SearchErrorResponse{Error: ErrorBadOrderField}
+	var orderErr *OrderError
+	if _, err := ParseOrder(orderField, OrderByAsc); errors.As(err, &orderErr) {
+		checkErr := SearchErrorResponse{Error: ErrorBadOrderField, Field: orderErr.Field, Message: orderErr.Message}
 		w.WriteHeader(http.StatusBadRequest)
 		err := json.NewEncoder(w).Encode(checkErr)
 		if err != nil {
@@ -175,7 +163,8 @@ func ProccesQueryParams(w http.ResponseWriter, r *http.Request) (*SearchRequest,
 	limit := LimitNotSet
 	if limitString != "" {
 		limit, err = strconv.Atoi(limitString)
-		if err != nil {
+		// -1 - то же, что без limit, остальные отрицательные не имеют смысла
+		if err != nil || limit < LimitNotSet {
 			checkErr := SearchErrorResponse{Error: errInvalidLimit.Error()}
 			w.WriteHeader(http.StatusBadRequest)
 			err = json.NewEncoder(w).Encode(checkErr)
@@ -190,7 +179,7 @@ func ProccesQueryParams(w http.ResponseWriter, r *http.Request) (*SearchRequest,
 	var offset int
 	if offsetString != "" {
 		offset, err = strconv.Atoi(offsetString)
-		if err != nil {
+		if err != nil || offset < 0 {
 			checkErr := SearchErrorResponse{Error: errInvalidOffset.Error()}
 			w.WriteHeader(http.StatusBadRequest)
 			err = json.NewEncoder(w).Encode(checkErr)
@@ -202,6 +191,7 @@ func ProccesQueryParams(w http.ResponseWriter, r *http.Request) (*SearchRequest,
 	}
 
 	query := r.URL.Query().Get("query")
+	cursor := r.URL.Query().Get("cursor")
 
 	result := SearchRequest{
 		Limit:      limit,
@@ -209,6 +199,7 @@ func ProccesQueryParams(w http.ResponseWriter, r *http.Request) (*SearchRequest,
 		Query:      query,
 		OrderField: orderField,
 		OrderBy:    order,
+		Cursor:     cursor,
 	}
 
 	return &result, nil
@@ -220,26 +211,71 @@ func SearchServer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, errAccessDenied.Error(), http.StatusUnauthorized)
 		return
 	}
+	grant, err := SearchAuth.Authenticate(token)
+	if err != nil {
+		http.Error(w, errAccessDenied.Error()+": "+err.Error(), http.StatusUnauthorized)
+		return
+	}
 
 	searchRequest, err := ProccesQueryParams(w, r)
 	if err != nil {
 		return
 	}
+	state, err := requestState(searchRequest)
+	if err == nil {
+		err = grant.check(searchRequest, state)
+	}
+	if err != nil {
+		SendSearchError(w, err)
+		return
+	}
 
-	xmlData, err := GetFileData(DataFileName)
+	index, err := searchIndex.get(DataFileName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	users := new(Users)
-	err = xml.Unmarshal(xmlData, &users)
+	var usersResult []UserXMLData
+	if searchRequest.Cursor == "" {
+		usersResult, err = ApplyQueryToUsers(searchRequest, index)
+	} else {
+		var nextCursor string
+		usersResult, nextCursor, err = ApplyCursorToUsers(searchRequest, index)
+		if nextCursor != "" {
+			w.Header().Set(NextCursorHeader, nextCursor)
+		}
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		SendSearchError(w, err)
 		return
 	}
 
-	usersResult := ApplyQueryToUsers(searchRequest, users)
+	SendResultToClient(w, grant.project(usersResult), false)
+}
 
-	SendResultToClient(w, usersResult, false)
+func SendSearchError(w http.ResponseWriter, err error) {
+	var queryErr *QueryError
+	var orderErr *OrderError
+	switch {
+	case errors.As(err, &queryErr):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchErrorResponse{ //nolint:errcheck
+			Error:    ErrorBadQuery,
+			Message:  queryErr.Message,
+			Field:    queryErr.Field,
+			Position: queryErr.Position,
+		})
+	case errors.As(err, &orderErr):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchErrorResponse{Error: ErrorBadOrderField, Field: orderErr.Field, Message: orderErr.Message}) //nolint:errcheck
+	case errors.Is(err, errCursorLimit):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchErrorResponse{Error: errInvalidLimit.Error(), Message: err.Error()}) //nolint:errcheck
+	case errors.Is(err, errBadCursor):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchErrorResponse{Error: ErrorBadCursor, Message: err.Error()}) //nolint:errcheck
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Подписанные значения - токены: JSON и его HMAC-SHA256, оба в base64, через точку.
+// Зашифрованные - курсоры: JSON, зашифрованный AES-256-GCM, вместе с nonce в base64;
+// их нельзя ни подделать, ни прочитать
+
+var (
+	errBadSignature = errors.New("неверная подпись")
+	errBadSealed    = errors.New("не удалось расшифровать")
+)
+
+func signJSON(secret []byte, value any) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signPayload(secret, payload)), nil
+}
+
+// verifyJSON проверяет подпись и разбирает JSON в value; любая ошибка - errBadSignature
+func verifyJSON(secret []byte, signed string, value any) error {
+	encodedPayload, encodedSignature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return errBadSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return errBadSignature
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signPayload(secret, payload)) {
+		return errBadSignature
+	}
+
+	if err := json.Unmarshal(payload, value); err != nil {
+		return errBadSignature
+	}
+
+	return nil
+}
+
+func signPayload(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func sealJSON(secret []byte, value any) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, payload, nil)), nil
+}
+
+// openJSON расшифровывает и разбирает JSON в value; любая ошибка - errBadSealed
+func openJSON(secret []byte, sealed string, value any) error {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return errBadSealed
+	}
+
+	payload, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return errBadSealed
+	}
+
+	if err := json.Unmarshal(payload, value); err != nil {
+		return errBadSealed
+	}
+
+	return nil
+}
+
+// newAEAD - AES-256-GCM с ключом из secret любой длины
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}